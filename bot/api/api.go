@@ -0,0 +1,103 @@
+// Package api serves a JSON API to manage the scheduled messages of a guild over HTTP, so the
+// website and scripts can queue announcements without Discord. Messages are checked like the
+// ones entered with /schedule, see package schedule.
+//
+// Every request needs the API token in an Authorization: Bearer header. The routes are
+//
+//	GET    /api/guilds/{guildID}/messages                  list messages, ?status=pending to filter
+//	POST   /api/guilds/{guildID}/messages                  create a message
+//	GET    /api/guilds/{guildID}/messages/{id}             get a message
+//	PUT    /api/guilds/{guildID}/messages/{id}             replace a pending or failed message
+//	DELETE /api/guilds/{guildID}/messages/{id}             cancel a message
+//	POST   /api/guilds/{guildID}/messages/{id}/trigger     post a message now
+//
+// Errors are answered with {"error": "..."} and a 4xx or 5xx status.
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/httpserver"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/schedule"
+	"github.com/betauia/BetaBot.go/bot/storage"
+)
+
+// maxBodySize limits request bodies, a message with an embed is well below it
+const maxBodySize = 64 << 10
+
+// Server serves the API
+type Server struct {
+	*httpserver.Server
+	store    storage.Store
+	channels schedule.ChannelLister
+	token    string
+	now      func() time.Time
+}
+
+// NewServer returns a server that will listen on addr, e.g. ":8080". Channels are looked up with
+// channels, usually the bot's *discordgo.Session, and requests must carry token.
+func NewServer(addr string, store storage.Store, channels schedule.ChannelLister, token string) *Server {
+	s := &Server{store: store, channels: channels, token: token, now: time.Now}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/guilds/{guildID}/messages", s.handleList)
+	mux.HandleFunc("POST /api/guilds/{guildID}/messages", s.handleCreate)
+	mux.HandleFunc("GET /api/guilds/{guildID}/messages/{id}", s.handleGet)
+	mux.HandleFunc("PUT /api/guilds/{guildID}/messages/{id}", s.handleUpdate)
+	mux.HandleFunc("DELETE /api/guilds/{guildID}/messages/{id}", s.handleCancel)
+	mux.HandleFunc("POST /api/guilds/{guildID}/messages/{id}/trigger", s.handleTrigger)
+
+	s.Server = httpserver.New("api", addr, s.authenticate(mux))
+	return s
+}
+
+// authenticate only lets requests with the API token through
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="betabot"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid API token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON answers with value as JSON
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Warn("Failed to write API response", logging.Err(err))
+	}
+}
+
+// writeError answers with an error message
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeInternalError logs an error that isn't the client's fault and answers with a 500
+func writeInternalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	slog.Error(message, slog.String("path", r.URL.Path), slog.String(logging.GuildID, r.PathValue("guildID")), logging.Err(err))
+	writeError(w, http.StatusInternalServerError, message)
+}
+
+// readJSON decodes the request body into value, answering with a 400 when it can't
+func readJSON(w http.ResponseWriter, r *http.Request, value any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
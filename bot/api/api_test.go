@@ -0,0 +1,321 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi/discordtest"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/schedule"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/betauia/BetaBot.go/bot/storage/storagetest"
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	testToken    = "secret-token"
+	otherGuildID = "200000000000000001"
+)
+
+// testNow is the time of every request, so relative times like "tomorrow" are predictable
+var testNow = time.Date(2025, time.January, 6, 10, 0, 0, 0, time.UTC)
+
+type testServer struct {
+	t       *testing.T
+	store   storage.Store
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	store := storagetest.OpenSQLite(t)
+	fake := discordtest.NewGuild()
+	fake.AddGuild(&discordgo.Guild{
+		ID:       otherGuildID,
+		Channels: []*discordgo.Channel{{ID: "200000000000000002", Name: "general", Type: discordgo.ChannelTypeGuildText}},
+	})
+
+	server := NewServer("127.0.0.1:0", store, fake, testToken)
+	server.now = func() time.Time { return testNow }
+	return &testServer{t: t, store: store, handler: server.Handler()}
+}
+
+// do sends a request with the API token and decodes the JSON answer into result, if not nil
+func (s *testServer) do(method, path string, body any, result any) int {
+	s.t.Helper()
+	return s.doWithHeader(method, path, "Bearer "+testToken, body, result)
+}
+
+func (s *testServer) doWithHeader(method, path, authorization string, body any, result any) int {
+	s.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	request := httptest.NewRequest(method, path, reader)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+
+	if result != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			s.t.Fatalf("%s %s: decoding %q: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+// create schedules a message through the API and fails the test if it isn't created
+func (s *testServer) create(request messageRequest) messageResponse {
+	s.t.Helper()
+	var created messageResponse
+	if status := s.do(http.MethodPost, messagesPath(discordtest.GuildID), request, &created); status != http.StatusCreated {
+		s.t.Fatalf("create returned %d, want 201", status)
+	}
+	return created
+}
+
+func messagesPath(guildID string) string {
+	return "/api/guilds/" + guildID + "/messages"
+}
+
+func messagePath(guildID string, id int64) string {
+	return fmt.Sprintf("/api/guilds/%s/messages/%d", guildID, id)
+}
+
+func validRequest(title string) messageRequest {
+	return messageRequest{
+		ChannelID: "#general",
+		Title:     title,
+		Message:   "See you {{date}}",
+		Time:      "07.01.2025 18:00",
+		AuthorID:  discordtest.UserID,
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "Basic " + testToken, http.StatusUnauthorized},
+		{"valid token", "Bearer " + testToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := s.doWithHeader(http.MethodGet, messagesPath(discordtest.GuildID), tt.authorization, nil, nil); status != tt.want {
+				t.Errorf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateGetAndList(t *testing.T) {
+	s := newTestServer(t)
+	created := s.create(validRequest("Weekly meeting"))
+	if created.ID == 0 || created.ChannelID != discordtest.ChannelID || created.Status != models.StatusPending {
+		t.Errorf("created %+v", created)
+	}
+	loc := storage.GuildLocation(s.store, discordtest.GuildID)
+	if want := time.Date(2025, time.January, 7, 18, 0, 0, 0, loc); !created.ScheduledTime.Equal(want) {
+		t.Errorf("scheduled for %v, want %v", created.ScheduledTime, want)
+	}
+
+	var got messageResponse
+	if status := s.do(http.MethodGet, messagePath(discordtest.GuildID, created.ID), nil, &got); status != http.StatusOK {
+		t.Fatalf("get returned %d", status)
+	}
+	if got.Title != "Weekly meeting" || got.Message != "See you {{date}}" || got.AuthorID != discordtest.UserID {
+		t.Errorf("get returned %+v", got)
+	}
+
+	// A second message, which is cancelled, to filter on
+	cancelled := s.create(validRequest("Cancelled one"))
+	if status := s.do(http.MethodDelete, messagePath(discordtest.GuildID, cancelled.ID), nil, nil); status != http.StatusOK {
+		t.Fatalf("cancel returned %d", status)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Weekly meeting", "Cancelled one"}},
+		{"?status=pending", []string{"Weekly meeting"}},
+		{"?status=cancelled", []string{"Cancelled one"}},
+		{"?status=sent", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var list []messageResponse
+			if status := s.do(http.MethodGet, messagesPath(discordtest.GuildID)+tt.query, nil, &list); status != http.StatusOK {
+				t.Fatalf("list returned %d", status)
+			}
+			var titles []string
+			for _, msg := range list {
+				titles = append(titles, msg.Title)
+			}
+			if strings.Join(titles, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("listed %q, want %q", titles, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s := newTestServer(t)
+	created := s.create(validRequest("Weekly meeting"))
+
+	update := validRequest("Board meeting")
+	update.Message = "Agenda attached"
+	var updated messageResponse
+	if status := s.do(http.MethodPut, messagePath(discordtest.GuildID, created.ID), update, &updated); status != http.StatusOK {
+		t.Fatalf("update returned %d", status)
+	}
+	if updated.Title != "Board meeting" || updated.Message != "Agenda attached" {
+		t.Errorf("updated %+v", updated)
+	}
+
+	// Once posted, a message can't be changed anymore
+	claimed, err := s.store.ScheduledMessages().ClaimDue(updated.ScheduledTime.Add(time.Minute), time.Minute, 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("claiming: %d messages, %v", len(claimed), err)
+	}
+	claimed[0].MarkSent("300000000000000001", testNow)
+	if err := s.store.ScheduledMessages().ReleaseLease(claimed[0]); err != nil {
+		t.Fatal(err)
+	}
+	var failure map[string]string
+	if status := s.do(http.MethodPut, messagePath(discordtest.GuildID, created.ID), update, &failure); status != http.StatusConflict {
+		t.Errorf("update of a sent message returned %d, want 409", status)
+	}
+	if !strings.Contains(failure["error"], "sent") {
+		t.Errorf("error %q doesn't say the message was sent", failure["error"])
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s := newTestServer(t)
+	created := s.create(validRequest("Weekly meeting"))
+
+	var cancelled messageResponse
+	if status := s.do(http.MethodDelete, messagePath(discordtest.GuildID, created.ID), nil, &cancelled); status != http.StatusOK {
+		t.Fatalf("cancel returned %d", status)
+	}
+	if cancelled.Status != models.StatusCancelled {
+		t.Errorf("status %s after cancelling", cancelled.Status)
+	}
+	if msg, _ := s.store.ScheduledMessages().GetByID(created.ID); msg == nil || msg.Status != models.StatusCancelled {
+		t.Errorf("stored message %+v, want it cancelled", msg)
+	}
+	if status := s.do(http.MethodDelete, messagePath(discordtest.GuildID, created.ID), nil, nil); status != http.StatusConflict {
+		t.Errorf("cancelling twice returned %d, want 409", status)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	s := newTestServer(t)
+	created := s.create(validRequest("Weekly meeting"))
+
+	var triggered messageResponse
+	if status := s.do(http.MethodPost, messagePath(discordtest.GuildID, created.ID)+"/trigger", nil, &triggered); status != http.StatusAccepted {
+		t.Fatalf("trigger returned %d, want 202", status)
+	}
+	if !triggered.ScheduledTime.Equal(testNow) {
+		t.Errorf("scheduled for %v after triggering, want now (%v)", triggered.ScheduledTime, testNow)
+	}
+	if msg, _ := s.store.ScheduledMessages().GetByID(created.ID); msg == nil || !msg.ScheduledTime.Equal(testNow) {
+		t.Errorf("stored message %+v, want it scheduled for now", msg)
+	}
+}
+
+func TestOtherGuildIsNotFound(t *testing.T) {
+	s := newTestServer(t)
+	created := s.create(validRequest("Weekly meeting"))
+
+	requests := []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodGet, messagePath(otherGuildID, created.ID), nil},
+		{http.MethodPut, messagePath(otherGuildID, created.ID), validRequest("Weekly meeting")},
+		{http.MethodDelete, messagePath(otherGuildID, created.ID), nil},
+		{http.MethodPost, messagePath(otherGuildID, created.ID) + "/trigger", nil},
+		{http.MethodGet, messagePath(discordtest.GuildID, created.ID+1), nil},
+	}
+	for _, r := range requests {
+		if status := s.do(r.method, r.path, r.body, nil); status != http.StatusNotFound {
+			t.Errorf("%s %s returned %d, want 404", r.method, r.path, status)
+		}
+	}
+	if msg, _ := s.store.ScheduledMessages().GetByID(created.ID); msg == nil || msg.Status != models.StatusPending {
+		t.Errorf("a request for another server changed the message: %+v", msg)
+	}
+}
+
+func TestCreateRejectsInvalidMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(r *messageRequest)
+		want   int
+		error  string
+	}{
+		{"unknown channel", func(r *messageRequest) { r.ChannelID = "#nowhere" }, http.StatusBadRequest, "nowhere"},
+		{"channel of another server", func(r *messageRequest) { r.ChannelID = "200000000000000002" }, http.StatusBadRequest, ""},
+		{"unknown placeholder", func(r *messageRequest) { r.Message = "Hi {{nope}}" }, http.StatusBadRequest, "{{nope}}"},
+		{"short title", func(r *messageRequest) { r.Title = "Hi" }, http.StatusBadRequest, "title"},
+		{"time in the past", func(r *messageRequest) { r.Time = "01.01.2025 18:00" }, http.StatusBadRequest, "future"},
+		{"empty message", func(r *messageRequest) { r.Message = "" }, http.StatusBadRequest, ""},
+		{"long message", func(r *messageRequest) { r.Message = strings.Repeat("a", schedule.MaxMessageLength+1) }, http.StatusBadRequest, "at most"},
+		{"bad mass mention", func(r *messageRequest) { r.MassMention = "nobody" }, http.StatusBadRequest, ""},
+		{"bad role ID", func(r *messageRequest) { r.RoleIDs = []string{"admins"} }, http.StatusBadRequest, "admins"},
+		{"title taken", func(r *messageRequest) { r.Title = "Existing one" }, http.StatusConflict, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.create(validRequest("Existing one"))
+
+			request := validRequest("Weekly meeting")
+			tt.change(&request)
+			var failure map[string]string
+			if status := s.do(http.MethodPost, messagesPath(discordtest.GuildID), request, &failure); status != tt.want {
+				t.Errorf("status %d (%s), want %d", status, failure["error"], tt.want)
+			}
+			if !strings.Contains(failure["error"], tt.error) {
+				t.Errorf("error %q doesn't mention %q", failure["error"], tt.error)
+			}
+			if list, _ := s.store.ScheduledMessages().ListByGuild(discordtest.GuildID); len(list) != 1 {
+				t.Errorf("%d messages stored, want only the existing one", len(list))
+			}
+		})
+	}
+}
+
+func TestInvalidBody(t *testing.T) {
+	s := newTestServer(t)
+	request := httptest.NewRequest(http.MethodPost, messagesPath(discordtest.GuildID), strings.NewReader(`{"title": "Weekly meeting", "colour": "red"}`))
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown field returned %d, want 400", recorder.Code)
+	}
+}
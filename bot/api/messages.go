@@ -0,0 +1,326 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/schedule"
+	"github.com/betauia/BetaBot.go/bot/scheduler"
+	"github.com/betauia/BetaBot.go/bot/storage"
+)
+
+// messageRequest is the body of a create or update. The fields take the same input as the
+// /schedule modal, e.g. "tomorrow 09:00" or RFC 3339 for the time.
+type messageRequest struct {
+	ChannelID   string          `json:"channel_id"` // ID, mention or name of a text channel
+	Title       string          `json:"title"`
+	Message     string          `json:"message"`
+	Time        string          `json:"time"`   // in the guild's timezone unless it has an offset
+	Repeat      string          `json:"repeat"` // e.g. "every Tuesday 18:00; until 31.12.2026"
+	Embed       json.RawMessage `json:"embed"`  // see package embeds
+	RoleIDs     []string        `json:"role_ids"`
+	UserIDs     []string        `json:"user_ids"`
+	MassMention string          `json:"mass_mention"` // "everyone", "here" or empty
+	AuthorID    string          `json:"author_id"`    // Discord user told when the message can't be posted, optional
+}
+
+// messageResponse is a scheduled message as returned by the API
+type messageResponse struct {
+	ID              int64                `json:"id"`
+	GuildID         string               `json:"guild_id"`
+	ChannelID       string               `json:"channel_id"`
+	Title           string               `json:"title"`
+	Message         string               `json:"message"`
+	Embed           json.RawMessage      `json:"embed,omitempty"`
+	ScheduledTime   time.Time            `json:"scheduled_time"`
+	Repeat          string               `json:"repeat,omitempty"`
+	RoleIDs         []string             `json:"role_ids,omitempty"`
+	UserIDs         []string             `json:"user_ids,omitempty"`
+	MassMention     models.MassMention   `json:"mass_mention,omitempty"`
+	AuthorID        string               `json:"author_id,omitempty"`
+	Status          models.MessageStatus `json:"status"`
+	Occurrences     int                  `json:"occurrences"`
+	Attempts        int                  `json:"attempts"`
+	LastError       string               `json:"last_error,omitempty"`
+	SentAt          *time.Time           `json:"sent_at,omitempty"`
+	PostedMessageID string               `json:"posted_message_id,omitempty"`
+}
+
+func (s *Server) newResponse(msg *models.ScheduledMessage) messageResponse {
+	response := messageResponse{
+		ID:              msg.ID,
+		GuildID:         msg.GuildID,
+		ChannelID:       msg.ChannelID,
+		Title:           msg.Title,
+		Message:         msg.Message,
+		ScheduledTime:   msg.ScheduledTime.UTC(),
+		RoleIDs:         msg.MentionRoleIDs(),
+		UserIDs:         msg.MentionUsers(),
+		MassMention:     msg.MassMention,
+		AuthorID:        msg.UserID,
+		Status:          msg.Status,
+		Occurrences:     msg.Occurrences,
+		Attempts:        msg.Attempts,
+		LastError:       msg.LastError,
+		PostedMessageID: msg.PostedMessageID,
+	}
+	if msg.Embed != "" {
+		response.Embed = json.RawMessage(msg.Embed)
+	}
+	if spec := schedule.Recurrence(msg, storage.GuildLocation(s.store, msg.GuildID)); spec != nil {
+		response.Repeat = spec.String()
+	}
+	if !msg.SentAt.IsZero() {
+		sentAt := msg.SentAt.UTC()
+		response.SentAt = &sentAt
+	}
+	return response
+}
+
+// handleList lists the messages of a guild, optionally only those with the given status
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	guildID, ok := pathGuildID(w, r)
+	if !ok {
+		return
+	}
+	messages, err := s.store.ScheduledMessages().ListByGuild(guildID)
+	if err != nil {
+		writeInternalError(w, r, "Failed to list scheduled messages", err)
+		return
+	}
+
+	status := models.MessageStatus(r.URL.Query().Get("status"))
+	responses := []messageResponse{}
+	for _, msg := range messages {
+		if status == "" || msg.Status == status {
+			responses = append(responses, s.newResponse(msg))
+		}
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// handleGet returns one message
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.lookupMessage(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.newResponse(msg))
+}
+
+// handleCreate schedules a new message
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	guildID, ok := pathGuildID(w, r)
+	if !ok {
+		return
+	}
+	var request messageRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	msg := &models.ScheduledMessage{GuildID: guildID, UserID: request.AuthorID}
+	if !s.applyRequest(w, r, msg, &request, false) {
+		return
+	}
+	if err := s.store.ScheduledMessages().Create(msg); err != nil {
+		writeInternalError(w, r, "Failed to save scheduled message", err)
+		return
+	}
+	scheduler.ScheduleMessage(msg)
+
+	slog.Info("Scheduled message created through the API", slog.String(logging.GuildID, guildID), slog.Int64(logging.ScheduledMessageID, msg.ID))
+	writeJSON(w, http.StatusCreated, s.newResponse(msg))
+}
+
+// handleUpdate replaces a pending or failed message, which reschedules a failed one
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.lookupMessage(w, r)
+	if !ok {
+		return
+	}
+	if !msg.IsActive() {
+		writeError(w, http.StatusConflict, fmt.Sprintf("scheduled message %d is already %s and can't be edited", msg.ID, msg.Status))
+		return
+	}
+	var request messageRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	attachments, err := s.store.Attachments().ListByMessage(msg.ID)
+	if err != nil {
+		writeInternalError(w, r, "Failed to load attachments", err)
+		return
+	}
+	if request.AuthorID != "" {
+		msg.UserID = request.AuthorID
+	}
+	if !s.applyRequest(w, r, msg, &request, len(attachments) > 0) {
+		return
+	}
+	msg.ResetDelivery()
+	if err := s.store.ScheduledMessages().Update(msg); errors.Is(err, models.ErrNotActive) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("scheduled message %d is being sent and can't be edited", msg.ID))
+		return
+	} else if err != nil {
+		writeInternalError(w, r, "Failed to update scheduled message", err)
+		return
+	}
+	scheduler.ScheduleMessage(msg)
+
+	slog.Info("Scheduled message updated through the API", slog.String(logging.GuildID, msg.GuildID), slog.Int64(logging.ScheduledMessageID, msg.ID))
+	writeJSON(w, http.StatusOK, s.newResponse(msg))
+}
+
+// handleCancel cancels a message, keeping it in the history
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.lookupMessage(w, r)
+	if !ok {
+		return
+	}
+	if !msg.IsActive() {
+		writeError(w, http.StatusConflict, fmt.Sprintf("scheduled message %d is already %s", msg.ID, msg.Status))
+		return
+	}
+	if err := s.store.ScheduledMessages().Cancel(msg); errors.Is(err, models.ErrNotActive) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("scheduled message %d is being sent and can't be cancelled", msg.ID))
+		return
+	} else if err != nil {
+		writeInternalError(w, r, "Failed to cancel scheduled message", err)
+		return
+	}
+	scheduler.ScheduleMessage(msg)
+
+	slog.Info("Scheduled message cancelled through the API", slog.String(logging.GuildID, msg.GuildID), slog.Int64(logging.ScheduledMessageID, msg.ID))
+	writeJSON(w, http.StatusOK, s.newResponse(msg))
+}
+
+// handleTrigger posts a message now instead of at its scheduled time. A recurring message
+// continues with the occurrence after now.
+func (s *Server) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.lookupMessage(w, r)
+	if !ok {
+		return
+	}
+	if !msg.IsActive() {
+		writeError(w, http.StatusConflict, fmt.Sprintf("scheduled message %d is already %s", msg.ID, msg.Status))
+		return
+	}
+
+	msg.ScheduledTime = s.now().UTC()
+	msg.ResetDelivery()
+	if err := s.store.ScheduledMessages().Update(msg); errors.Is(err, models.ErrNotActive) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("scheduled message %d is already being sent", msg.ID))
+		return
+	} else if err != nil {
+		writeInternalError(w, r, "Failed to trigger scheduled message", err)
+		return
+	}
+	scheduler.ScheduleMessage(msg)
+
+	slog.Info("Scheduled message triggered through the API", slog.String(logging.GuildID, msg.GuildID), slog.Int64(logging.ScheduledMessageID, msg.ID))
+	writeJSON(w, http.StatusAccepted, s.newResponse(msg))
+}
+
+// applyRequest checks a create or update request like /schedule does and copies it onto msg,
+// answering with a 400 or 409 when it can't be used
+func (s *Server) applyRequest(w http.ResponseWriter, r *http.Request, msg *models.ScheduledMessage, request *messageRequest, hasAttachment bool) bool {
+	embedJSON := string(request.Embed)
+	if embedJSON == "null" {
+		embedJSON = ""
+	}
+	parsed, err := schedule.Parse(schedule.Input{
+		Title:   request.Title,
+		Message: request.Message,
+		Time:    request.Time,
+		Repeat:  request.Repeat,
+		Embed:   embedJSON,
+	}, s.now(), storage.GuildLocation(s.store, msg.GuildID))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if err := schedule.CheckContent(parsed.Message, parsed.Embed, hasAttachment); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	mass := models.MassMention(request.MassMention)
+	if err := schedule.CheckMassMention(mass); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	for _, id := range append(append([]string{request.AuthorID}, request.RoleIDs...), request.UserIDs...) {
+		if id != "" && !isSnowflake(id) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%q is not a Discord ID", id))
+			return false
+		}
+	}
+
+	channelID, err := schedule.ResolveChannel(s.channels, msg.GuildID, request.ChannelID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if err := schedule.CheckTitle(s.store, msg.GuildID, parsed.Title, msg.ID); errors.Is(err, schedule.ErrTitleTaken) {
+		writeError(w, http.StatusConflict, err.Error())
+		return false
+	} else if err != nil {
+		writeInternalError(w, r, "Failed to check the title", err)
+		return false
+	}
+
+	parsed.Apply(msg)
+	msg.ChannelID = channelID
+	msg.SetMentions(request.RoleIDs, request.UserIDs, mass)
+	return true
+}
+
+// lookupMessage loads the message in the path, answering with a 404 if it isn't in the guild
+func (s *Server) lookupMessage(w http.ResponseWriter, r *http.Request) (*models.ScheduledMessage, bool) {
+	guildID, ok := pathGuildID(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%q is not a scheduled message ID", r.PathValue("id")))
+		return nil, false
+	}
+
+	msg, err := s.store.ScheduledMessages().GetByID(id)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && msg.GuildID != guildID) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no scheduled message with ID %d in this server", id))
+		return nil, false
+	}
+	if err != nil {
+		writeInternalError(w, r, "Failed to get scheduled message", err)
+		return nil, false
+	}
+	return msg, true
+}
+
+// pathGuildID returns the guild ID in the path, answering with a 400 if it isn't one
+func pathGuildID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	guildID := r.PathValue("guildID")
+	if !isSnowflake(guildID) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%q is not a server ID", guildID))
+		return "", false
+	}
+	return guildID, true
+}
+
+// isSnowflake reports whether id looks like a Discord ID
+func isSnowflake(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 64)
+	return err == nil
+}
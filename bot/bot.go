@@ -1,81 +1,148 @@
 package bot
 
 import (
-	"flag"
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/betauia/BetaBot.go/bot/api"
 	"github.com/betauia/BetaBot.go/bot/commands"
+	"github.com/betauia/BetaBot.go/bot/config"
+	"github.com/betauia/BetaBot.go/bot/dashboard"
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/metrics"
 	"github.com/betauia/BetaBot.go/bot/scheduler"
-	"github.com/betauia/BetaBot.go/bot/utils"
 	"github.com/bwmarrin/discordgo"
 )
 
-var BotToken string
-var RemoveCommands = flag.Bool("remove-command", true, "Remove all commands after shutting down or not")
+// gatewayGracePeriod is how long the gateway may be disconnected before /healthz fails and the bot
+// should be restarted. discordgo reconnects on its own, which can take a while during Discord outages.
+const gatewayGracePeriod = 5 * time.Minute
 
-func init() { flag.Parse() }
-
-func Run() {
-	if BotToken == "" {
-		log.Fatal("No bot token provided. Please set the BOT_TOKEN environment variable.")
+// Run starts the bot with a validated configuration and blocks until it is shut down
+func Run(cfg *config.Config) {
+	// Catch duplicate or unreachable command and custom IDs before anything is registered
+	if err := commands.Check(); err != nil {
+		slog.Error("Invalid commands", logging.Err(err))
+		os.Exit(1)
 	}
 
 	// Inject the database into the commands package
-	commands.SetDatabase(DB)
+	commands.SetStore(Store)
+	if err := commands.LoadGuildDebugLogging(); err != nil {
+		slog.Warn("Failed to load which servers turned on debug logs", logging.Err(err))
+	}
+
+	// Wrap every handler, logging and counting each interaction, and so one failing interaction can't take the bot down
+	commands.SetErrorChannel(cfg.AdminChannelID)
+	commands.Use(commands.StandardMiddleware...)
 
 	// Create a new Discord session using the provided bot token.
-	discord, err := discordgo.New("Bot " + BotToken)
-	utils.CheckNilErr(err)
-
-	// Add interaction handlers
-	discord.AddHandler(func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
-		switch interaction.Type {
-		case discordgo.InteractionApplicationCommand:
-			// Handle slash commands
-			handlers := commands.GetCommandHandlers()
-			if handler, exists := handlers[interaction.ApplicationCommandData().Name]; exists {
-				handler(session, interaction)
-			}
-		case discordgo.InteractionModalSubmit:
-			// Handle modal submissions
-			modalHandlers := commands.GetModalHandlers()
-			if handler, exists := modalHandlers[interaction.ModalSubmitData().CustomID]; exists {
-				handler(session, interaction)
-			}
-		case discordgo.InteractionMessageComponent:
-			// Handle button clicks and other components
-			componentHandlers := commands.GetComponentHandlers()
-			if handler, exists := componentHandlers[interaction.MessageComponentData().CustomID]; exists {
-				handler(session, interaction)
-			}
+	discord, err := discordgo.New("Bot " + cfg.BotToken)
+	if err != nil {
+		slog.Error("Failed to create Discord session", logging.Err(err))
+		os.Exit(1)
+	}
+
+	// Route interactions to the commands, see commands.Registry
+	discord.AddHandler(commands.Dispatch)
+
+	// Follow the gateway connection from before it is opened, for the metrics and health checks
+	gatewayCtx, stopGateway := context.WithCancel(context.Background())
+	defer stopGateway()
+	gateway := metrics.WatchGateway(gatewayCtx, discord)
+
+	// Serve metrics and health checks, readiness only turns ok once the gateway is connected
+	if cfg.MetricsAddr != "" {
+		server := metrics.NewServer(cfg.MetricsAddr)
+		server.AddLivenessCheck("database", Store.Ping)
+		server.AddLivenessCheck("gateway", gateway.ConnectedWithin(gatewayGracePeriod))
+		server.AddReadinessCheck("gateway", gateway.Connected)
+		if err := server.Start(); err != nil {
+			slog.Error("Failed to start metrics server", slog.String("addr", cfg.MetricsAddr), logging.Err(err))
+			os.Exit(1)
 		}
-	})
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
 
 	// open session
-	discord.Open()
-	utils.CheckNilErr(err)
+	if err := discord.Open(); err != nil {
+		slog.Error("Failed to connect to Discord", logging.Err(err))
+		os.Exit(1)
+	}
 	defer discord.Close() // close session, after function termination
 
-	// Register commands
-	commands.RegisterAllCommands(discord)
+	// Serve the admin API, channels are looked up through the session
+	if cfg.APIAddr != "" {
+		server := api.NewServer(cfg.APIAddr, Store, discord, cfg.APIToken)
+		if err := server.Start(); err != nil {
+			slog.Error("Failed to start API server", slog.String("addr", cfg.APIAddr), logging.Err(err))
+			os.Exit(1)
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
+
+	// Serve the web dashboard, people log in with the bot's Discord application
+	if cfg.DashboardAddr != "" {
+		auth := dashboard.NewDiscordOAuth(cfg.ClientID, cfg.ClientSecret, cfg.DashboardURL)
+		server := dashboard.NewServer(cfg.DashboardAddr, Store, discordapi.New(discord), auth, strings.HasPrefix(cfg.DashboardURL, "https://"))
+		if err := server.Start(); err != nil {
+			slog.Error("Failed to start dashboard server", slog.String("addr", cfg.DashboardAddr), logging.Err(err))
+			os.Exit(1)
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
+
+	// Register commands, in the development guild if one is configured
+	if err := commands.RegisterAllCommands(discord, cfg.DevGuildID); err != nil {
+		slog.Error("Failed to register commands", logging.Err(err))
+	}
+
+	// Stop on CTRL+C, or SIGTERM from Docker/systemd
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start scheduler for scheduled messages, periodically reconciling with the database
+	scheduler.Start(ctx, discordapi.New(discord), Store, cfg.ReconcileInterval)
 
-	// Start scheduler for scheduled messages
-	scheduler.Start(discord, DB, 30*time.Second)
+	// Delete drafts of multi-step commands that were abandoned
+	commands.StartDraftCleanup(ctx)
 
 	// Keep bot running until a termination signal is received
-	fmt.Println("Bot is now running. Press CTRL+C to exit.")
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	slog.Info("Bot is now running. Press CTRL+C to exit.", slog.String("version", config.Version))
+	<-ctx.Done()
+	stop() // a second signal terminates immediately
+
+	// Let messages that are being posted finish before the session is closed
+	slog.Info("Waiting for in-flight scheduled messages")
+	scheduler.Stop()
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelDrain()
+	if err := scheduler.Wait(drainCtx); err != nil {
+		slog.Warn("Scheduler did not stop in time", logging.Err(err))
+	}
 
 	// Remove commands if the flag is set
-	if *RemoveCommands {
+	if cfg.RemoveCommands {
 		commands.RemoveCommands(discord)
 	}
 
-	fmt.Println("Shutting down bot.")
+	slog.Info("Shutting down bot")
 }
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/bwmarrin/discordgo"
+)
+
+// downloadTimeout bounds how long fetching an uploaded file from Discord's CDN may take
+const downloadTimeout = 30 * time.Second
+
+// downloadAttachment fetches a file uploaded with a command so it can be stored until the
+// message is posted. Discord's CDN links expire, so the file can't be fetched at send time.
+func downloadAttachment(ctx context.Context, file *discordgo.MessageAttachment) (*models.Attachment, error) {
+	if file.Size > models.MaxAttachmentSize {
+		return nil, fmt.Errorf("%s is %s, the limit is %s", file.Filename, formatSize(file.Size), formatSize(models.MaxAttachmentSize))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", file.Filename, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: %s", file.Filename, resp.Status)
+	}
+
+	// Don't trust the reported size, read at most one byte more than allowed
+	data, err := io.ReadAll(io.LimitReader(resp.Body, models.MaxAttachmentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", file.Filename, err)
+	}
+	if len(data) > models.MaxAttachmentSize {
+		return nil, fmt.Errorf("%s is larger than %s", file.Filename, formatSize(models.MaxAttachmentSize))
+	}
+
+	return &models.Attachment{
+		Filename:    file.Filename,
+		ContentType: file.ContentType,
+		Data:        data,
+	}, nil
+}
+
+// formatSize formats a file size in bytes for display
+func formatSize(size int) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", size)
+	}
+}
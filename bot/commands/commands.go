@@ -1,36 +1,39 @@
 package commands
 
 import (
-	"database/sql"
 	"fmt"
 
-	"github.com/betauia/BetaBot.go/bot/utils"
+	"github.com/betauia/BetaBot.go/bot/config"
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/storage"
 	"github.com/bwmarrin/discordgo"
 )
 
-var db *sql.DB
+var store storage.Store
 
-func SetDatabase(database *sql.DB) {
-	db = database
+func SetStore(s storage.Store) {
+	store = s
 }
 
-var (
-	defaultMemberPermissions int64 = discordgo.PermissionManageGuild
-
-	commands = []*discordgo.ApplicationCommand{
-		{
-			Name:                     "ping",
-			Description:              "Replies with Pong!",
-			DefaultMemberPermissions: &defaultMemberPermissions,
-			Contexts:                 &[]discordgo.InteractionContextType{discordgo.InteractionContextGuild},
-			Version:                  "0.1.0",
-			Type:                     1,
+func init() {
+	register(&Module{
+		Command: &discordgo.ApplicationCommand{
+			Name:        "ping",
+			Description: "Replies with Pong!",
+			Contexts:    &[]discordgo.InteractionContextType{discordgo.InteractionContextGuild},
+			Version:     config.Version,
+			Type:        1,
 		},
-		{
-			Name:                     "add",
-			Description:              "Adds two numbers.",
-			DefaultMemberPermissions: &defaultMemberPermissions,
-			Contexts:                 &[]discordgo.InteractionContextType{discordgo.InteractionContextGuild},
+		Handle:      handlePingCommand,
+		Permissions: discordgo.PermissionManageGuild,
+	})
+
+	register(&Module{
+		Command: &discordgo.ApplicationCommand{
+			Name:        "add",
+			Description: "Adds two numbers.",
+			Contexts:    &[]discordgo.InteractionContextType{discordgo.InteractionContextGuild},
 			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Type:        discordgo.ApplicationCommandOptionInteger,
@@ -45,62 +48,17 @@ var (
 					Required:    true,
 				},
 			},
-			Version: "0.1.0",
+			Version: config.Version,
 			Type:    1,
 		},
-		scheduleCommand, // Add scheduleCommand
-	}
-
-	// Command Handlers - triggered by /commands
-	commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"ping":     handlePingCommand,
-		"add":      handleAddCommand,
-		"schedule": handleScheduleCommand,
-	}
-
-	// Modal handlers - triggered when modals are submitted
-	modalHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"schedule_add_modal": handleModalSubmit,
-	}
-
-	// Component handlers - triggered when buttons/select menus are clicked
-	componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"schedule_channel_select": handleChannelSelect,
-		"confirm_schedule":        handleScheduleConfirmation,
-		"cancel_schedule":         handleScheduleConfirmation,
-	}
-)
-
-func RegisterAllCommands(session *discordgo.Session) {
-	// Register the commands
-	for _, command := range commands {
-		_, err := session.ApplicationCommandCreate(session.State.User.ID, "", command)
-		utils.CheckNilErr(err)
-	}
-}
-
-func RemoveCommands(session *discordgo.Session) {
-	for _, cmd := range commands {
-		err := session.ApplicationCommandDelete(session.State.User.ID, "", cmd.ID)
-		utils.CheckNilErr(err)
-	}
-}
-
-func GetCommandHandlers() map[string]func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
-	return commandHandlers
-}
-
-func GetModalHandlers() map[string]func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
-	return modalHandlers
-}
-
-func GetComponentHandlers() map[string]func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
-	return componentHandlers
+		Handle:      handleAddCommand,
+		Permissions: discordgo.PermissionManageGuild,
+	})
 }
 
 // Command Handlers
 // Handler for the "ping" command
-func handlePingCommand(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+func handlePingCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
 	// Respond to the interaction with "Pong!"
 	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -108,11 +66,13 @@ func handlePingCommand(session *discordgo.Session, interaction *discordgo.Intera
 			Content: "Pong!",
 		},
 	})
-	utils.CheckNilErr(err)
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to respond to /ping", logging.Err(err))
+	}
 }
 
 // Handler for the "add" command
-func handleAddCommand(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+func handleAddCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
 	options := interaction.ApplicationCommandData().Options
 	if len(options) < 2 {
 		// Respond with an error message if not enough options are provided
@@ -122,7 +82,9 @@ func handleAddCommand(session *discordgo.Session, interaction *discordgo.Interac
 				Content: "Please provide two numbers to add.",
 			},
 		})
-		utils.CheckNilErr(err)
+		if err != nil {
+			interactionLogger(interaction).Warn("Failed to respond to /add", logging.Err(err))
+		}
 		return
 	}
 
@@ -140,5 +102,7 @@ func handleAddCommand(session *discordgo.Session, interaction *discordgo.Interac
 			Content: fmt.Sprintf("The sum of %d and %d is %d.", num1, num2, sum),
 		},
 	})
-	utils.CheckNilErr(err)
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to respond to /add", logging.Err(err))
+	}
 }
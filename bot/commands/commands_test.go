@@ -0,0 +1,264 @@
+package commands_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/betauia/BetaBot.go/bot/commands/commandstest"
+	"github.com/betauia/BetaBot.go/bot/discordapi/discordtest"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// otherUserID is a member who didn't write the messages the tests create
+const otherUserID = "100000000000000009"
+
+// step is one interaction of a flow in a golden file, with what the bot answered
+type step struct {
+	Step      string
+	Responses []*discordgo.InteractionResponse
+}
+
+// createMessage stores a pending message written by authorID
+func createMessage(t *testing.T, h *commandstest.Harness, title, authorID string) *models.ScheduledMessage {
+	t.Helper()
+	msg := &models.ScheduledMessage{
+		Title:         title,
+		GuildID:       h.GuildID,
+		UserID:        authorID,
+		Message:       "See you there",
+		ScheduledTime: time.Date(2030, time.March, 1, 12, 0, 0, 0, time.UTC),
+		ChannelID:     h.ChannelID,
+		Status:        models.StatusPending,
+	}
+	if err := h.Store.ScheduledMessages().Create(msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func getMessage(t *testing.T, h *commandstest.Harness, id int64) *models.ScheduledMessage {
+	t.Helper()
+	msg, err := h.Store.ScheduledMessages().GetByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// TestScheduleAdd is the flow from the commandstest package doc, with every answer in a golden file
+func TestScheduleAdd(t *testing.T) {
+	h := commandstest.New(t)
+
+	add := h.Command("schedule", commandstest.Subcommand("add"))
+	pick := h.Select(add.CustomID("schedule_channel_select:"), discordtest.OtherChannelID)
+	preview := h.Submit(pick.CustomID("schedule_add_modal:"),
+		commandstest.Field("title", "Meeting"),
+		commandstest.Field("message", "See you there, {{role:Members}}"),
+		commandstest.Field("time", "2030-01-02 18:00"),
+		commandstest.Field("repeat", "weekly"))
+	mentions := h.Select(preview.CustomID("schedule_mentions_select:"), discordtest.RoleID)
+	confirm := h.Click(mentions.CustomID("confirm_schedule:"))
+
+	commandstest.Golden(t, "schedule_add", []step{
+		{"/schedule add", add.Responses()},
+		{"pick the channel", pick.Responses()},
+		{"submit the modal", preview.Responses()},
+		{"pick the mentions", mentions.Responses()},
+		{"confirm", confirm.Responses()},
+	})
+
+	list := expectMessages(t, h, "Meeting")
+	msg := list[0]
+	loc := storage.GuildLocation(h.Store, h.GuildID)
+	if msg.ChannelID != discordtest.OtherChannelID || msg.UserID != discordtest.UserID || msg.Message != "See you there, {{role:Members}}" ||
+		!msg.ScheduledTime.Equal(time.Date(2030, time.January, 2, 18, 0, 0, 0, loc)) || msg.Recurrence != "weekly" ||
+		strings.Join(msg.MentionRoleIDs(), ",") != discordtest.RoleID || msg.Status != models.StatusPending {
+		t.Errorf("saved %+v", msg)
+	}
+}
+
+func TestScheduleEdit(t *testing.T) {
+	h := commandstest.New(t)
+	msg := createMessage(t, h, "Board meeting", discordtest.UserID)
+
+	edit := h.Command("schedule", commandstest.Subcommand("edit", commandstest.Int("id", msg.ID)))
+	modal := edit.Response()
+	if modal.Type != discordgo.InteractionResponseModal || !strings.Contains(fmt.Sprint(modal.Data.Components), "Board meeting") {
+		t.Fatalf("/schedule edit answered %+v, want a modal filled in with the message", modal.Data)
+	}
+
+	preview := h.Submit(edit.CustomID("schedule_edit_modal:"),
+		commandstest.Field("title", "Annual meeting"),
+		commandstest.Field("message", "The agenda is pinned"),
+		commandstest.Field("time", "03.04.2030 19:30"))
+	confirm := h.Click(preview.CustomID("confirm_schedule:"))
+	if content := confirm.Content(); content != fmt.Sprintf("✅ Scheduled message %d updated successfully.", msg.ID) {
+		t.Errorf("confirming answered %q", content)
+	}
+
+	expectMessages(t, h, "Annual meeting")
+	got := getMessage(t, h, msg.ID)
+	loc := storage.GuildLocation(h.Store, h.GuildID)
+	if got.Message != "The agenda is pinned" || !got.ScheduledTime.Equal(time.Date(2030, time.April, 3, 19, 30, 0, 0, loc)) ||
+		got.ChannelID != h.ChannelID || got.UserID != discordtest.UserID || got.Status != models.StatusPending {
+		t.Errorf("after editing: %+v", got)
+	}
+}
+
+func TestScheduleRemove(t *testing.T) {
+	tests := []struct {
+		name       string
+		button     string
+		wantAnswer string
+		wantStatus models.MessageStatus
+	}{
+		{"confirmed", "confirm_remove:", "🗑️ Scheduled message **Board meeting** (ID: 1) cancelled.", models.StatusCancelled},
+		{"cancelled", "cancel_remove:", "❌ Removal canceled.", models.StatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := commandstest.New(t)
+			msg := createMessage(t, h, "Board meeting", discordtest.UserID)
+
+			remove := h.Command("schedule", commandstest.Subcommand("remove", commandstest.Int("id", msg.ID)))
+			if content := remove.Content(); !strings.HasPrefix(content, "Are you sure you want to remove **Board meeting** (ID: 1)") {
+				t.Errorf("/schedule remove answered %q", content)
+			}
+			if got := getMessage(t, h, msg.ID); got.Status != models.StatusPending {
+				t.Fatalf("asking removed the message, status %s", got.Status)
+			}
+
+			answer := h.Click(remove.CustomID(tt.button))
+			if content := answer.Content(); content != tt.wantAnswer {
+				t.Errorf("the button answered %q, want %q", content, tt.wantAnswer)
+			}
+			if got := getMessage(t, h, msg.ID); got.Status != tt.wantStatus {
+				t.Errorf("status %s, want %s", got.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPermissionDenied(t *testing.T) {
+	tests := []struct {
+		name       string
+		use        func(h *commandstest.Harness, msg *models.ScheduledMessage) *commandstest.Result
+		wantAnswer string
+	}{
+		{
+			name: "/logging needs Manage Server",
+			use: func(h *commandstest.Harness, msg *models.ScheduledMessage) *commandstest.Result {
+				return h.Command("logging", commandstest.Bool("debug", true))
+			},
+			wantAnswer: "You don't have permission to do that.",
+		},
+		{
+			name: "editing another member's message",
+			use: func(h *commandstest.Harness, msg *models.ScheduledMessage) *commandstest.Result {
+				return h.Command("schedule", commandstest.Subcommand("edit", commandstest.Int("id", msg.ID)))
+			},
+			wantAnswer: "Only the author or members with Manage Server can edit this message.",
+		},
+		{
+			name: "removing another member's message",
+			use: func(h *commandstest.Harness, msg *models.ScheduledMessage) *commandstest.Result {
+				return h.Command("schedule", commandstest.Subcommand("remove", commandstest.Int("id", msg.ID)))
+			},
+			wantAnswer: "Only the author or members with Manage Server can remove this message.",
+		},
+		{
+			name: "confirming the removal of another member's message",
+			use: func(h *commandstest.Harness, msg *models.ScheduledMessage) *commandstest.Result {
+				return h.Click(fmt.Sprintf("confirm_remove:%d", msg.ID))
+			},
+			wantAnswer: "Only the author or members with Manage Server can remove this message.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := commandstest.New(t)
+			msg := createMessage(t, h, "Board meeting", otherUserID)
+			h.Member.Permissions = discordgo.PermissionSendMessages | discordgo.PermissionViewChannel
+
+			answer := tt.use(h, msg)
+			if content := answer.Content(); content != tt.wantAnswer {
+				t.Errorf("answered %q, want %q", content, tt.wantAnswer)
+			}
+			if flags := answer.Response().Data.Flags; flags&discordgo.MessageFlagsEphemeral == 0 {
+				t.Error("the denial isn't ephemeral")
+			}
+
+			// Nothing changed
+			if got := getMessage(t, h, msg.ID); got.Status != models.StatusPending || got.Title != "Board meeting" {
+				t.Errorf("the message changed: status %s, title %q", got.Status, got.Title)
+			}
+			settings, err := h.Store.GuildSettings().Get(h.GuildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings.DebugLogging {
+				t.Error("debug logging was turned on")
+			}
+		})
+	}
+}
+
+func TestScheduleHistory(t *testing.T) {
+	tests := []struct {
+		name       string
+		deliveries int
+		wantMore   bool
+	}{
+		{"none", 0, false},
+		{"a few", 3, false},
+		{"more than fit in a response", 15, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := commandstest.New(t)
+			for i := range tt.deliveries {
+				err := h.Store.Deliveries().Create(&models.Delivery{
+					ScheduledMessageID: int64(i + 1),
+					GuildID:            h.GuildID,
+					ChannelID:          h.ChannelID,
+					Title:              fmt.Sprintf("Weekly board meeting %04d", i),
+					PostedMessageID:    fmt.Sprintf("3000000000000000%02d", i),
+					Status:             models.StatusSent,
+					DeliveredAt:        commandstest.Clock.Add(time.Duration(i) * time.Hour),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			content := h.Command("schedule", commandstest.Subcommand("history")).Content()
+			if length := utf8.RuneCountInString(content); length > 2000 {
+				t.Errorf("the history is %d characters, Discord accepts at most 2000", length)
+			}
+			if tt.deliveries == 0 {
+				if content != "No scheduled messages have been delivered in this server yet." {
+					t.Errorf("empty history answered %q", content)
+				}
+				return
+			}
+
+			// Newest first, and a count of the ones left out
+			shown := strings.Count(content, "✅")
+			if !strings.Contains(content, fmt.Sprintf("Weekly board meeting %04d", tt.deliveries-1)) {
+				t.Error("the newest delivery isn't shown")
+			}
+			more := fmt.Sprintf("…and %d more", tt.deliveries-shown)
+			if tt.wantMore != strings.HasSuffix(content, more) {
+				t.Errorf("%d of %d deliveries shown, ending with %q", shown, tt.deliveries, content[strings.LastIndex(content, "\n")+1:])
+			}
+			if !tt.wantMore && shown != tt.deliveries {
+				t.Errorf("%d of %d deliveries shown", shown, tt.deliveries)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+// Package commandstest replays interactions through the bot's commands for end-to-end tests.
+// A Harness sends synthetic interactions to commands.Handle, the dispatcher bot.Run uses, with
+// the same middleware, a fresh SQLite store and a discordtest.Fake guild. Each step returns what
+// the bot sent back, and the custom IDs in it lead to the next step:
+//
+//	h := commandstest.New(t)
+//	add := h.Command("schedule", commandstest.Subcommand("add"))
+//	pick := h.Select(add.CustomID("schedule_channel_select:"), h.ChannelID)
+//	preview := h.Submit(pick.CustomID("schedule_add_modal:"),
+//		commandstest.Field("title", "Meeting"),
+//		commandstest.Field("message", "See you there"),
+//		commandstest.Field("time", "2030-01-02 18:00"))
+//	commandstest.Golden(t, "schedule_add_preview", preview.Responses())
+//	h.Click(preview.CustomID("confirm_schedule:"))
+//	messages, _ := h.Store.ScheduledMessages().ListByGuild(h.GuildID)
+//
+// See TestScheduleAdd in package commands for the whole flow.
+//
+// The commands package keeps its store in a package variable, so tests using a Harness can't
+// run in parallel.
+package commandstest
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/commands"
+	"github.com/betauia/BetaBot.go/bot/discordapi/discordtest"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/betauia/BetaBot.go/bot/storage/storagetest"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Clock is the time of the fake's message IDs and timestamps, so they are the same in every run
+var Clock = time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// middleware is added to the commands once, however many harnesses are created
+var middleware sync.Once
+
+// Harness sends interactions to the bot's commands as one member of one guild
+type Harness struct {
+	Store   storage.Store
+	Discord *discordtest.Fake
+
+	// GuildID, ChannelID and Member are where and by whom the interactions are used.
+	// Change Member.Permissions to try a command without them.
+	GuildID   string
+	ChannelID string
+	Member    *discordgo.Member
+
+	t        testing.TB
+	sequence int
+}
+
+// New returns a harness with an empty database and the guild of discordtest.NewGuild, where
+// the interactions are used in #general by the member who may manage the server
+func New(t *testing.T) *Harness {
+	t.Helper()
+	middleware.Do(func() { commands.Use(commands.StandardMiddleware...) })
+
+	store := storagetest.OpenSQLite(t)
+	commands.SetStore(store)
+
+	fake := discordtest.NewGuild()
+	fake.SetClock(func() time.Time { return Clock })
+	member, err := fake.GuildMember(discordtest.GuildID, discordtest.UserID)
+	if err != nil {
+		t.Fatalf("getting the member: %v", err)
+	}
+	fake.ClearCalls()
+
+	return &Harness{
+		Store:     store,
+		Discord:   fake,
+		GuildID:   discordtest.GuildID,
+		ChannelID: discordtest.ChannelID,
+		Member:    member,
+		t:         t,
+	}
+}
+
+// Command uses a slash command
+func (h *Harness) Command(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *Result {
+	h.t.Helper()
+	return h.Dispatch(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{
+		ID:          "cmd-" + name,
+		Name:        name,
+		CommandType: discordgo.ChatApplicationCommand,
+		Options:     options,
+	})
+}
+
+// Autocomplete asks a slash command for suggestions, one of the options should be Focused
+func (h *Harness) Autocomplete(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *Result {
+	h.t.Helper()
+	return h.Dispatch(discordgo.InteractionApplicationCommandAutocomplete, discordgo.ApplicationCommandInteractionData{
+		ID:          "cmd-" + name,
+		Name:        name,
+		CommandType: discordgo.ChatApplicationCommand,
+		Options:     options,
+	})
+}
+
+// Click clicks a button
+func (h *Harness) Click(customID string) *Result {
+	h.t.Helper()
+	return h.Dispatch(discordgo.InteractionMessageComponent, discordgo.MessageComponentInteractionData{
+		CustomID:      customID,
+		ComponentType: discordgo.ButtonComponent,
+	})
+}
+
+// Select picks values in a select menu. Values that are IDs of the guild's channels, roles or
+// members are resolved like Discord does for their select menus.
+func (h *Harness) Select(customID string, values ...string) *Result {
+	h.t.Helper()
+	data := discordgo.MessageComponentInteractionData{
+		CustomID:      customID,
+		ComponentType: discordgo.SelectMenuComponent,
+		Values:        values,
+	}
+	guild, err := h.Discord.LoadGuild(h.GuildID)
+	if err != nil {
+		h.t.Fatalf("loading the guild: %v", err)
+	}
+	for _, value := range values {
+		for _, channel := range guild.Channels {
+			if channel.ID == value {
+				resolved(&data).Channels[value] = channel
+			}
+		}
+		for _, role := range guild.Roles {
+			if role.ID == value {
+				resolved(&data).Roles[value] = role
+			}
+		}
+		for _, member := range guild.Members {
+			if member.User.ID == value {
+				resolved(&data).Members[value] = member
+				resolved(&data).Users[value] = member.User
+			}
+		}
+	}
+	return h.Dispatch(discordgo.InteractionMessageComponent, data)
+}
+
+// resolved returns the resolved data of a select menu, creating it on first use
+func resolved(data *discordgo.MessageComponentInteractionData) *discordgo.MessageComponentInteractionDataResolved {
+	if data.Resolved.Channels == nil {
+		data.Resolved = discordgo.MessageComponentInteractionDataResolved{
+			Channels: map[string]*discordgo.Channel{},
+			Roles:    map[string]*discordgo.Role{},
+			Members:  map[string]*discordgo.Member{},
+			Users:    map[string]*discordgo.User{},
+		}
+	}
+	return &data.Resolved
+}
+
+// Submit submits a modal with the text inputs filled in, each in its own row like the bot's modals
+func (h *Harness) Submit(customID string, fields ...*discordgo.TextInput) *Result {
+	h.t.Helper()
+	data := discordgo.ModalSubmitInteractionData{CustomID: customID}
+	for _, field := range fields {
+		data.Components = append(data.Components, &discordgo.ActionsRow{Components: []discordgo.MessageComponent{field}})
+	}
+	return h.Dispatch(discordgo.InteractionModalSubmit, data)
+}
+
+// Dispatch sends an interaction of any type with the given data, as the harness's member in its
+// channel, and returns the calls the bot made while handling it
+func (h *Harness) Dispatch(kind discordgo.InteractionType, data discordgo.InteractionData) *Result {
+	h.t.Helper()
+	h.sequence++
+	interaction := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        strconv.Itoa(200000000000000000 + h.sequence),
+		AppID:     h.Discord.BotUser.ID,
+		Type:      kind,
+		Data:      data,
+		GuildID:   h.GuildID,
+		ChannelID: h.ChannelID,
+		Member:    h.Member,
+		Token:     "token-" + strconv.Itoa(h.sequence),
+		Locale:    discordgo.EnglishUS,
+		Version:   1,
+	}}
+
+	before := len(h.Discord.Calls())
+	commands.Handle(h.Discord, interaction)
+	return &Result{Interaction: interaction, Calls: h.Discord.Calls()[before:], t: h.t}
+}
+
+// Subcommand is a subcommand option with its own options
+func Subcommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionSubCommand, Options: options}
+}
+
+// String is a string option
+func String(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+// Int is an integer option. Discord sends numbers as JSON, so the value is a float64 like in real interactions.
+func Int(name string, value int64) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionInteger, Value: float64(value)}
+}
+
+// Bool is a boolean option
+func Bool(name string, value bool) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionBoolean, Value: value}
+}
+
+// Focused is the string option being typed in during autocomplete
+func Focused(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	option := String(name, value)
+	option.Focused = true
+	return option
+}
+
+// Field is a text input of a submitted modal
+func Field(customID, value string) *discordgo.TextInput {
+	return &discordgo.TextInput{CustomID: customID, Value: value}
+}
+
+// Result is what the bot did while handling one interaction
+type Result struct {
+	Interaction *discordgo.InteractionCreate
+	// Calls are the calls made to the fake, including the ones for other interactions or
+	// channels such as scheduled messages posted right away. Calls from goroutines the
+	// handler started, like deleting the channel selector, may arrive later.
+	Calls []discordtest.Call
+
+	t testing.TB
+}
+
+// Responses returns the responses to the interaction, in order
+func (r *Result) Responses() []*discordgo.InteractionResponse {
+	var responses []*discordgo.InteractionResponse
+	for _, call := range r.Calls {
+		if call.Method == "InteractionRespond" && call.Args[0] == r.Interaction.ID {
+			responses = append(responses, call.Args[1].(*discordgo.InteractionResponse))
+		}
+	}
+	return responses
+}
+
+// Response returns the first response to the interaction, and fails the test if there was none
+func (r *Result) Response() *discordgo.InteractionResponse {
+	r.t.Helper()
+	responses := r.Responses()
+	if len(responses) == 0 {
+		r.t.Fatalf("no response to interaction %s, calls: %s", r.Interaction.ID, methods(r.Calls))
+	}
+	return responses[0]
+}
+
+// edits returns the edits of the interaction's response, in order
+func (r *Result) edits() []*discordgo.WebhookEdit {
+	var edits []*discordgo.WebhookEdit
+	for _, call := range r.Calls {
+		if call.Method == "InteractionResponseEdit" && call.Args[0] == r.Interaction.ID {
+			edits = append(edits, call.Args[1].(*discordgo.WebhookEdit))
+		}
+	}
+	return edits
+}
+
+// Content returns what the user sees as the answer to the interaction: the content of the last
+// edit of the response, or of the response itself. It is empty if the response was a modal.
+func (r *Result) Content() string {
+	r.t.Helper()
+	edits := r.edits()
+	for i := len(edits) - 1; i >= 0; i-- {
+		if edits[i].Content != nil {
+			return *edits[i].Content
+		}
+	}
+	if data := r.Response().Data; data != nil {
+		return data.Content
+	}
+	return ""
+}
+
+// CustomID returns the first custom ID starting with prefix in the responses, including the
+// modals and the components of edits, and fails the test if there is none. It finds the ID of
+// the next step of a multi-step command, e.g. CustomID("confirm_schedule:").
+func (r *Result) CustomID(prefix string) string {
+	r.t.Helper()
+	var found []string
+	for _, response := range r.Responses() {
+		if response.Data == nil {
+			continue
+		}
+		if response.Data.CustomID != "" {
+			found = append(found, response.Data.CustomID)
+		}
+		found = appendCustomIDs(found, response.Data.Components)
+	}
+	for _, edit := range r.edits() {
+		if edit.Components != nil {
+			found = appendCustomIDs(found, *edit.Components)
+		}
+	}
+
+	for _, customID := range found {
+		if strings.HasPrefix(customID, prefix) {
+			return customID
+		}
+	}
+	r.t.Fatalf("no custom ID starting with %q in the responses to interaction %s, found %q", prefix, r.Interaction.ID, found)
+	return ""
+}
+
+// appendCustomIDs adds the custom IDs of components and the components in them
+func appendCustomIDs(found []string, components []discordgo.MessageComponent) []string {
+	for _, component := range components {
+		switch c := component.(type) {
+		case *discordgo.ActionsRow:
+			found = appendCustomIDs(found, c.Components)
+		case discordgo.ActionsRow:
+			found = appendCustomIDs(found, c.Components)
+		case *discordgo.Button:
+			found = append(found, c.CustomID)
+		case discordgo.Button:
+			found = append(found, c.CustomID)
+		case *discordgo.SelectMenu:
+			found = append(found, c.CustomID)
+		case discordgo.SelectMenu:
+			found = append(found, c.CustomID)
+		case *discordgo.TextInput:
+			found = append(found, c.CustomID)
+		case discordgo.TextInput:
+			found = append(found, c.CustomID)
+		}
+	}
+	return found
+}
+
+// methods lists the methods of calls, for failure messages
+func methods(calls []discordtest.Call) string {
+	names := make([]string, len(calls))
+	for i, call := range calls {
+		names[i] = call.Method
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
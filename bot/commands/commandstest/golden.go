@@ -0,0 +1,71 @@
+package commandstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// updateEnv is the environment variable that rewrites the golden files with the current
+// output instead of comparing with them: UPDATE_GOLDEN=1 go test ./...
+const updateEnv = "UPDATE_GOLDEN"
+
+// draftID matches the IDs of drafts, which are random in every run
+var draftID = regexp.MustCompile(`\b[0-9a-f]{32}\b`)
+
+// Golden compares value, as indented JSON, with testdata/<name>.golden in the directory of the
+// test, and fails the test if they differ. Run the tests with UPDATE_GOLDEN=1 to write the files.
+// Draft IDs are replaced with <draft 1>, <draft 2> and so on, in the order they appear, so the
+// file still shows which steps continue the same draft. Pass Result.Calls to snapshot
+// everything the bot sent for an interaction.
+func Golden(t *testing.T, name string, value any) {
+	t.Helper()
+	// Mentions like <@&id> stay readable without HTML escaping
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		t.Fatalf("encoding %s: %v", name, err)
+	}
+	got := scrub(encoded.Bytes())
+
+	path := filepath.Join("testdata", name+".golden")
+	if os.Getenv(updateEnv) == "1" {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("%s doesn't exist, run the test with %s=1 to create it", path, updateEnv)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from %s, run the test with %s=1 if the change is intended\ngot:\n%s", name, path, updateEnv, got)
+	}
+}
+
+// scrub replaces the values that change between runs with stable placeholders
+func scrub(data []byte) []byte {
+	seen := map[string]string{}
+	return draftID.ReplaceAllFunc(data, func(id []byte) []byte {
+		placeholder, ok := seen[string(id)]
+		if !ok {
+			placeholder = fmt.Sprintf("<draft %d>", len(seen)+1)
+			seen[string(id)] = placeholder
+		}
+		return []byte(placeholder)
+	})
+}
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/dateparse"
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/schedule"
+	"github.com/betauia/BetaBot.go/bot/scheduler"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Handlers for the buttons in the DM the scheduler sends when a message could not be posted
+
+// handleDeadLetterReschedule opens a modal to pick a new time and channel for a failed message
+func handleDeadLetterReschedule(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	scheduledMsg, ok := lookupDeadLetter(session, interaction, interaction.MessageComponentData().CustomID)
+	if !ok {
+		return
+	}
+
+	channelValue := scheduledMsg.ChannelID
+	if channel, err := session.Channel(scheduledMsg.ChannelID); err == nil {
+		channelValue = "#" + channel.Name
+	}
+
+	modal := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: fmt.Sprintf("deadletter_reschedule_modal:%d", scheduledMsg.ID),
+			Title:    "Reschedule Message",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "time",
+							Label:       "New time",
+							Style:       discordgo.TextInputShort,
+							Placeholder: "e.g., 31.12.2025 16:12, tomorrow 09:00 or in 10m",
+							Required:    true,
+							MaxLength:   30,
+							MinLength:   1,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "channel",
+							Label:       "Channel (name or ID)",
+							Style:       discordgo.TextInputShort,
+							Placeholder: "#announcements",
+							Value:       channelValue,
+							Required:    true,
+							MaxLength:   100,
+							MinLength:   1,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := session.InteractionRespond(interaction.Interaction, modal); err != nil {
+		interactionLogger(interaction).Warn("Failed to send reschedule modal", logging.Err(err))
+	}
+}
+
+// handleDeadLetterRescheduleModal moves a failed message to the submitted time and channel
+func handleDeadLetterRescheduleModal(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.ModalSubmitData()
+	scheduledMsg, ok := lookupDeadLetter(session, interaction, data.CustomID)
+	if !ok {
+		return
+	}
+
+	timestr := modalValue(data, "time")
+	channelInput := modalValue(data, "channel")
+
+	loc := storage.GuildLocation(store, scheduledMsg.GuildID)
+	scheduledTime, err := dateparse.Parse(timestr, time.Now(), loc)
+	if errors.Is(err, dateparse.ErrInPast) {
+		respondWithError(session, interaction, "The new time must be in the future.")
+		return
+	}
+	if err != nil {
+		respondWithError(session, interaction, fmt.Sprintf("Invalid time format (%v).", err))
+		return
+	}
+
+	channelID, err := schedule.ResolveChannel(session, scheduledMsg.GuildID, channelInput)
+	if err != nil {
+		respondWithError(session, interaction, err.Error())
+		return
+	}
+
+	scheduledMsg.ScheduledTime = scheduledTime
+	scheduledMsg.ChannelID = channelID
+	scheduledMsg.ResetDelivery()
+	if err := store.ScheduledMessages().Update(scheduledMsg); err != nil {
+		respondWithInternalError(session, interaction, "Failed to reschedule message", err)
+		return
+	}
+	scheduler.ScheduleMessage(scheduledMsg)
+
+	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("✅ **%s** (ID: %d) rescheduled for %s in <#%s>.",
+				scheduledMsg.Title, scheduledMsg.ID, formatTime(scheduledTime, loc), channelID),
+			Components: []discordgo.MessageComponent{}, // Remove buttons
+		},
+	})
+}
+
+// handleDeadLetterCancel cancels a failed message
+func handleDeadLetterCancel(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	scheduledMsg, ok := lookupDeadLetter(session, interaction, interaction.MessageComponentData().CustomID)
+	if !ok {
+		return
+	}
+
+	if err := store.ScheduledMessages().Cancel(scheduledMsg); err != nil {
+		respondWithInternalError(session, interaction, "Failed to cancel message", err)
+		return
+	}
+	scheduler.ScheduleMessage(scheduledMsg)
+
+	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    fmt.Sprintf("🗑️ Scheduled message **%s** (ID: %d) cancelled.", scheduledMsg.Title, scheduledMsg.ID),
+			Components: []discordgo.MessageComponent{}, // Remove buttons
+		},
+	})
+}
+
+// lookupDeadLetter loads the failed message referenced by a "prefix:id" custom ID, responding with an error if
+// it can't be used. Only the author, who received the DM, may act on it.
+func lookupDeadLetter(session discordapi.Session, interaction *discordgo.InteractionCreate, customID string) (*models.ScheduledMessage, bool) {
+	_, idText, _ := strings.Cut(customID, ":")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		respondWithError(session, interaction, "Invalid button.")
+		return nil, false
+	}
+
+	scheduledMsg, err := store.ScheduledMessages().GetByID(id)
+	if err != nil || scheduledMsg.UserID != interactionUserID(interaction) {
+		respondWithError(session, interaction, "This scheduled message no longer exists.")
+		return nil, false
+	}
+
+	if scheduledMsg.Status != models.StatusFailed {
+		respondWithError(session, interaction, fmt.Sprintf("Scheduled message %d is %s and no longer needs attention.", scheduledMsg.ID, scheduledMsg.Status))
+		return nil, false
+	}
+
+	return scheduledMsg, true
+}
+
+// interactionUserID returns the ID of the user who triggered an interaction, in a guild or a DM
+func interactionUserID(interaction *discordgo.InteractionCreate) string {
+	if interaction.Member != nil && interaction.Member.User != nil {
+		return interaction.Member.User.ID
+	}
+	if interaction.User != nil {
+		return interaction.User.ID
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"log/slog"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/bwmarrin/discordgo"
+)
+
+func init() {
+	register(&Module{
+		Command: &discordgo.ApplicationCommand{
+			Name:        "logging",
+			Description: "Turn detailed bot logs for this server on or off, to help troubleshoot problems",
+			Contexts:    &[]discordgo.InteractionContextType{discordgo.InteractionContextGuild},
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "debug",
+					Description: "Log everything the bot does in this server",
+					Required:    true,
+				},
+			},
+		},
+		Handle:      handleLoggingCommand,
+		Permissions: discordgo.PermissionManageGuild,
+	})
+}
+
+// LoadGuildDebugLogging turns on debug logs for the guilds that turned them on with /logging
+func LoadGuildDebugLogging() error {
+	guildIDs, err := store.GuildSettings().ListDebugLogging()
+	if err != nil {
+		return err
+	}
+	for _, guildID := range guildIDs {
+		logging.SetGuildDebug(guildID, true)
+	}
+	return nil
+}
+
+// Handler for /logging - turns debug logs for the guild on or off
+func handleLoggingCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	options := interaction.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return
+	}
+	enabled := options[0].BoolValue()
+
+	settings, err := store.GuildSettings().Get(interaction.GuildID)
+	if err != nil {
+		respondWithInternalError(session, interaction, "Error getting settings from database", err)
+		return
+	}
+	settings.DebugLogging = enabled
+	if err := store.GuildSettings().Save(settings); err != nil {
+		respondWithInternalError(session, interaction, "Failed to save logging setting", err)
+		return
+	}
+	logging.SetGuildDebug(interaction.GuildID, enabled)
+	interactionLogger(interaction).Info("Changed debug logging", slog.Bool("debug", enabled))
+
+	if enabled {
+		respondWithSuccess(session, interaction, "🔍 Debug logs are on for this server. Turn them off again with `/logging debug:False` once the problem is found.")
+	} else {
+		respondWithSuccess(session, interaction, "✅ Debug logs are off for this server.")
+	}
+}
@@ -0,0 +1,261 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/metrics"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/bwmarrin/discordgo"
+)
+
+// reportInterval keeps a failing database from flooding the error channel with the same error
+const reportInterval = time.Minute
+
+// outcomes holds the outcome of each interaction that is being handled, by interaction ID, see Metrics
+var outcomes sync.Map
+
+// outcomeSeverity orders the outcomes, an interaction is counted with the worst one it had
+var outcomeSeverity = map[string]int{
+	metrics.OutcomeOK:            0,
+	metrics.OutcomeUserError:     1,
+	metrics.OutcomeInternalError: 2,
+	metrics.OutcomePanic:         3,
+}
+
+var errorReports struct {
+	sync.Mutex
+	channelID string
+	last      map[string]time.Time // error text -> when it was last reported
+}
+
+// SetErrorChannel sets the channel unexpected errors are reported in, empty to only log them
+func SetErrorChannel(channelID string) {
+	errorReports.Lock()
+	defer errorReports.Unlock()
+	errorReports.channelID = channelID
+	errorReports.last = map[string]time.Time{}
+}
+
+// Recover is middleware that turns a panic in a handler into an error message for the user
+// and a report for the admins, instead of crashing the bot
+func Recover(next HandlerFunc) HandlerFunc {
+	return func(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			setOutcome(interaction, metrics.OutcomePanic)
+			interactionLogger(interaction).Error("Panic in interaction handler", slog.Any("panic", recovered), slog.String("stack", string(debug.Stack())))
+			reportError(session, interaction, fmt.Errorf("panic: %v", recovered))
+			respondWithUnexpectedError(session, interaction)
+		}()
+		next(session, interaction)
+	}
+}
+
+// LogInteractions is middleware that logs every interaction and how long its handler took, at debug level
+func LogInteractions(next HandlerFunc) HandlerFunc {
+	return func(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+		logger := interactionLogger(interaction)
+		if !logger.Enabled(context.Background(), slog.LevelDebug) {
+			next(session, interaction)
+			return
+		}
+
+		logger.Debug("Handling interaction", slog.String("type", interaction.Type.String()))
+		start := time.Now()
+		defer func() {
+			logger.Debug("Handled interaction", slog.Duration("duration", time.Since(start)))
+		}()
+		next(session, interaction)
+	}
+}
+
+// Metrics is middleware that counts interactions by command and outcome, and measures how long
+// their handlers took. It has to be added before Recover to see panics.
+func Metrics(next HandlerFunc) HandlerFunc {
+	return func(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+		command, kind := interactionCommand(interaction), interactionKind(interaction)
+		outcomes.Store(interaction.ID, metrics.OutcomeOK)
+		start := time.Now()
+		defer func() {
+			outcome, _ := outcomes.LoadAndDelete(interaction.ID)
+			metrics.CommandDuration.WithLabelValues(command, kind).Observe(time.Since(start).Seconds())
+			metrics.CommandsHandled.WithLabelValues(command, kind, outcome.(string)).Inc()
+		}()
+		next(session, interaction)
+	}
+}
+
+// setOutcome records how handling an interaction went, unless it already went worse
+func setOutcome(interaction *discordgo.InteractionCreate, outcome string) {
+	current, tracked := outcomes.Load(interaction.ID)
+	if tracked && outcomeSeverity[outcome] > outcomeSeverity[current.(string)] {
+		outcomes.Store(interaction.ID, outcome)
+	}
+}
+
+// interactionCommand returns the name of the command an interaction belongs to, empty if there is none
+func interactionCommand(interaction *discordgo.InteractionCreate) string {
+	var rt route
+	switch interaction.Type {
+	case discordgo.InteractionApplicationCommand, discordgo.InteractionApplicationCommandAutocomplete:
+		return interaction.ApplicationCommandData().Name
+	case discordgo.InteractionMessageComponent:
+		rt, _ = lookupRoute(registry.components, interaction.MessageComponentData().CustomID)
+	case discordgo.InteractionModalSubmit:
+		rt, _ = lookupRoute(registry.modals, interaction.ModalSubmitData().CustomID)
+	}
+	if rt.command == nil {
+		return ""
+	}
+	return rt.command.Definition().Name
+}
+
+// interactionKind names the type of an interaction for the metrics
+func interactionKind(interaction *discordgo.InteractionCreate) string {
+	switch interaction.Type {
+	case discordgo.InteractionApplicationCommand:
+		return "command"
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		return "autocomplete"
+	case discordgo.InteractionMessageComponent:
+		return "component"
+	case discordgo.InteractionModalSubmit:
+		return "modal"
+	}
+	return "other"
+}
+
+// interactionLogger returns a logger with the attributes of an interaction, see package logging
+func interactionLogger(interaction *discordgo.InteractionCreate) *slog.Logger {
+	attrs := []any{slog.String(logging.InteractionID, interaction.ID)}
+	if interaction.GuildID != "" {
+		attrs = append(attrs, slog.String(logging.GuildID, interaction.GuildID))
+	}
+	if interaction.ChannelID != "" {
+		attrs = append(attrs, slog.String(logging.ChannelID, interaction.ChannelID))
+	}
+	if userID := interactionUserID(interaction); userID != "" {
+		attrs = append(attrs, slog.String(logging.UserID, userID))
+	}
+
+	if command := interactionCommand(interaction); command != "" {
+		attrs = append(attrs, slog.String(logging.Command, command))
+	}
+	switch interaction.Type {
+	case discordgo.InteractionMessageComponent:
+		attrs = append(attrs, slog.String(logging.CustomID, interaction.MessageComponentData().CustomID))
+	case discordgo.InteractionModalSubmit:
+		attrs = append(attrs, slog.String(logging.CustomID, interaction.ModalSubmitData().CustomID))
+	}
+	return slog.With(attrs...)
+}
+
+// respondWithUnexpectedError tells the user something went wrong, whether or not the handler responded already
+func respondWithUnexpectedError(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	const message = "❌ Something went wrong on our side. The admins have been notified, please try again later."
+	switch interaction.Type {
+	case discordgo.InteractionApplicationCommandAutocomplete, discordgo.InteractionPing:
+		return
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err == nil {
+		return
+	}
+
+	// The handler may have acknowledged the interaction before it failed
+	_, err = session.FollowupMessageCreate(interaction.Interaction, false, &discordgo.WebhookParams{
+		Content: message,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to tell the user about the error", logging.Err(err))
+	}
+}
+
+// respondWithInternalError shows the user an error that isn't their fault, and reports it
+func respondWithInternalError(session discordapi.Session, interaction *discordgo.InteractionCreate, message string, err error) {
+	if errors.Is(err, models.ErrNotActive) {
+		// The message was sent or cancelled in the meantime, which is no bug
+		respondWithError(session, interaction, fmt.Sprintf("%s: %v", message, err))
+		return
+	}
+
+	setOutcome(interaction, metrics.OutcomeInternalError)
+	interactionLogger(interaction).Error(message, logging.Err(err))
+	reportError(session, interaction, fmt.Errorf("%s: %w", message, err))
+	respondWithError(session, interaction, fmt.Sprintf("%s: %v", message, err))
+}
+
+// reportError posts an unexpected error to the error channel, if one is set. The same error is
+// reported at most once per reportInterval.
+func reportError(session discordapi.Session, interaction *discordgo.InteractionCreate, err error) {
+	errorReports.Lock()
+	channelID := errorReports.channelID
+	text := err.Error()
+	if channelID == "" || time.Since(errorReports.last[text]) < reportInterval {
+		errorReports.Unlock()
+		return
+	}
+	errorReports.last[text] = time.Now()
+	errorReports.Unlock()
+
+	report := fmt.Sprintf("⚠️ Error while handling %s:\n```\n%s\n```", describeInteraction(interaction), truncate(text, 1800))
+	_, sendErr := session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         report,
+		AllowedMentions: &discordgo.MessageAllowedMentions{}, // the report mentions the user without pinging them
+	})
+	if sendErr != nil {
+		interactionLogger(interaction).Warn("Failed to report error", slog.String("report_channel_id", channelID), logging.Err(errors.Join(sendErr, err)))
+	}
+}
+
+// describeInteraction names an interaction for logs and error reports, e.g.
+// "/schedule add by <@123> in guild 456"
+func describeInteraction(interaction *discordgo.InteractionCreate) string {
+	var what string
+	switch interaction.Type {
+	case discordgo.InteractionApplicationCommand, discordgo.InteractionApplicationCommandAutocomplete:
+		data := interaction.ApplicationCommandData()
+		what = "/" + data.Name
+		if len(data.Options) > 0 && data.Options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+			what += " " + data.Options[0].Name
+		}
+		if interaction.Type == discordgo.InteractionApplicationCommandAutocomplete {
+			what += " (autocomplete)"
+		}
+	case discordgo.InteractionMessageComponent:
+		what = fmt.Sprintf("component %q", interaction.MessageComponentData().CustomID)
+	case discordgo.InteractionModalSubmit:
+		what = fmt.Sprintf("modal %q", interaction.ModalSubmitData().CustomID)
+	default:
+		what = fmt.Sprintf("interaction type %d", interaction.Type)
+	}
+
+	if userID := interactionUserID(interaction); userID != "" {
+		what += fmt.Sprintf(" by <@%s>", userID)
+	}
+	if interaction.GuildID != "" {
+		what += " in guild " + interaction.GuildID
+	} else {
+		what += " in DMs"
+	}
+	return what
+}
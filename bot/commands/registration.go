@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/bwmarrin/discordgo"
+)
+
+// registered remembers where the commands were registered and the IDs Discord gave them,
+// which the definitions in the registry don't have
+var registered struct {
+	sync.Mutex
+	guildID string
+	ids     map[string]string // command name -> ID
+}
+
+// RegisterAllCommands makes the commands registered with Discord match the definitions.
+// With a guildID they are registered in that guild only, which takes effect immediately and
+// is meant for development; otherwise they are registered globally, which can take a while
+// to reach every server. Nothing is sent when the registered commands are already up to date.
+// Otherwise all commands are overwritten at once, which also removes stale commands.
+func RegisterAllCommands(session *discordgo.Session, guildID string) error {
+	appID := session.State.User.ID
+	scope := "globally"
+	if guildID != "" {
+		scope = "in guild " + guildID
+	}
+
+	existing, err := session.ApplicationCommands(appID, guildID)
+	if err != nil {
+		return fmt.Errorf("fetching registered commands: %w", err)
+	}
+
+	definitions := registry.Definitions()
+	changes := diffCommands(definitions, existing)
+	current := existing
+	if len(changes) == 0 {
+		slog.Info("Commands are up to date", slog.Int("count", len(existing)), slog.String("scope", scope))
+	} else {
+		current, err = session.ApplicationCommandBulkOverwrite(appID, guildID, definitions)
+		if err != nil {
+			return fmt.Errorf("registering commands: %w", err)
+		}
+		slog.Info("Registered commands", slog.Int("count", len(current)), slog.String("scope", scope), slog.String("changes", strings.Join(changes, ", ")))
+	}
+
+	registered.Lock()
+	defer registered.Unlock()
+	registered.guildID = guildID
+	registered.ids = map[string]string{}
+	for _, cmd := range current {
+		registered.ids[cmd.Name] = cmd.ID
+	}
+	return nil
+}
+
+// RemoveCommands deletes the commands registered by RegisterAllCommands
+func RemoveCommands(session *discordgo.Session) {
+	registered.Lock()
+	defer registered.Unlock()
+
+	for name, id := range registered.ids {
+		if err := session.ApplicationCommandDelete(session.State.User.ID, registered.guildID, id); err != nil {
+			slog.Warn("Failed to remove command", slog.String(logging.Command, name), logging.Err(err))
+			continue
+		}
+		delete(registered.ids, name)
+	}
+}
+
+// diffCommands describes how the registered commands differ from the definitions,
+// e.g. "added /template", "changed /schedule" or "removed /old". It is empty when they match.
+func diffCommands(definitions, existing []*discordgo.ApplicationCommand) []string {
+	byName := map[string]*discordgo.ApplicationCommand{}
+	for _, cmd := range existing {
+		byName[cmd.Name] = cmd
+	}
+
+	var changes []string
+	for _, cmd := range definitions {
+		current, ok := byName[cmd.Name]
+		delete(byName, cmd.Name)
+		switch {
+		case !ok:
+			changes = append(changes, "added /"+cmd.Name)
+		case !sameDefinition(cmd, current):
+			changes = append(changes, "changed /"+cmd.Name)
+		}
+	}
+	for name := range byName {
+		changes = append(changes, "removed /"+name)
+	}
+
+	sort.Strings(changes)
+	return changes
+}
+
+// sameDefinition compares a command definition with the command Discord has registered.
+// Discord fills in defaults and IDs, so only the fields the bot sets are compared.
+func sameDefinition(definition, registered *discordgo.ApplicationCommand) bool {
+	a, b := canonical(definition), canonical(registered)
+	if a.Contexts == nil || b.Contexts == nil {
+		// Not returned for every kind of command
+		a.Contexts, b.Contexts = nil, nil
+	}
+
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
+
+type canonicalCommand struct {
+	Type                     discordgo.ApplicationCommandType
+	Name                     string
+	Description              string
+	DefaultMemberPermissions *int64
+	NSFW                     bool
+	Contexts                 []discordgo.InteractionContextType
+	Options                  []*discordgo.ApplicationCommandOption
+}
+
+func canonical(cmd *discordgo.ApplicationCommand) canonicalCommand {
+	c := canonicalCommand{
+		Type:                     cmd.Type,
+		Name:                     cmd.Name,
+		Description:              cmd.Description,
+		DefaultMemberPermissions: cmd.DefaultMemberPermissions,
+		NSFW:                     cmd.NSFW != nil && *cmd.NSFW,
+		Options:                  canonicalOptions(cmd.Options),
+	}
+	if c.Type == 0 {
+		c.Type = discordgo.ChatApplicationCommand
+	}
+	if cmd.Contexts != nil {
+		c.Contexts = *cmd.Contexts
+	}
+	return c
+}
+
+// canonicalOptions copies options with empty lists normalized to nil
+func canonicalOptions(options []*discordgo.ApplicationCommandOption) []*discordgo.ApplicationCommandOption {
+	if len(options) == 0 {
+		return nil
+	}
+
+	copies := make([]*discordgo.ApplicationCommandOption, len(options))
+	for i, option := range options {
+		o := *option
+		o.Options = canonicalOptions(o.Options)
+		if len(o.ChannelTypes) == 0 {
+			o.ChannelTypes = nil
+		}
+		if len(o.Choices) == 0 {
+			o.Choices = nil
+		}
+		if len(o.NameLocalizations) == 0 {
+			o.NameLocalizations = nil
+		}
+		if len(o.DescriptionLocalizations) == 0 {
+			o.DescriptionLocalizations = nil
+		}
+		copies[i] = &o
+	}
+	return copies
+}
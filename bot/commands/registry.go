@@ -0,0 +1,274 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/bwmarrin/discordgo"
+)
+
+// HandlerFunc handles one interaction
+type HandlerFunc func(session discordapi.Session, interaction *discordgo.InteractionCreate)
+
+// Command is a slash command together with everything that handles its interactions.
+// Commands are added to the registry with register and routed to by Dispatch.
+type Command interface {
+	// Definition is the slash command registered with Discord
+	Definition() *discordgo.ApplicationCommand
+	// Handler runs when the command is used
+	Handler() HandlerFunc
+	// AutocompleteHandler suggests values for the options with Autocomplete set, nil if there are none
+	AutocompleteHandler() HandlerFunc
+	// ComponentHandlers handle the buttons and select menus the command sends, by custom ID.
+	// An ID ending with a colon, such as "deadletter_cancel:", matches every custom ID that
+	// starts with it, so data like a message ID can follow the colon.
+	ComponentHandlers() map[string]HandlerFunc
+	// ModalHandlers handle the modals the command opens, by custom ID like ComponentHandlers
+	ModalHandlers() map[string]HandlerFunc
+	// RequiredPermissions a member needs to use the command and its components, 0 for none
+	RequiredPermissions() int64
+}
+
+// Middleware wraps the handlers of every interaction, e.g. to recover from panics
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// StandardMiddleware is the middleware the bot runs its commands with, outermost first
+var StandardMiddleware = []Middleware{LogInteractions, Metrics, Recover}
+
+// Module is a Command declared as a struct literal
+type Module struct {
+	Command      *discordgo.ApplicationCommand
+	Handle       HandlerFunc
+	Autocomplete HandlerFunc
+	Components   map[string]HandlerFunc
+	Modals       map[string]HandlerFunc
+	Permissions  int64
+}
+
+func (m *Module) Definition() *discordgo.ApplicationCommand { return m.Command }
+func (m *Module) Handler() HandlerFunc                      { return m.Handle }
+func (m *Module) AutocompleteHandler() HandlerFunc          { return m.Autocomplete }
+func (m *Module) ComponentHandlers() map[string]HandlerFunc { return m.Components }
+func (m *Module) ModalHandlers() map[string]HandlerFunc     { return m.Modals }
+func (m *Module) RequiredPermissions() int64                { return m.Permissions }
+
+// Registry routes interactions to the commands registered in it
+type Registry struct {
+	commands   []Command
+	byName     map[string]Command
+	components map[string]route
+	modals     map[string]route
+	middleware []Middleware
+
+	// problems found while registering, reported by Check
+	problems []error
+}
+
+// route is a component or modal handler and the command it belongs to
+type route struct {
+	command Command
+	handler HandlerFunc
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		byName:     map[string]Command{},
+		components: map[string]route{},
+		modals:     map[string]route{},
+	}
+}
+
+// registry holds the bot's commands, each module registers itself in an init function
+var registry = NewRegistry()
+
+func register(cmd Command) {
+	registry.Register(cmd)
+}
+
+// Register adds a command. Mistakes such as a command name or custom ID that is already
+// taken don't stop the registration, they are collected and reported by Check.
+func (r *Registry) Register(cmd Command) {
+	definition := cmd.Definition()
+	if definition == nil || definition.Name == "" {
+		r.problems = append(r.problems, errors.New("command without a definition or name"))
+		return
+	}
+	name := definition.Name
+	if cmd.Handler() == nil {
+		r.problems = append(r.problems, fmt.Errorf("/%s has no handler", name))
+	}
+	if _, exists := r.byName[name]; exists {
+		r.problems = append(r.problems, fmt.Errorf("/%s is registered twice", name))
+		return
+	}
+
+	// Hide the command from members who couldn't use it anyway
+	if permissions := cmd.RequiredPermissions(); permissions != 0 && definition.DefaultMemberPermissions == nil {
+		definition.DefaultMemberPermissions = &permissions
+	}
+
+	hasAutocomplete := hasAutocompleteOption(definition.Options)
+	switch {
+	case hasAutocomplete && cmd.AutocompleteHandler() == nil:
+		r.problems = append(r.problems, fmt.Errorf("/%s has autocomplete options but no autocomplete handler", name))
+	case !hasAutocomplete && cmd.AutocompleteHandler() != nil:
+		r.problems = append(r.problems, fmt.Errorf("/%s has an autocomplete handler but no autocomplete options", name))
+	}
+
+	r.addRoutes(r.components, "component", cmd, cmd.ComponentHandlers())
+	r.addRoutes(r.modals, "modal", cmd, cmd.ModalHandlers())
+
+	r.commands = append(r.commands, cmd)
+	r.byName[name] = cmd
+}
+
+func (r *Registry) addRoutes(routes map[string]route, kind string, cmd Command, handlers map[string]HandlerFunc) {
+	name := cmd.Definition().Name
+	for _, customID := range slices.Sorted(maps.Keys(handlers)) {
+		handler := handlers[customID]
+		switch {
+		case customID == "":
+			r.problems = append(r.problems, fmt.Errorf("/%s has a %s handler without a custom ID", name, kind))
+			continue
+		case handler == nil:
+			r.problems = append(r.problems, fmt.Errorf("/%s has no handler for %s %q", name, kind, customID))
+			continue
+		case strings.HasSuffix(customID, ":") && strings.Count(customID, ":") > 1:
+			// lookup only tries the part up to the first colon as a prefix
+			r.problems = append(r.problems, fmt.Errorf("/%s: %s prefix %q can never match, prefixes can't contain another colon", name, kind, customID))
+			continue
+		}
+		if existing, taken := routes[customID]; taken {
+			r.problems = append(r.problems, fmt.Errorf("%s %q is handled by both /%s and /%s", kind, customID, existing.command.Definition().Name, name))
+			continue
+		}
+		routes[customID] = route{command: cmd, handler: handler}
+	}
+}
+
+func hasAutocompleteOption(options []*discordgo.ApplicationCommandOption) bool {
+	for _, option := range options {
+		if option.Autocomplete || hasAutocompleteOption(option.Options) {
+			return true
+		}
+	}
+	return false
+}
+
+// Use adds middleware around every handler. The first middleware added is the outermost.
+func (r *Registry) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Check reports every problem found while the commands were registered, such as duplicate
+// command names or custom IDs, and handlers nothing can reach. It is run at startup.
+func (r *Registry) Check() error {
+	return errors.Join(r.problems...)
+}
+
+// Definitions returns the slash commands to register with Discord, in registration order
+func (r *Registry) Definitions() []*discordgo.ApplicationCommand {
+	definitions := make([]*discordgo.ApplicationCommand, len(r.commands))
+	for i, cmd := range r.commands {
+		definitions[i] = cmd.Definition()
+	}
+	return definitions
+}
+
+// Dispatch routes an interaction to the handler of the command it belongs to. The bot's
+// registry is added to the Discord session as an event handler, see the package Dispatch.
+func (r *Registry) Dispatch(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	var (
+		cmd     Command
+		handler HandlerFunc
+	)
+	switch interaction.Type {
+	case discordgo.InteractionApplicationCommand:
+		// Slash commands
+		if cmd = r.byName[interaction.ApplicationCommandData().Name]; cmd != nil {
+			handler = cmd.Handler()
+		}
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		// Suggestions while an autocomplete option is typed
+		if cmd = r.byName[interaction.ApplicationCommandData().Name]; cmd != nil {
+			handler = cmd.AutocompleteHandler()
+		}
+	case discordgo.InteractionModalSubmit:
+		// Modal submissions
+		if rt, exists := lookupRoute(r.modals, interaction.ModalSubmitData().CustomID); exists {
+			cmd, handler = rt.command, rt.handler
+		}
+	case discordgo.InteractionMessageComponent:
+		// Button clicks and select menus
+		if rt, exists := lookupRoute(r.components, interaction.MessageComponentData().CustomID); exists {
+			cmd, handler = rt.command, rt.handler
+		}
+	}
+	if handler == nil {
+		return
+	}
+
+	next := checkPermissions(cmd.RequiredPermissions(), handler)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		next = r.middleware[i](next)
+	}
+	next(session, interaction)
+}
+
+// checkPermissions only runs handler for members with the required permissions. Discord already
+// hides commands from members without them, but the components of a message stay clickable for
+// everyone who can see it. Interactions in DMs have no member and are checked by the handlers themselves.
+func checkPermissions(required int64, handler HandlerFunc) HandlerFunc {
+	if required == 0 {
+		return handler
+	}
+	return func(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+		if interaction.Member != nil && interaction.Member.Permissions&required != required {
+			if interaction.Type != discordgo.InteractionApplicationCommandAutocomplete {
+				respondWithError(session, interaction, "You don't have permission to do that.")
+			}
+			return
+		}
+		handler(session, interaction)
+	}
+}
+
+// lookupRoute finds the handler for a custom ID. IDs carrying data, such as "deadletter_cancel:42",
+// are matched against handlers registered with their prefix, "deadletter_cancel:".
+func lookupRoute(routes map[string]route, customID string) (route, bool) {
+	if rt, exists := routes[customID]; exists {
+		return rt, true
+	}
+	if prefix, _, found := strings.Cut(customID, ":"); found {
+		rt, exists := routes[prefix+":"]
+		return rt, exists
+	}
+	return route{}, false
+}
+
+// Check reports problems with the bot's commands, see Registry.Check
+func Check() error {
+	return registry.Check()
+}
+
+// Use adds middleware around the handlers of the bot's commands, see Registry.Use
+func Use(middleware ...Middleware) {
+	registry.Use(middleware...)
+}
+
+// Dispatch routes an interaction to the bot's commands, see Registry.Dispatch. It has the
+// signature of a discordgo event handler.
+func Dispatch(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	Handle(discordapi.New(session), interaction)
+}
+
+// Handle routes an interaction to the bot's commands like Dispatch, on any Session, e.g. the
+// discordtest.Fake used by commandstest
+func Handle(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	registry.Dispatch(session, interaction)
+}
@@ -1,27 +1,67 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"sync"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/betauia/BetaBot.go/bot/dateparse"
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/embeds"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/metrics"
 	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/recurrence"
+	"github.com/betauia/BetaBot.go/bot/schedule"
+	"github.com/betauia/BetaBot.go/bot/scheduler"
+	"github.com/betauia/BetaBot.go/bot/storage"
 	"github.com/bwmarrin/discordgo"
 )
 
-// Temporary storage for pending scheduled messages
+// maxResponseLength is the most characters Discord accepts in the content of a response
+const maxResponseLength = 2000
+
+// PendingSchedule is the draft of /schedule add and /schedule edit, see scheduleFlow
 type PendingSchedule struct {
+	ID            int64 // set when editing an existing message, 0 for new ones
 	ChannelID     string
 	Title         string
 	Message       string
+	Embed         *embeds.Spec     // nil for plain messages
+	Attachment    *draftAttachment // file uploaded with /schedule add, nil if none
 	ScheduledTime time.Time
+	Recurrence    *recurrence.Spec // nil for one-off messages
+
+	// Who is pinged when the message is posted
+	RoleIDs     []string
+	UserIDs     []string
+	MassMention models.MassMention
 }
 
-var (
-	pendingSchedules = make(map[string]*PendingSchedule) // key: userID
-	pendingMutex     sync.RWMutex
-)
+// draftAttachment is a file uploaded with /schedule add. The file itself is stored once, owned
+// by the draft, so only what the preview shows is saved with every step.
+type draftAttachment struct {
+	ID       int64
+	Filename string
+	Size     int
+}
+
+// scheduleFlow keeps the PendingSchedule between the channel picker, the modal and the preview
+var scheduleFlow = flow[PendingSchedule]{name: "schedule", ttl: time.Hour}
+
+// scheduledMessageIDOption is the option used by subcommands that target an existing message
+var scheduledMessageIDOption = &discordgo.ApplicationCommandOption{
+	Type:         discordgo.ApplicationCommandOptionInteger,
+	Name:         "id",
+	Description:  "ID of the scheduled message (type to search by title)",
+	Required:     true,
+	Autocomplete: true,
+}
 
 // Define the schedule command
 var scheduleCommand = &discordgo.ApplicationCommand{
@@ -37,31 +77,101 @@ var scheduleCommand = &discordgo.ApplicationCommand{
 			Type:        discordgo.ApplicationCommandOptionSubCommand,
 			Name:        "add",
 			Description: "Add a new scheduled message",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "template",
+					Description:  "Start from a message template, see /template list",
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionAttachment,
+					Name:        "attachment",
+					Description: fmt.Sprintf("File to post with the message, up to %s", formatSize(models.MaxAttachmentSize)),
+				},
+			},
 		},
 		{
 			Type:        discordgo.ApplicationCommandOptionSubCommand,
 			Name:        "preview",
 			Description: "Preview a scheduled message by its ID",
+			Options:     []*discordgo.ApplicationCommandOption{scheduledMessageIDOption},
 		},
 		{
 			Type:        discordgo.ApplicationCommandOptionSubCommand,
 			Name:        "remove",
 			Description: "Remove a scheduled message by its ID",
+			Options:     []*discordgo.ApplicationCommandOption{scheduledMessageIDOption},
 		},
 		{
 			Type:        discordgo.ApplicationCommandOptionSubCommand,
 			Name:        "edit",
 			Description: "Edit a scheduled message by its ID",
+			Options: []*discordgo.ApplicationCommandOption{
+				scheduledMessageIDOption,
+				{
+					Type:        discordgo.ApplicationCommandOptionChannel,
+					Name:        "channel",
+					Description: "Move the message to another channel",
+					ChannelTypes: []discordgo.ChannelType{
+						discordgo.ChannelTypeGuildText,
+						discordgo.ChannelTypeGuildNews,
+					},
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "history",
+			Description: "List recently delivered scheduled messages for this server",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "timezone",
+			Description: "Show or set the timezone used for scheduled messages in this server",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "name",
+					Description:  "IANA timezone name, e.g. Europe/Oslo",
+					Autocomplete: true,
+				},
+			},
 		},
 	},
 }
 
-// Register function to create the "schedule" command
-func RegisterScheduleCommand(session *discordgo.Session) *discordgo.ApplicationCommand {
-	return scheduleCommand
+func init() {
+	register(&Module{
+		Command:      scheduleCommand,
+		Handle:       handleScheduleCommand,
+		Autocomplete: handleScheduleAutocomplete,
+		Components: map[string]HandlerFunc{
+			// The draft ID follows the colon
+			"schedule_channel_select:":      handleChannelSelect,
+			"schedule_mentions_select:":     handleMentionsSelect,
+			"schedule_mass_mention_select:": handleMassMentionSelect,
+			"confirm_schedule:":             handleScheduleConfirmation,
+			"cancel_schedule:":              handleScheduleConfirmation,
+
+			// The scheduled message ID follows the colon
+			"confirm_remove:": handleRemoveConfirmation,
+			"cancel_remove:":  handleRemoveConfirmation,
+
+			// Buttons in the scheduler's DM about a failed message, the message ID follows the colon
+			"deadletter_reschedule:": handleDeadLetterReschedule,
+			"deadletter_cancel:":     handleDeadLetterCancel,
+		},
+		Modals: map[string]HandlerFunc{
+			"schedule_add_modal:":  handleModalSubmit,
+			"schedule_edit_modal:": handleModalSubmit,
+
+			"deadletter_reschedule_modal:": handleDeadLetterRescheduleModal,
+		},
+	})
 }
 
-func handleScheduleCommand(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+func handleScheduleCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
 	options := interaction.ApplicationCommandData().Options
 	if len(options) == 0 {
 		return
@@ -73,11 +183,15 @@ func handleScheduleCommand(session *discordgo.Session, interaction *discordgo.In
 	case "list":
 		handleScheduleListCommand(session, interaction)
 	case "preview":
-		// TODO
+		handleSchedulePreviewCommand(session, interaction)
 	case "remove":
-		// TODO
+		handleScheduleRemoveCommand(session, interaction)
 	case "edit":
-		// TODO
+		handleScheduleEditCommand(session, interaction)
+	case "history":
+		handleScheduleHistoryCommand(session, interaction)
+	case "timezone":
+		handleScheduleTimezoneCommand(session, interaction)
 	}
 }
 
@@ -90,102 +204,128 @@ func handleScheduleCommand(session *discordgo.Session, interaction *discordgo.In
 */
 
 // Handle the "add" subcommand
-func handleScheduleAddCommand(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
-	// Show channel selector first
-	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: "Select a channel for the scheduled message:",
-			Flags:   discordgo.MessageFlagsEphemeral,
-			Components: []discordgo.MessageComponent{
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.SelectMenu{
-							CustomID:    "schedule_channel_select",
-							Placeholder: "Choose a channel",
-							MenuType:    discordgo.ChannelSelectMenu,
-							ChannelTypes: []discordgo.ChannelType{
-								discordgo.ChannelTypeGuildText,
-								discordgo.ChannelTypeGuildNews,
-							},
-						},
-					},
-				},
+func handleScheduleAddCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	// Start from a template if one was picked
+	draft := &PendingSchedule{}
+	if templateOpt := subcommandOption(interaction, "template"); templateOpt != nil {
+		template, err := store.Templates().GetByName(interaction.GuildID, strings.TrimSpace(templateOpt.StringValue()))
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(session, interaction, fmt.Sprintf("No template called %q in this server. See /template list.", templateOpt.StringValue()))
+			return
+		}
+		if err != nil {
+			respondWithInternalError(session, interaction, "Error getting template from database", err)
+			return
+		}
+		draft.Message = template.Content
+	}
+
+	attachmentOpt := subcommandOption(interaction, "attachment")
+	if attachmentOpt == nil {
+		d, err := scheduleFlow.start(interaction, draft)
+		if err != nil {
+			respondWithDraftError(session, interaction, err)
+			return
+		}
+
+		// Show channel selector first
+		err = session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content:    "Select a channel for the scheduled message:",
+				Flags:      discordgo.MessageFlagsEphemeral,
+				Components: scheduleChannelSelector(d),
 			},
-		},
+		})
+		if err != nil {
+			interactionLogger(interaction).Warn("Failed to send channel selector", logging.Err(err))
+		}
+		return
+	}
+
+	attachmentID, _ := attachmentOpt.Value.(string)
+	file := interaction.ApplicationCommandData().Resolved.Attachments[attachmentID]
+	if file == nil {
+		respondWithError(session, interaction, "Could not find the uploaded file. Please try again.")
+		return
+	}
+	if file.Size > models.MaxAttachmentSize {
+		respondWithError(session, interaction, fmt.Sprintf("%s is %s, files can be at most %s.", file.Filename, formatSize(file.Size), formatSize(models.MaxAttachmentSize)))
+		return
+	}
+
+	// Downloading may take longer than Discord waits for a response
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
 	})
 	if err != nil {
-		log.Printf("Failed to send channel selector: %v", err)
+		interactionLogger(interaction).Warn("Failed to defer schedule add response", logging.Err(err))
+		return
+	}
+
+	attachment, err := downloadAttachment(context.Background(), file)
+	if err != nil {
+		content := fmt.Sprintf("Failed to store the attachment: %v", err)
+		session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+
+	d, err := scheduleFlow.start(interaction, draft)
+	if err == nil {
+		attachment.DraftID = d.model.ID
+		err = store.Attachments().Create(attachment)
+	}
+	if err == nil {
+		d.State.Attachment = &draftAttachment{ID: attachment.ID, Filename: attachment.Filename, Size: attachment.Size()}
+		err = scheduleFlow.save(d)
+	}
+	if err != nil {
+		if d != nil {
+			scheduleFlow.finish(d)
+		}
+		content := fmt.Sprintf("Failed to save your progress: %v", err)
+		session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+
+	// Show channel selector first
+	content := fmt.Sprintf("📎 %s (%s) will be posted with the message.\nSelect a channel for the scheduled message:", attachment.Filename, formatSize(attachment.Size()))
+	channelSelector := scheduleChannelSelector(d)
+	_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Components: &channelSelector,
+	})
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to send channel selector", logging.Err(err))
 	}
 }
 
 // Handle channel selection and show modal
-func handleChannelSelect(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+func handleChannelSelect(session discordapi.Session, interaction *discordgo.InteractionCreate) {
 	data := interaction.MessageComponentData()
+	if len(data.Values) == 0 {
+		respondWithError(session, interaction, "Please choose a channel.")
+		return
+	}
 	selectedChannelID := data.Values[0]
 
-	// Store the channel ID temporarily
-	userID := interaction.Member.User.ID
-	pendingMutex.Lock()
-	if pendingSchedules[userID] == nil {
-		pendingSchedules[userID] = &PendingSchedule{}
+	// Store the channel in the draft, next to the template and file picked with /schedule add
+	d, err := scheduleFlow.update(interaction, data.CustomID, func(pending *PendingSchedule) error {
+		pending.ChannelID = selectedChannelID
+		return nil
+	})
+	if err != nil {
+		respondWithDraftError(session, interaction, err)
+		return
 	}
-	pendingSchedules[userID].ChannelID = selectedChannelID
-	pendingMutex.Unlock()
 
 	// Show modal without channel field
-	modal := &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseModal,
-		Data: &discordgo.InteractionResponseData{
-			CustomID: "schedule_add_modal",
-			Title:    "Add Scheduled Message",
-			Components: []discordgo.MessageComponent{
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.TextInput{
-							CustomID:    "time",
-							Label:       "Scheduled Time (Oslo timezone)",
-							Style:       discordgo.TextInputShort,
-							Placeholder: "e.g., 31.12.2025 16:12 or 28.02.2025",
-							Required:    true,
-							MaxLength:   30,
-							MinLength:   1,
-						},
-					},
-				},
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.TextInput{
-							CustomID:    "title",
-							Label:       "Title of the message (unique)",
-							Style:       discordgo.TextInputShort,
-							Placeholder: "Weekly Update (01.01.2025)",
-							Required:    true,
-							MaxLength:   25,
-							MinLength:   5,
-						},
-					},
-				},
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.TextInput{
-							CustomID:    "message",
-							Label:       "Message Content",
-							Style:       discordgo.TextInputParagraph,
-							Placeholder: "Enter your Discord Markdown message here...",
-							Required:    true,
-							MaxLength:   4000,
-							MinLength:   1,
-						},
-					},
-				},
-			},
-		},
-	}
+	modal := buildScheduleModal(d.customID("schedule_add_modal"), "Add Scheduled Message", d.State, storage.GuildLocation(store, interaction.GuildID))
 
-	err := session.InteractionRespond(interaction.Interaction, modal)
+	err = session.InteractionRespond(interaction.Interaction, modal)
 	if err != nil {
-		log.Printf("Failed to send modal: %v", err)
+		interactionLogger(interaction).Warn("Failed to send modal", logging.Err(err))
 	}
 
 	// Delete the original channel selector message after modal is shown
@@ -193,82 +333,126 @@ func handleChannelSelect(session *discordgo.Session, interaction *discordgo.Inte
 		time.Sleep(500 * time.Millisecond) // delay to make sure the modal is displayed
 		err := session.InteractionResponseDelete(interaction.Interaction)
 		if err != nil {
-			log.Printf("Failed to delete channel selector message: %v", err)
+			interactionLogger(interaction).Warn("Failed to delete channel selector message", logging.Err(err))
 		}
 	}()
 }
 
 // handle modal submit
-func handleModalSubmit(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+func handleModalSubmit(session discordapi.Session, interaction *discordgo.InteractionCreate) {
 	data := interaction.ModalSubmitData()
-	timestr := data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
-	title := data.Components[1].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
-	message := data.Components[2].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
-
-	// Validate time format
-	scheduledTime, err := parseScheduledTime(timestr)
+	loc := storage.GuildLocation(store, interaction.GuildID)
+	parsed, err := schedule.Parse(schedule.Input{
+		Title:   modalValue(data, "title"),
+		Message: modalValue(data, "message"),
+		Time:    modalValue(data, "time"),
+		Repeat:  modalValue(data, "repeat"),
+		Embed:   modalValue(data, "embed"),
+	}, time.Now(), loc)
 	if err != nil {
-		respondWithError(session, interaction, "Invalid time format. Use formats like 31.12.2025 16:12 or 28.02.2025")
+		respondWithError(session, interaction, err.Error())
 		return
 	}
 
-	// Retrieve the channel from pending schedule
-	userID := interaction.Member.User.ID
-	pendingMutex.Lock()
-	pending := pendingSchedules[userID]
-	if pending == nil {
-		pendingMutex.Unlock()
-		respondWithError(session, interaction, "Session expired. Please try again.")
+	// Update the draft started by /schedule add or /schedule edit
+	d, err := scheduleFlow.update(interaction, data.CustomID, func(pending *PendingSchedule) error {
+		if err := schedule.CheckContent(parsed.Message, parsed.Embed, pending.Attachment != nil); err != nil {
+			return err
+		}
+		pending.Title = parsed.Title
+		pending.Message = parsed.Message
+		pending.Embed = parsed.Embed
+		pending.ScheduledTime = parsed.ScheduledTime
+		pending.Recurrence = parsed.Recurrence
+		return nil
+	})
+	if errors.Is(err, schedule.ErrEmptyMessage) {
+		respondWithError(session, interaction, "The message needs content, an embed or an attachment.")
+		return
+	}
+	if err != nil {
+		respondWithDraftError(session, interaction, err)
 		return
 	}
-	channel := pending.ChannelID
-
-	// Update with new data
-	pending.Title = title
-	pending.Message = message
-	pending.ScheduledTime = scheduledTime
-	pendingMutex.Unlock()
 
 	// Show a preview of the message
-	preview := fmt.Sprintf("**Channel:** <#%s>\n**Time:** %s\n**Title:** %s\n**Message:**\n%s", channel, scheduledTime.Format("02.01.2006 15:04 MST"), title, message)
+	preview, err := buildSchedulePreview(session, interaction.GuildID, d, loc)
+	if err != nil {
+		respondWithError(session, interaction, fmt.Sprintf("The message can't be posted as written: %v", err))
+		return
+	}
 	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: preview,
-			Flags:   discordgo.MessageFlagsEphemeral,
-			Components: []discordgo.MessageComponent{
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.Button{
-							CustomID: "confirm_schedule",
-							Label:    "Confirm",
-							Style:    discordgo.PrimaryButton,
-						},
-						discordgo.Button{
-							CustomID: "cancel_schedule",
-							Label:    "Cancel",
-							Style:    discordgo.SecondaryButton,
-						},
-					},
-				},
-			},
-		},
+		Data: preview,
 	})
 }
 
-func handleScheduleConfirmation(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+// Handle the role and member picker on the schedule preview
+func handleMentionsSelect(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.MessageComponentData()
+	canMentionEveryone := interaction.Member.Permissions&discordgo.PermissionMentionEveryone != 0
+
+	var roleIDs, userIDs []string
+	for _, id := range data.Values {
+		if role, ok := data.Resolved.Roles[id]; ok {
+			if role.ID == interaction.GuildID {
+				respondWithError(session, interaction, "Use the @everyone / @here picker to ping everyone.")
+				return
+			}
+			// Discord only lets members with Mention Everyone ping roles that aren't mentionable
+			if !role.Mentionable && !canMentionEveryone {
+				respondWithError(session, interaction, fmt.Sprintf("The role **%s** can't be mentioned by you.", role.Name))
+				return
+			}
+			roleIDs = append(roleIDs, id)
+		} else {
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	updateSchedulePreview(session, interaction, func(pending *PendingSchedule) {
+		pending.RoleIDs = roleIDs
+		pending.UserIDs = userIDs
+	})
+}
+
+// Handle the @everyone / @here picker on the schedule preview
+func handleMassMentionSelect(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	mass := models.MentionNone
+	if values := interaction.MessageComponentData().Values; len(values) > 0 {
+		mass = models.MassMention(values[0])
+	}
+	if mass != models.MentionEveryone && mass != models.MentionHere {
+		mass = models.MentionNone
+	}
+
+	if mass != models.MentionNone && interaction.Member.Permissions&discordgo.PermissionMentionEveryone == 0 {
+		respondWithError(session, interaction, "Only members with Mention @everyone, @here and All Roles can ping everyone.")
+		return
+	}
+
+	updateSchedulePreview(session, interaction, func(pending *PendingSchedule) {
+		pending.MassMention = mass
+	})
+}
+
+func handleScheduleConfirmation(session discordapi.Session, interaction *discordgo.InteractionCreate) {
 	data := interaction.MessageComponentData()
 	userID := interaction.Member.User.ID
 
-	switch data.CustomID {
-	case "confirm_schedule":
-		// Retrieve the pending schedule from memory
-		pendingMutex.RLock()
-		pending, exists := pendingSchedules[userID]
-		pendingMutex.RUnlock()
+	// Cancelling an expired draft still removes the buttons
+	confirmed := strings.HasPrefix(data.CustomID, "confirm_schedule:")
+	d, err := scheduleFlow.load(interaction, data.CustomID)
+	if err != nil && confirmed {
+		respondWithDraftError(session, interaction, err)
+		return
+	}
 
-		if !exists {
-			respondWithError(session, interaction, "Session expired. Please try again.")
+	switch {
+	case confirmed:
+		pending := d.State
+		if pending.ID != 0 {
+			confirmScheduleEdit(session, interaction, d)
 			return
 		}
 
@@ -276,24 +460,39 @@ func handleScheduleConfirmation(session *discordgo.Session, interaction *discord
 		scheduledMsg := &models.ScheduledMessage{
 			Title:         pending.Title,
 			GuildID:       interaction.GuildID,
-			RoleID:        "",
 			UserID:        userID,
 			Message:       pending.Message,
+			Embed:         schedule.EmbedJSON(pending.Embed),
 			ScheduledTime: pending.ScheduledTime,
 			ChannelID:     pending.ChannelID,
 		}
+		scheduledMsg.SetMentions(pending.RoleIDs, pending.UserIDs, pending.MassMention)
+		schedule.ApplyRecurrence(scheduledMsg, pending.Recurrence)
 
 		// Save to database
-		err := scheduledMsg.Create(db)
+		if err := schedule.CheckTitle(store, interaction.GuildID, scheduledMsg.Title, 0); errors.Is(err, schedule.ErrTitleTaken) {
+			respondWithError(session, interaction, fmt.Sprintf("Another scheduled message is already called **%s**, please start over with another title.", scheduledMsg.Title))
+			return
+		} else if err != nil {
+			respondWithInternalError(session, interaction, "Failed to save scheduled message", err)
+			return
+		}
+		err := store.ScheduledMessages().Create(scheduledMsg)
 		if err != nil {
-			respondWithError(session, interaction, fmt.Sprintf("Failed to save scheduled message: %v", err))
+			respondWithInternalError(session, interaction, "Failed to save scheduled message", err)
 			return
 		}
+		if pending.Attachment != nil {
+			if err := store.Attachments().MoveToMessage(d.model.ID, scheduledMsg.ID); err != nil {
+				store.ScheduledMessages().Delete(scheduledMsg) // don't post the message without its file
+				respondWithInternalError(session, interaction, "Failed to save attachment", err)
+				return
+			}
+		}
+		scheduler.ScheduleMessage(scheduledMsg)
 
-		// Clean up the pending schedule
-		pendingMutex.Lock()
-		delete(pendingSchedules, userID)
-		pendingMutex.Unlock()
+		// Clean up the draft
+		scheduleFlow.finish(d)
 
 		// Update the message to remove buttons and show success
 		session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
@@ -304,17 +503,17 @@ func handleScheduleConfirmation(session *discordgo.Session, interaction *discord
 				Flags:      discordgo.MessageFlagsEphemeral,
 			},
 		})
-	case "cancel_schedule":
-		// Clean up the pending schedule
-		pendingMutex.Lock()
-		delete(pendingSchedules, userID)
-		pendingMutex.Unlock()
+	default:
+		// Clean up the draft
+		if d != nil {
+			scheduleFlow.finish(d)
+		}
 
 		// Update the message to remove buttons and show cancellation
 		session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseUpdateMessage,
 			Data: &discordgo.InteractionResponseData{
-				Content:    "❌ Schedule creation canceled.",
+				Content:    "❌ Schedule canceled.",
 				Components: []discordgo.MessageComponent{}, // Remove buttons
 				Flags:      discordgo.MessageFlagsEphemeral,
 			},
@@ -323,12 +522,12 @@ func handleScheduleConfirmation(session *discordgo.Session, interaction *discord
 }
 
 // Handler for /schedule list - Only shows messages in that guild
-func handleScheduleListCommand(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+func handleScheduleListCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
 	guildID := interaction.GuildID
 
-	messages, err := models.GetUpcomingMessagesByGuild(db, guildID)
+	messages, err := store.ScheduledMessages().ListUpcomingByGuild(guildID, time.Now())
 	if err != nil {
-		respondWithError(session, interaction, fmt.Sprintf("Error getting messages from database: %v", err))
+		respondWithInternalError(session, interaction, "Error getting messages from database", err)
 		return
 	}
 
@@ -337,17 +536,369 @@ func handleScheduleListCommand(session *discordgo.Session, interaction *discordg
 		return
 	}
 
+	loc := storage.GuildLocation(store, guildID)
 	response := fmt.Sprintf("**Upcoming Scheduled Messages (%d):**\n\n", len(messages))
 
 	for i, msg := range messages {
 		response += fmt.Sprintf("**%d. %s** (ID: %d)\n", i+1, msg.Title, msg.ID)
-		response += fmt.Sprintf("   📅 Scheduled: %s\n", msg.ScheduledTime.Format("02.01.2006 15:04 MST"))
+		response += fmt.Sprintf("   📅 Scheduled: %s\n", formatTime(msg.ScheduledTime, loc))
+		if msg.IsRecurring() {
+			response += fmt.Sprintf("   🔁 Repeats: %s\n", msg.Recurrence)
+		}
 		response += fmt.Sprintf("   📢 Channel: <#%s>\n\n", msg.ChannelID)
 	}
 
 	respondWithSuccess(session, interaction, response)
 }
 
+// confirmScheduleEdit persists the changes in a draft to an existing scheduled message
+func confirmScheduleEdit(session discordapi.Session, interaction *discordgo.InteractionCreate, d *draft[PendingSchedule]) {
+	pending := d.State
+	scheduledMsg, err := store.ScheduledMessages().GetByID(pending.ID)
+	if err != nil || scheduledMsg.GuildID != interaction.GuildID || !scheduledMsg.IsActive() {
+		respondWithError(session, interaction, "This scheduled message no longer exists.")
+		return
+	}
+
+	// Permissions may have changed since the edit was started
+	if !canManageScheduledMessage(interaction, scheduledMsg) {
+		respondWithError(session, interaction, "Only the author or members with Manage Server can edit this message.")
+		return
+	}
+
+	if err := schedule.CheckTitle(store, interaction.GuildID, pending.Title, scheduledMsg.ID); errors.Is(err, schedule.ErrTitleTaken) {
+		respondWithError(session, interaction, fmt.Sprintf("Another scheduled message is already called **%s**, please start over with another title.", pending.Title))
+		return
+	} else if err != nil {
+		respondWithInternalError(session, interaction, "Failed to update scheduled message", err)
+		return
+	}
+
+	scheduledMsg.Title = pending.Title
+	scheduledMsg.Message = pending.Message
+	scheduledMsg.Embed = schedule.EmbedJSON(pending.Embed)
+	scheduledMsg.ScheduledTime = pending.ScheduledTime
+	scheduledMsg.ChannelID = pending.ChannelID
+	scheduledMsg.SetMentions(pending.RoleIDs, pending.UserIDs, pending.MassMention)
+	schedule.ApplyRecurrence(scheduledMsg, pending.Recurrence)
+
+	// Editing a failed message reschedules it
+	scheduledMsg.ResetDelivery()
+
+	if err := store.ScheduledMessages().Update(scheduledMsg); err != nil {
+		respondWithInternalError(session, interaction, "Failed to update scheduled message", err)
+		return
+	}
+	scheduler.ScheduleMessage(scheduledMsg)
+	scheduleFlow.finish(d)
+
+	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    fmt.Sprintf("✅ Scheduled message %d updated successfully.", scheduledMsg.ID),
+			Components: []discordgo.MessageComponent{}, // Remove buttons
+			Flags:      discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// Handler for /schedule preview - shows the message exactly as the scheduler will post it
+func handleSchedulePreviewCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	scheduledMsg, ok := lookupScheduledMessage(session, interaction)
+	if !ok {
+		return
+	}
+
+	loc := storage.GuildLocation(store, interaction.GuildID)
+	header := fmt.Sprintf("**Preview of %s** (ID: %d)\n📅 Scheduled: %s\n📢 Channel: <#%s>\n",
+		scheduledMsg.Title, scheduledMsg.ID, formatTime(scheduledMsg.ScheduledTime, loc), scheduledMsg.ChannelID)
+	if scheduledMsg.IsRecurring() {
+		header += fmt.Sprintf("🔁 Repeats: %s\n", scheduledMsg.Recurrence)
+	}
+	if scheduledMsg.Status != models.StatusPending {
+		header += fmt.Sprintf("📌 Status: %s\n", scheduledMsg.Status)
+	}
+	header += "The message below is exactly what will be posted:"
+	respondWithSuccess(session, interaction, header)
+
+	content, err := scheduler.BuildMessageContent(scheduledMsg, scheduler.TemplateData(session, store, scheduledMsg))
+	if err != nil {
+		content = fmt.Sprintf("⚠️ This message can't be posted as written: %v", err)
+	}
+	messageEmbeds, err := scheduler.BuildMessageEmbeds(scheduledMsg)
+	if err != nil {
+		interactionLogger(interaction).Warn("Invalid embed", slog.Int64(logging.ScheduledMessageID, scheduledMsg.ID), logging.Err(err))
+	}
+	_, err = session.FollowupMessageCreate(interaction.Interaction, true, &discordgo.WebhookParams{
+		Content:         content,
+		Embeds:          messageEmbeds,
+		Flags:           discordgo.MessageFlagsEphemeral,
+		AllowedMentions: &discordgo.MessageAllowedMentions{}, // never ping from a preview
+	})
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to send preview", slog.Int64(logging.ScheduledMessageID, scheduledMsg.ID), logging.Err(err))
+	}
+}
+
+// Handler for /schedule remove - asks for confirmation before cancelling
+func handleScheduleRemoveCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	scheduledMsg, ok := lookupScheduledMessage(session, interaction)
+	if !ok {
+		return
+	}
+
+	if !scheduledMsg.IsActive() {
+		respondWithError(session, interaction, fmt.Sprintf("Scheduled message %d is already %s.", scheduledMsg.ID, scheduledMsg.Status))
+		return
+	}
+
+	if !canManageScheduledMessage(interaction, scheduledMsg) {
+		respondWithError(session, interaction, "Only the author or members with Manage Server can remove this message.")
+		return
+	}
+
+	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("Are you sure you want to remove **%s** (ID: %d) scheduled for %s?",
+				scheduledMsg.Title, scheduledMsg.ID, formatTime(scheduledMsg.ScheduledTime, storage.GuildLocation(store, interaction.GuildID))),
+			Flags: discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							CustomID: fmt.Sprintf("confirm_remove:%d", scheduledMsg.ID),
+							Label:    "Remove",
+							Style:    discordgo.DangerButton,
+						},
+						discordgo.Button{
+							CustomID: fmt.Sprintf("cancel_remove:%d", scheduledMsg.ID),
+							Label:    "Cancel",
+							Style:    discordgo.SecondaryButton,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func handleRemoveConfirmation(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	action, idStr, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+	content := "❌ Removal canceled."
+	if action == "confirm_remove" {
+		// Nothing is kept between the question and the answer, so check everything again
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			respondWithError(session, interaction, "Invalid scheduled message ID.")
+			return
+		}
+
+		scheduledMsg, err := store.ScheduledMessages().GetByID(id)
+		if err != nil || scheduledMsg.GuildID != interaction.GuildID || !scheduledMsg.IsActive() {
+			respondWithError(session, interaction, "This scheduled message no longer exists.")
+			return
+		}
+		if !canManageScheduledMessage(interaction, scheduledMsg) {
+			respondWithError(session, interaction, "Only the author or members with Manage Server can remove this message.")
+			return
+		}
+		if err := store.ScheduledMessages().Cancel(scheduledMsg); err != nil {
+			respondWithInternalError(session, interaction, "Failed to remove scheduled message", err)
+			return
+		}
+		scheduler.ScheduleMessage(scheduledMsg)
+		content = fmt.Sprintf("🗑️ Scheduled message **%s** (ID: %d) cancelled.", scheduledMsg.Title, scheduledMsg.ID)
+	}
+
+	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{}, // Remove buttons
+			Flags:      discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// Handler for /schedule edit - reopens the schedule modal pre-filled with the stored values
+func handleScheduleEditCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	scheduledMsg, ok := lookupScheduledMessage(session, interaction)
+	if !ok {
+		return
+	}
+
+	if !scheduledMsg.IsActive() {
+		respondWithError(session, interaction, fmt.Sprintf("Scheduled message %d is already %s and can't be edited.", scheduledMsg.ID, scheduledMsg.Status))
+		return
+	}
+
+	if !canManageScheduledMessage(interaction, scheduledMsg) {
+		respondWithError(session, interaction, "Only the author or members with Manage Server can edit this message.")
+		return
+	}
+
+	loc := storage.GuildLocation(store, interaction.GuildID)
+	pending := &PendingSchedule{
+		ID:            scheduledMsg.ID,
+		ChannelID:     scheduledMsg.ChannelID,
+		Title:         scheduledMsg.Title,
+		Message:       scheduledMsg.Message,
+		ScheduledTime: scheduledMsg.ScheduledTime,
+		RoleIDs:       scheduledMsg.MentionRoleIDs(),
+		UserIDs:       scheduledMsg.MentionUsers(),
+		MassMention:   scheduledMsg.MassMention,
+	}
+	if scheduledMsg.Embed != "" {
+		pending.Embed, _ = embeds.Parse(scheduledMsg.Embed) // validated when it was saved
+	}
+	pending.Recurrence = schedule.Recurrence(scheduledMsg, loc)
+	if channelOpt := subcommandOption(interaction, "channel"); channelOpt != nil {
+		if channelID, ok := channelOpt.Value.(string); ok {
+			pending.ChannelID = channelID
+		}
+	}
+
+	d, err := scheduleFlow.start(interaction, pending)
+	if err != nil {
+		respondWithDraftError(session, interaction, err)
+		return
+	}
+
+	modal := buildScheduleModal(d.customID("schedule_edit_modal"), fmt.Sprintf("Edit Scheduled Message %d", scheduledMsg.ID), pending, loc)
+	if err := session.InteractionRespond(interaction.Interaction, modal); err != nil {
+		interactionLogger(interaction).Warn("Failed to send edit modal", logging.Err(err))
+	}
+}
+
+// Handler for /schedule history - lists the most recent deliveries in the guild with jump links
+func handleScheduleHistoryCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	deliveries, err := store.Deliveries().ListRecentByGuild(interaction.GuildID, 15)
+	if err != nil {
+		respondWithInternalError(session, interaction, "Error getting history from database", err)
+		return
+	}
+
+	if len(deliveries) == 0 {
+		respondWithSuccess(session, interaction, "No scheduled messages have been delivered in this server yet.")
+		return
+	}
+
+	loc := storage.GuildLocation(store, interaction.GuildID)
+	response := fmt.Sprintf("**Recent Deliveries (%d):**\n\n", len(deliveries))
+
+	for i, delivery := range deliveries {
+		var line string
+		if delivery.Status == models.StatusSent {
+			line = fmt.Sprintf("✅ **%s** (ID: %d) - %s in <#%s> - [jump](%s)\n",
+				delivery.Title, delivery.ScheduledMessageID, formatTime(delivery.DeliveredAt, loc), delivery.ChannelID, delivery.JumpURL())
+		} else {
+			line = fmt.Sprintf("❌ **%s** (ID: %d) - %s in <#%s> - %s\n",
+				delivery.Title, delivery.ScheduledMessageID, formatTime(delivery.DeliveredAt, loc), delivery.ChannelID, truncate(delivery.Error, 100))
+		}
+
+		// Keep room for the line saying how many were left out
+		more := fmt.Sprintf("…and %d more", len(deliveries)-i)
+		if utf8.RuneCountInString(response+line+more) > maxResponseLength {
+			response += more
+			break
+		}
+		response += line
+	}
+
+	respondWithSuccess(session, interaction, response)
+}
+
+// Handler for /schedule timezone - shows or changes the guild's timezone
+func handleScheduleTimezoneCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	settings, err := store.GuildSettings().Get(interaction.GuildID)
+	if err != nil {
+		respondWithInternalError(session, interaction, "Error getting settings from database", err)
+		return
+	}
+
+	nameOpt := subcommandOption(interaction, "name")
+	if nameOpt == nil {
+		respondWithSuccess(session, interaction, fmt.Sprintf("🕒 Scheduled times in this server are interpreted in **%s** (now %s).",
+			settings.Location(), formatTime(time.Now(), settings.Location())))
+		return
+	}
+
+	if interaction.Member == nil || interaction.Member.Permissions&discordgo.PermissionManageGuild == 0 {
+		respondWithError(session, interaction, "Only members with Manage Server can change the timezone.")
+		return
+	}
+
+	loc, err := dateparse.LoadLocation(strings.TrimSpace(nameOpt.StringValue()))
+	if err != nil {
+		respondWithError(session, interaction, fmt.Sprintf("Unknown timezone %q. Use an IANA name such as Europe/Oslo.", nameOpt.StringValue()))
+		return
+	}
+
+	settings.Timezone = loc.String()
+	if err := store.GuildSettings().Save(settings); err != nil {
+		respondWithInternalError(session, interaction, "Failed to save timezone", err)
+		return
+	}
+
+	respondWithSuccess(session, interaction, fmt.Sprintf("✅ Timezone set to **%s** (now %s). Existing scheduled messages keep their absolute time.",
+		loc, formatTime(time.Now(), loc)))
+}
+
+// handleScheduleAutocomplete suggests scheduled messages in the guild matching the typed ID or title, or timezones
+func handleScheduleAutocomplete(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+
+	focused := focusedOption(interaction)
+	if focused != nil && focused.Name == "template" {
+		choices = templateChoices(interaction.GuildID, fmt.Sprint(focused.Value))
+	}
+
+	if focused != nil && focused.Name == "name" {
+		query := strings.ToLower(strings.TrimSpace(fmt.Sprint(focused.Value)))
+		for _, name := range dateparse.CommonTimezones {
+			if strings.Contains(strings.ToLower(name), query) {
+				choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+			}
+		}
+	}
+
+	if focused != nil && focused.Name == "id" {
+		query := strings.ToLower(strings.TrimSpace(fmt.Sprint(focused.Value)))
+
+		messages, err := store.ScheduledMessages().ListUpcomingByGuild(interaction.GuildID, time.Now())
+		if err != nil {
+			interactionLogger(interaction).Warn("Failed to load messages for autocomplete", logging.Err(err))
+		}
+		loc := storage.GuildLocation(store, interaction.GuildID)
+
+		for _, msg := range messages {
+			id := strconv.FormatInt(msg.ID, 10)
+			if query != "" && !strings.HasPrefix(id, query) && !strings.Contains(strings.ToLower(msg.Title), query) {
+				continue
+			}
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  fmt.Sprintf("#%d %s (%s)", msg.ID, msg.Title, msg.ScheduledTime.In(loc).Format("02.01.2006 15:04")),
+				Value: msg.ID,
+			})
+			if len(choices) == 25 { // Discord limit
+				break
+			}
+		}
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to send autocomplete choices", logging.Err(err))
+	}
+}
+
 /*
 #------------------------------#
 |                              |
@@ -355,45 +906,353 @@ func handleScheduleListCommand(session *discordgo.Session, interaction *discordg
 |                              |
 #------------------------------#
 */
-func respondWithSuccess(session *discordgo.Session, interaction *discordgo.InteractionCreate, message string) {
-	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+
+// scheduleChannelSelector asks for the channel of a new scheduled message, the first step of /schedule add
+func scheduleChannelSelector(d *draft[PendingSchedule]) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    d.customID("schedule_channel_select"),
+					Placeholder: "Choose a channel",
+					MenuType:    discordgo.ChannelSelectMenu,
+					ChannelTypes: []discordgo.ChannelType{
+						discordgo.ChannelTypeGuildText,
+						discordgo.ChannelTypeGuildNews,
+					},
+				},
+			},
+		},
+	}
+}
+
+// buildScheduleModal creates the schedule modal, pre-filled from pending when it is not nil.
+// Times are shown and entered in loc.
+func buildScheduleModal(customID, title string, pending *PendingSchedule, loc *time.Location) *discordgo.InteractionResponse {
+	timeLabel := fmt.Sprintf("Scheduled Time (%s)", loc)
+	if len(timeLabel) > 45 { // Discord limit for labels
+		timeLabel = fmt.Sprintf("Time (%s)", loc)
+	}
+
+	var timeValue, titleValue, messageValue, repeatValue, embedValue string
+	if pending != nil {
+		if !pending.ScheduledTime.IsZero() {
+			timeValue = pending.ScheduledTime.In(loc).Format("02.01.2006 15:04")
+		}
+		titleValue = pending.Title
+		messageValue = pending.Message
+		if pending.Recurrence != nil {
+			repeatValue = pending.Recurrence.String()
+		}
+		embedValue = schedule.EmbedJSON(pending.Embed)
+	}
+
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: customID,
+			Title:    title,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "time",
+							Label:       timeLabel,
+							Style:       discordgo.TextInputShort,
+							Placeholder: "e.g., 31.12.2025 16:12, tomorrow 09:00 or in 2h",
+							Value:       timeValue,
+							Required:    true,
+							MaxLength:   30,
+							MinLength:   1,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "title",
+							Label:       "Title of the message (unique)",
+							Style:       discordgo.TextInputShort,
+							Placeholder: "Weekly Update (01.01.2025)",
+							Value:       titleValue,
+							Required:    true,
+							MaxLength:   schedule.MaxTitleLength,
+							MinLength:   schedule.MinTitleLength,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "message",
+							Label:       "Message Content",
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: "Enter your Discord Markdown message here...",
+							Value:       messageValue,
+							Required:    false, // may be left empty when the message has an embed
+							MaxLength:   schedule.MaxMessageLength,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "repeat",
+							Label:       "Repeat (optional)",
+							Style:       discordgo.TextInputShort,
+							Placeholder: "e.g., every Tuesday 18:00; until 31.12.2026",
+							Value:       repeatValue,
+							Required:    false,
+							MaxLength:   schedule.MaxRepeatLength,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "embed",
+							Label:       "Embed as JSON (optional)",
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: `{"title": "Weekly meeting", "description": "See you there!", "color": "#5865F2", "timestamp": true}`,
+							Value:       embedValue,
+							Required:    false,
+							MaxLength:   schedule.MaxEmbedLength,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// buildSchedulePreview shows the drafted message as it will be posted, with pickers for who to
+// ping and buttons to confirm or cancel
+func buildSchedulePreview(session discordapi.Session, guildID string, d *draft[PendingSchedule], loc *time.Location) (*discordgo.InteractionResponseData, error) {
+	pending := d.State
+	draft := &models.ScheduledMessage{
+		GuildID:       guildID,
+		Title:         pending.Title,
+		Message:       pending.Message,
+		Embed:         schedule.EmbedJSON(pending.Embed),
+		ScheduledTime: pending.ScheduledTime,
+		ChannelID:     pending.ChannelID,
+	}
+	draft.SetMentions(pending.RoleIDs, pending.UserIDs, pending.MassMention)
+	content, err := scheduler.BuildMessageContent(draft, scheduler.TemplateData(session, store, draft))
+	if err != nil {
+		return nil, err
+	}
+	messageEmbeds, _ := scheduler.BuildMessageEmbeds(draft) // validated in handleModalSubmit
+
+	repeats := "Never"
+	if pending.Recurrence != nil {
+		repeats = pending.Recurrence.String()
+		if rule, err := recurrence.Parse(pending.Recurrence.Rule); err == nil {
+			repeats += fmt.Sprintf(" (then %s)", formatTime(rule.Next(pending.ScheduledTime.In(loc)), loc))
+		}
+	}
+	preview := fmt.Sprintf("**Channel:** <#%s>\n**Time:** %s\n**Repeats:** %s\n**Title:** %s\n**Message:**\n%s",
+		pending.ChannelID, formatTime(pending.ScheduledTime, loc), repeats, pending.Title, content)
+	if pending.Attachment != nil {
+		preview += fmt.Sprintf("\n📎 %s (%s)", pending.Attachment.Filename, formatSize(pending.Attachment.Size))
+	}
+	if pending.ID != 0 {
+		preview = fmt.Sprintf("**Editing ID:** %d\n%s", pending.ID, preview)
+	}
+
+	var defaultMentions []discordgo.SelectMenuDefaultValue
+	for _, roleID := range pending.RoleIDs {
+		defaultMentions = append(defaultMentions, discordgo.SelectMenuDefaultValue{ID: roleID, Type: discordgo.SelectMenuDefaultValueRole})
+	}
+	for _, userID := range pending.UserIDs {
+		defaultMentions = append(defaultMentions, discordgo.SelectMenuDefaultValue{ID: userID, Type: discordgo.SelectMenuDefaultValueUser})
+	}
+	minMentions := 0
+
+	return &discordgo.InteractionResponseData{
+		Content:         preview,
+		Embeds:          messageEmbeds,
+		Flags:           discordgo.MessageFlagsEphemeral,
+		AllowedMentions: &discordgo.MessageAllowedMentions{}, // never ping from a preview
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						CustomID:      d.customID("schedule_mentions_select"),
+						Placeholder:   "Roles or members to ping (optional)",
+						MenuType:      discordgo.MentionableSelectMenu,
+						MinValues:     &minMentions,
+						MaxValues:     10,
+						DefaultValues: defaultMentions,
+					},
+				},
+			},
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						CustomID: d.customID("schedule_mass_mention_select"),
+						MenuType: discordgo.StringSelectMenu,
+						Options: []discordgo.SelectMenuOption{
+							{Label: "Don't ping @everyone or @here", Value: "none", Default: pending.MassMention == models.MentionNone},
+							{Label: "Ping @everyone", Value: string(models.MentionEveryone), Default: pending.MassMention == models.MentionEveryone},
+							{Label: "Ping @here", Value: string(models.MentionHere), Default: pending.MassMention == models.MentionHere},
+						},
+					},
+				},
+			},
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						CustomID: d.customID("confirm_schedule"),
+						Label:    "Confirm",
+						Style:    discordgo.PrimaryButton,
+					},
+					discordgo.Button{
+						CustomID: d.customID("cancel_schedule"),
+						Label:    "Cancel",
+						Style:    discordgo.SecondaryButton,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// updateSchedulePreview applies a change to the draft of the preview and redraws it
+func updateSchedulePreview(session discordapi.Session, interaction *discordgo.InteractionCreate, change func(*PendingSchedule)) {
+	loc := storage.GuildLocation(store, interaction.GuildID)
+
+	d, err := scheduleFlow.update(interaction, interaction.MessageComponentData().CustomID, func(pending *PendingSchedule) error {
+		change(pending)
+		return nil
+	})
+	if err != nil {
+		respondWithDraftError(session, interaction, err)
+		return
+	}
+
+	preview, err := buildSchedulePreview(session, interaction.GuildID, d, loc)
+	if err != nil {
+		respondWithError(session, interaction, fmt.Sprintf("The message can't be posted as written: %v", err))
+		return
+	}
+	err = session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: preview,
+	})
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to update schedule preview", logging.Err(err))
+	}
+}
+
+// lookupScheduledMessage loads the message referenced by the "id" option, responding with an error if it can't be used
+func lookupScheduledMessage(session discordapi.Session, interaction *discordgo.InteractionCreate) (*models.ScheduledMessage, bool) {
+	idOpt := subcommandOption(interaction, "id")
+	if idOpt == nil {
+		respondWithError(session, interaction, "Please provide the ID of a scheduled message.")
+		return nil, false
+	}
+
+	scheduledMsg, err := store.ScheduledMessages().GetByID(idOpt.IntValue())
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && scheduledMsg.GuildID != interaction.GuildID) {
+		respondWithError(session, interaction, fmt.Sprintf("No scheduled message with ID %d in this server.", idOpt.IntValue()))
+		return nil, false
+	}
+	if err != nil {
+		respondWithInternalError(session, interaction, "Error getting message from database", err)
+		return nil, false
+	}
+
+	return scheduledMsg, true
+}
+
+// canManageScheduledMessage reports whether the invoking member may edit or remove the message
+func canManageScheduledMessage(interaction *discordgo.InteractionCreate, msg *models.ScheduledMessage) bool {
+	if interaction.Member == nil {
+		return false
+	}
+	if interaction.Member.User != nil && interaction.Member.User.ID == msg.UserID {
+		return true
+	}
+	return interaction.Member.Permissions&discordgo.PermissionManageGuild != 0
+}
+
+// subcommandOption returns the named option of the invoked subcommand, or nil if it wasn't provided
+func subcommandOption(interaction *discordgo.InteractionCreate, name string) *discordgo.ApplicationCommandInteractionDataOption {
+	options := interaction.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].GetOption(name)
+}
+
+// modalValue returns the value of the text input with the given custom ID in a submitted modal,
+// or "" if there is none
+func modalValue(data discordgo.ModalSubmitInteractionData, customID string) string {
+	for _, component := range data.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, input := range row.Components {
+			if input, ok := input.(*discordgo.TextInput); ok && input.CustomID == customID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
+
+// focusedOption returns the option the user is currently typing in during autocomplete
+func focusedOption(interaction *discordgo.InteractionCreate) *discordgo.ApplicationCommandInteractionDataOption {
+	options := interaction.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return nil
+	}
+	for _, opt := range options[0].Options {
+		if opt.Focused {
+			return opt
+		}
+	}
+	return nil
+}
+func respondWithSuccess(session discordapi.Session, interaction *discordgo.InteractionCreate, message string) {
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Content: message,
 			Flags:   discordgo.MessageFlagsEphemeral, // message only appears to the user
 		},
 	})
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to respond", logging.Err(err))
+	}
 }
 
-func respondWithError(session *discordgo.Session, interaction *discordgo.InteractionCreate, message string) {
-	session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+func respondWithError(session discordapi.Session, interaction *discordgo.InteractionCreate, message string) {
+	setOutcome(interaction, metrics.OutcomeUserError)
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Content: message,
 			Flags:   discordgo.MessageFlagsEphemeral, // message only appears to the user
 		},
 	})
-}
-
-// parseScheduledTime attempts to parse the time string using multiple formats
-func parseScheduledTime(timestr string) (time.Time, error) {
-	formats := []string{
-		time.RFC3339,              // 2025-01-01T23:59:00Z
-		"01.02.2006 15:04 MST",    // 12.31.2025 16:12 CET
-		"01.02.2006 15:04:05 MST", // 12.31.2025 16:12:00 CET
-		"2006-01-02 15:04 MST",    // 2025-12-31 16:12 CET
-		"2006-01-02 15:04:05 MST", // 2025-12-31 16:12:00 CET
-		"02.01.2006 15:04 MST",    // 28.02.2025 16:12:00 CET (DD.MM.YYYY)
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to respond with an error", logging.Err(err))
 	}
+}
 
-	var lastErr error
-	for _, format := range formats {
-		t, err := time.Parse(format, timestr)
-		if err == nil {
-			return t, nil
-		}
-		lastErr = err
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
 	}
+	return string(runes[:n-1]) + "…"
+}
 
-	return time.Time{}, lastErr
+// formatTime formats a time for display in the given timezone
+func formatTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("02.01.2006 15:04 MST")
 }
@@ -0,0 +1,159 @@
+package commands_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/commands/commandstest"
+	"github.com/betauia/BetaBot.go/bot/discordapi/discordtest"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestScheduleAddWizard(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // title of a message already scheduled in the guild
+		title    string
+		time     string
+		cancel   bool // press Cancel on the preview instead of Confirm
+
+		wantModalError string // part of the answer to the modal, empty when it shows the preview
+		wantAnswer     string // part of the answer to the button
+		wantSaved      bool
+	}{
+		{
+			name:       "scheduled",
+			title:      "Board meeting",
+			time:       "02.01.2030 18:00",
+			wantAnswer: "✅ Scheduled message saved successfully with ID: 1",
+			wantSaved:  true,
+		},
+		{
+			name:       "cancelled",
+			title:      "Board meeting",
+			time:       "02.01.2030 18:00",
+			cancel:     true,
+			wantAnswer: "❌ Schedule canceled.",
+		},
+		{
+			name:           "bad time",
+			title:          "Board meeting",
+			time:           "someday",
+			wantModalError: "someday",
+		},
+		{
+			name:           "time in the past",
+			title:          "Board meeting",
+			time:           "02.01.2020 18:00",
+			wantModalError: "future",
+		},
+		{
+			name:           "short title",
+			title:          "Hi",
+			time:           "02.01.2030 18:00",
+			wantModalError: "title",
+		},
+		{
+			name:       "title taken",
+			existing:   "Board meeting",
+			title:      "Board meeting",
+			time:       "02.01.2030 18:00",
+			wantAnswer: "already called **Board meeting**",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := commandstest.New(t)
+			if tt.existing != "" {
+				err := h.Store.ScheduledMessages().Create(&models.ScheduledMessage{
+					Title:         tt.existing,
+					GuildID:       h.GuildID,
+					UserID:        discordtest.UserID,
+					Message:       "Already scheduled",
+					ScheduledTime: time.Date(2030, time.March, 1, 12, 0, 0, 0, time.UTC),
+					ChannelID:     h.ChannelID,
+					Status:        models.StatusPending,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			add := h.Command("schedule", commandstest.Subcommand("add"))
+			if content := add.Content(); content != "Select a channel for the scheduled message:" {
+				t.Fatalf("/schedule add answered %q", content)
+			}
+
+			pick := h.Select(add.CustomID("schedule_channel_select:"), discordtest.OtherChannelID)
+			if response := pick.Response(); response.Type != discordgo.InteractionResponseModal {
+				t.Fatalf("picking the channel answered with response type %d, want a modal", response.Type)
+			}
+
+			preview := h.Submit(pick.CustomID("schedule_add_modal:"),
+				commandstest.Field("title", tt.title),
+				commandstest.Field("message", "See you in the usual room"),
+				commandstest.Field("time", tt.time))
+			if tt.wantModalError != "" {
+				if content := preview.Content(); !strings.Contains(content, tt.wantModalError) {
+					t.Errorf("the modal answered %q, want it to mention %q", content, tt.wantModalError)
+				}
+				expectMessages(t, h, tt.existing)
+				return
+			}
+			if content := preview.Content(); !strings.Contains(content, "<#"+discordtest.OtherChannelID+">") || !strings.Contains(content, "See you in the usual room") {
+				t.Errorf("the preview is %q, want the channel and the message", content)
+			}
+
+			button := preview.CustomID("confirm_schedule:")
+			if tt.cancel {
+				button = preview.CustomID("cancel_schedule:")
+			}
+			answer := h.Click(button)
+			if content := answer.Content(); !strings.Contains(content, tt.wantAnswer) {
+				t.Errorf("the button answered %q, want it to mention %q", content, tt.wantAnswer)
+			}
+
+			if !tt.wantSaved {
+				expectMessages(t, h, tt.existing)
+				return
+			}
+			list := expectMessages(t, h, tt.title)
+			msg := list[0]
+			want := time.Date(2030, time.January, 2, 18, 0, 0, 0, storage.GuildLocation(h.Store, h.GuildID))
+			if msg.ChannelID != discordtest.OtherChannelID || msg.UserID != discordtest.UserID || msg.Message != "See you in the usual room" ||
+				!msg.ScheduledTime.Equal(want) || msg.Status != models.StatusPending {
+				t.Errorf("saved %+v", msg)
+			}
+
+			// The draft is gone, so the buttons of the preview don't work again
+			if content := h.Click(button).Content(); !strings.Contains(content, "expired") {
+				t.Errorf("confirming twice answered %q", content)
+			}
+			expectMessages(t, h, tt.title)
+		})
+	}
+}
+
+// expectMessages checks the titles of the messages scheduled in the harness's guild, none when titles is empty
+func expectMessages(t *testing.T, h *commandstest.Harness, titles ...string) []*models.ScheduledMessage {
+	t.Helper()
+	list, err := h.Store.ScheduledMessages().ListByGuild(h.GuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, msg := range list {
+		got = append(got, msg.Title)
+	}
+	want := titles
+	if len(titles) == 1 && titles[0] == "" {
+		want = nil
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("scheduled %q, want %q", got, want)
+	}
+	return list
+}
@@ -0,0 +1,253 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/schedule"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/betauia/BetaBot.go/bot/templates"
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxTemplateName keeps template names short enough for autocomplete choices
+const maxTemplateName = 32
+
+// Define the template command
+var templateCommand = &discordgo.ApplicationCommand{
+	Name:        "template",
+	Description: "Manage reusable message templates for this server",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "create",
+			Description: "Create a new message template",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List the message templates in this server",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "delete",
+			Description: "Delete a message template",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "name",
+					Description:  "Name of the template",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+	},
+}
+
+func init() {
+	register(&Module{
+		Command:      templateCommand,
+		Handle:       handleTemplateCommand,
+		Autocomplete: handleTemplateAutocomplete,
+		Modals: map[string]HandlerFunc{
+			"template_create_modal": handleTemplateCreateModal,
+		},
+	})
+}
+
+func handleTemplateCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	options := interaction.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return
+	}
+
+	switch options[0].Name {
+	case "create":
+		handleTemplateCreateCommand(session, interaction)
+	case "list":
+		handleTemplateListCommand(session, interaction)
+	case "delete":
+		handleTemplateDeleteCommand(session, interaction)
+	}
+}
+
+// Handler for /template create - asks for the name and content in a modal
+func handleTemplateCreateCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	modal := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: "template_create_modal",
+			Title:    "Create Message Template",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "name",
+							Label:       "Name",
+							Style:       discordgo.TextInputShort,
+							Placeholder: "weekly-meeting",
+							Required:    true,
+							MaxLength:   maxTemplateName,
+							MinLength:   1,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "content",
+							Label:       "Content",
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: "Meeting {{weekday}} {{date}} at {{time}} in {{channel:general}}! This is meeting #{{occurrence}}.",
+							Required:    true,
+							MaxLength:   schedule.MaxMessageLength,
+							MinLength:   1,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := session.InteractionRespond(interaction.Interaction, modal); err != nil {
+		interactionLogger(interaction).Warn("Failed to send template modal", logging.Err(err))
+	}
+}
+
+// handleTemplateCreateModal saves the submitted template
+func handleTemplateCreateModal(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.ModalSubmitData()
+	name := strings.TrimSpace(modalValue(data, "name"))
+	content := modalValue(data, "content")
+
+	if name == "" || utf8.RuneCountInString(name) > maxTemplateName {
+		respondWithError(session, interaction, fmt.Sprintf("Template names must be between 1 and %d characters long.", maxTemplateName))
+		return
+	}
+	if err := templates.Validate(content); err != nil {
+		respondWithError(session, interaction, fmt.Sprintf("Invalid template variable: %v\n%s", err, schedule.VariableHelp()))
+		return
+	}
+
+	_, err := store.Templates().GetByName(interaction.GuildID, name)
+	if err == nil {
+		respondWithError(session, interaction, fmt.Sprintf("A template called **%s** already exists. Delete it first to replace it.", name))
+		return
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		respondWithInternalError(session, interaction, "Error getting template from database", err)
+		return
+	}
+
+	template := &models.Template{
+		GuildID: interaction.GuildID,
+		Name:    name,
+		Content: content,
+		UserID:  interaction.Member.User.ID,
+	}
+	if err := store.Templates().Create(template); err != nil {
+		respondWithInternalError(session, interaction, "Failed to save template", err)
+		return
+	}
+
+	respondWithSuccess(session, interaction, fmt.Sprintf("✅ Template **%s** saved. Use it with `/schedule add template:%s`.", template.Name, template.Name))
+}
+
+// Handler for /template list - Only shows templates in that guild
+func handleTemplateListCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	list, err := store.Templates().ListByGuild(interaction.GuildID)
+	if err != nil {
+		respondWithInternalError(session, interaction, "Error getting templates from database", err)
+		return
+	}
+
+	var response string
+	if len(list) == 0 {
+		response = "No message templates in this server yet. Create one with /template create.\n\n"
+	} else {
+		response = fmt.Sprintf("**Message Templates (%d):**\n\n", len(list))
+		for _, template := range list {
+			firstLine, _, _ := strings.Cut(template.Content, "\n")
+			response += fmt.Sprintf("**%s** by <@%s>\n   %s\n", template.Name, template.UserID, truncate(firstLine, 80))
+		}
+		response += "\n"
+	}
+	response += schedule.VariableHelp()
+
+	respondWithSuccess(session, interaction, response)
+}
+
+// Handler for /template delete - only the creator or members with Manage Server can delete a template
+func handleTemplateDeleteCommand(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	name := strings.TrimSpace(subcommandOption(interaction, "name").StringValue())
+
+	template, err := store.Templates().GetByName(interaction.GuildID, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(session, interaction, fmt.Sprintf("No template called %q in this server.", name))
+		return
+	}
+	if err != nil {
+		respondWithInternalError(session, interaction, "Error getting template from database", err)
+		return
+	}
+
+	if interaction.Member.User.ID != template.UserID && interaction.Member.Permissions&discordgo.PermissionManageGuild == 0 {
+		respondWithError(session, interaction, "Only the creator or members with Manage Server can delete this template.")
+		return
+	}
+
+	if err := store.Templates().Delete(template); err != nil {
+		respondWithInternalError(session, interaction, "Failed to delete template", err)
+		return
+	}
+
+	respondWithSuccess(session, interaction, fmt.Sprintf("🗑️ Template **%s** deleted. Messages already scheduled from it are not affected.", template.Name))
+}
+
+// handleTemplateAutocomplete suggests templates in the guild matching the typed name
+func handleTemplateAutocomplete(session discordapi.Session, interaction *discordgo.InteractionCreate) {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	if focused := focusedOption(interaction); focused != nil && focused.Name == "name" {
+		choices = templateChoices(interaction.GuildID, fmt.Sprint(focused.Value))
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		interactionLogger(interaction).Warn("Failed to send autocomplete choices", logging.Err(err))
+	}
+}
+
+// templateChoices returns autocomplete choices for the templates in a guild whose name contains query
+func templateChoices(guildID, query string) []*discordgo.ApplicationCommandOptionChoice {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+
+	list, err := store.Templates().ListByGuild(guildID)
+	if err != nil {
+		slog.Warn("Failed to load templates for autocomplete", slog.String(logging.GuildID, guildID), logging.Err(err))
+		return choices
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	for _, template := range list {
+		if !strings.Contains(strings.ToLower(template.Name), query) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: template.Name, Value: template.Name})
+		if len(choices) == 25 { // Discord limit
+			break
+		}
+	}
+	return choices
+}
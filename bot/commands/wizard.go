@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Multi-step commands, like /schedule add, keep what the user entered so far in a draft that is
+// stored in the database between the steps. The draft's ID follows the colon in the custom IDs
+// of the components and modals of every step, e.g. "confirm_schedule:<draft ID>", so each run of
+// a command has its own draft, one user can run several at once, and a restart doesn't lose them.
+// Drafts expire when they haven't been touched for the flow's TTL.
+
+// draftCleanupInterval is how often expired drafts are deleted
+const draftCleanupInterval = 10 * time.Minute
+
+// errDraftExpired is returned when a draft doesn't exist anymore, or belongs to someone else
+var errDraftExpired = errors.New("draft expired")
+
+// draftMutex serializes changes to drafts, so two quick clicks on the same step don't overwrite each other
+var draftMutex sync.Mutex
+
+// flow is a kind of multi-step command whose state between the steps is a T
+type flow[T any] struct {
+	name string
+	ttl  time.Duration
+}
+
+// draft is the state of one run of a flow
+type draft[T any] struct {
+	model *models.Draft
+	State *T
+}
+
+// customID returns the custom ID of a component or modal that continues this draft
+func (d *draft[T]) customID(base string) string {
+	return base + ":" + d.model.ID
+}
+
+// start stores a new draft for the member who used the interaction
+func (f flow[T]) start(interaction *discordgo.InteractionCreate, state *T) (*draft[T], error) {
+	d := &draft[T]{
+		model: &models.Draft{Flow: f.name, GuildID: interaction.GuildID, UserID: interactionUserID(interaction)},
+		State: state,
+	}
+	if err := f.encode(d); err != nil {
+		return nil, err
+	}
+	if err := store.Drafts().Create(d.model); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// load returns the draft whose ID is in the custom ID. Only the member who started a draft can continue it.
+func (f flow[T]) load(interaction *discordgo.InteractionCreate, customID string) (*draft[T], error) {
+	_, id, found := strings.Cut(customID, ":")
+	if !found || id == "" {
+		return nil, errDraftExpired
+	}
+
+	model, err := store.Drafts().Get(id, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, errDraftExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	if model.Flow != f.name || model.GuildID != interaction.GuildID || model.UserID != interactionUserID(interaction) {
+		return nil, errDraftExpired
+	}
+
+	d := &draft[T]{model: model, State: new(T)}
+	if err := json.Unmarshal(model.State, d.State); err != nil {
+		return nil, fmt.Errorf("decoding %s draft: %w", f.name, err)
+	}
+	return d, nil
+}
+
+// update loads a draft, applies change to its state and saves it. Nothing is saved when change fails.
+func (f flow[T]) update(interaction *discordgo.InteractionCreate, customID string, change func(state *T) error) (*draft[T], error) {
+	draftMutex.Lock()
+	defer draftMutex.Unlock()
+
+	d, err := f.load(interaction, customID)
+	if err != nil {
+		return nil, err
+	}
+	if err := change(d.State); err != nil {
+		return nil, err
+	}
+	if err := f.save(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// save stores the state of a draft and extends its expiry
+func (f flow[T]) save(d *draft[T]) error {
+	if err := f.encode(d); err != nil {
+		return err
+	}
+	if err := store.Drafts().Update(d.model); errors.Is(err, storage.ErrNotFound) {
+		return errDraftExpired
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// finish deletes a draft, and the files uploaded for it, once its command is done or cancelled
+func (f flow[T]) finish(d *draft[T]) {
+	if err := store.Drafts().Delete(d.model.ID); err != nil {
+		slog.Warn("Failed to delete draft", slog.String("flow", f.name), slog.String("draft_id", d.model.ID), logging.Err(err))
+	}
+}
+
+// encode stores the state in the draft model and extends its expiry
+func (f flow[T]) encode(d *draft[T]) error {
+	state, err := json.Marshal(d.State)
+	if err != nil {
+		return fmt.Errorf("encoding %s draft: %w", f.name, err)
+	}
+	d.model.State = state
+	d.model.ExpiresAt = time.Now().Add(f.ttl)
+	return nil
+}
+
+// respondWithDraftError tells the user why a step of a multi-step command failed
+func respondWithDraftError(session discordapi.Session, interaction *discordgo.InteractionCreate, err error) {
+	if errors.Is(err, errDraftExpired) {
+		respondWithError(session, interaction, "Session expired. Please try again.")
+		return
+	}
+	respondWithError(session, interaction, fmt.Sprintf("Failed to save your progress: %v", err))
+}
+
+// StartDraftCleanup periodically deletes expired drafts until ctx is cancelled
+func StartDraftCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(draftCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			deleted, err := store.Drafts().DeleteExpired(time.Now())
+			if err != nil {
+				slog.Warn("Failed to delete expired drafts", logging.Err(err))
+			} else if deleted > 0 {
+				slog.Info("Deleted expired drafts", slog.Int64("count", deleted))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,495 @@
+// Package config loads the bot's settings. Every setting has a built-in default and can be
+// overridden, from lowest to highest precedence, by the YAML config file, environment variables
+// (including those in an optional .env file) and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/dateparse"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Version of the bot, set at build time with
+// -ldflags "-X github.com/betauia/BetaBot.go/bot/config.Version=1.2.3"
+var Version = "0.1.0"
+
+// minAPITokenLength keeps the admin API from being guarded by a guessable token
+const minAPITokenLength = 32
+
+// Config is the effective configuration of the bot
+type Config struct {
+	BotToken          string
+	DatabaseURL       string // see storage.Open
+	ReconcileInterval time.Duration
+	ShutdownTimeout   time.Duration
+	RemoveCommands    bool
+	DevGuildID        string // register the commands in this guild only, empty to register them globally
+	DefaultTimezone   string
+	AdminChannelID    string // channel unexpected errors are reported in, empty to only log them
+	LogLevel          string // debug, info, warn or error
+	LogFormat         string // text or json
+	MetricsAddr       string // address of the metrics and health check server, empty to not start it
+	APIAddr           string // address of the admin API, empty to not start it
+	APIToken          string // token the admin API requires, see package api
+	DashboardAddr     string // address of the web dashboard, empty to not start it
+	DashboardURL      string // public URL of the dashboard, Discord redirects logins back to it
+	ClientID          string // ID of the Discord application, for logging in to the dashboard
+	ClientSecret      string
+
+	ConfigFile  string // YAML file the settings were read from, empty if none
+	PrintConfig bool   // print the configuration and exit instead of starting the bot
+
+	sources map[string]string // where the value of each setting came from
+}
+
+// setting describes one configuration value and where it can be set
+type setting struct {
+	name  string // key in the config file
+	env   string
+	flag  string // empty if the setting can't be passed on the command line, e.g. secrets
+	usage string
+	bool  bool // the flag can be passed without a value to mean true
+
+	set  func(c *Config, value string) error
+	show func(c *Config) string // the value for --print-config, with secrets redacted
+}
+
+var settings = []setting{
+	{
+		name:  "bot_token",
+		env:   "BOT_TOKEN",
+		usage: "Discord bot token",
+		set:   func(c *Config, value string) error { c.BotToken = value; return nil },
+		show:  func(c *Config) string { return redact(c.BotToken) },
+	},
+	{
+		name:  "database_url",
+		env:   "DATABASE_URL",
+		flag:  "database-url",
+		usage: "database to use: a SQLite path like sqlite:./db/betabot.db, or a postgres:// URL",
+		set:   func(c *Config, value string) error { c.DatabaseURL = value; return nil },
+		show:  func(c *Config) string { return redactURL(c.DatabaseURL) },
+	},
+	{
+		name:  "reconcile_interval",
+		env:   "RECONCILE_INTERVAL",
+		flag:  "reconcile-interval",
+		usage: "how often the scheduler re-reads scheduled messages from the database",
+		set: func(c *Config, value string) (err error) {
+			c.ReconcileInterval, err = time.ParseDuration(value)
+			return err
+		},
+		show: func(c *Config) string { return c.ReconcileInterval.String() },
+	},
+	{
+		name:  "shutdown_timeout",
+		env:   "SHUTDOWN_TIMEOUT",
+		flag:  "shutdown-timeout",
+		usage: "how long messages that are being posted get to finish on shutdown",
+		set: func(c *Config, value string) (err error) {
+			c.ShutdownTimeout, err = time.ParseDuration(value)
+			return err
+		},
+		show: func(c *Config) string { return c.ShutdownTimeout.String() },
+	},
+	{
+		name:  "remove_commands",
+		env:   "REMOVE_COMMANDS",
+		flag:  "remove-command",
+		usage: "remove the slash commands from Discord when shutting down",
+		bool:  true,
+		set: func(c *Config, value string) (err error) {
+			c.RemoveCommands, err = strconv.ParseBool(value)
+			return err
+		},
+		show: func(c *Config) string { return strconv.FormatBool(c.RemoveCommands) },
+	},
+	{
+		name:  "dev_guild_id",
+		env:   "DEV_GUILD_ID",
+		flag:  "dev-guild",
+		usage: "ID of a server to register the commands in instead of globally, so changes show up immediately while developing",
+		set:   func(c *Config, value string) error { c.DevGuildID = value; return nil },
+		show:  func(c *Config) string { return c.DevGuildID },
+	},
+	{
+		name:  "default_timezone",
+		env:   "DEFAULT_TIMEZONE",
+		flag:  "default-timezone",
+		usage: "IANA timezone of servers that haven't picked their own with /schedule timezone",
+		set:   func(c *Config, value string) error { c.DefaultTimezone = value; return nil },
+		show:  func(c *Config) string { return c.DefaultTimezone },
+	},
+	{
+		name:  "admin_channel_id",
+		env:   "ADMIN_CHANNEL_ID",
+		flag:  "admin-channel",
+		usage: "ID of a channel the bot reports unexpected errors in, such as crashed commands",
+		set:   func(c *Config, value string) error { c.AdminChannelID = value; return nil },
+		show:  func(c *Config) string { return c.AdminChannelID },
+	},
+	{
+		name:  "log_level",
+		env:   "LOG_LEVEL",
+		flag:  "log-level",
+		usage: "least severe logs to write: debug, info, warn or error. /logging turns on debug logs for one server",
+		set:   func(c *Config, value string) error { c.LogLevel = value; return nil },
+		show:  func(c *Config) string { return c.LogLevel },
+	},
+	{
+		name:  "log_format",
+		env:   "LOG_FORMAT",
+		flag:  "log-format",
+		usage: "format of the logs: text, or json for log collectors",
+		set:   func(c *Config, value string) error { c.LogFormat = value; return nil },
+		show:  func(c *Config) string { return c.LogFormat },
+	},
+	{
+		name:  "metrics_addr",
+		env:   "METRICS_ADDR",
+		flag:  "metrics-addr",
+		usage: "address to serve Prometheus metrics on /metrics and health checks on /healthz and /readyz, like :9090. Empty to turn them off",
+		set:   func(c *Config, value string) error { c.MetricsAddr = value; return nil },
+		show:  func(c *Config) string { return c.MetricsAddr },
+	},
+	{
+		name:  "api_addr",
+		env:   "API_ADDR",
+		flag:  "api-addr",
+		usage: "address to serve the admin API for scheduled messages on, like :8080. Empty to turn it off",
+		set:   func(c *Config, value string) error { c.APIAddr = value; return nil },
+		show:  func(c *Config) string { return c.APIAddr },
+	},
+	{
+		name:  "api_token",
+		env:   "API_TOKEN",
+		usage: "token clients of the admin API send as Authorization: Bearer <token>",
+		set:   func(c *Config, value string) error { c.APIToken = value; return nil },
+		show:  func(c *Config) string { return redact(c.APIToken) },
+	},
+	{
+		name:  "dashboard_addr",
+		env:   "DASHBOARD_ADDR",
+		flag:  "dashboard-addr",
+		usage: "address to serve the web dashboard on, like :8081. Empty to turn it off",
+		set:   func(c *Config, value string) error { c.DashboardAddr = value; return nil },
+		show:  func(c *Config) string { return c.DashboardAddr },
+	},
+	{
+		name:  "dashboard_url",
+		env:   "DASHBOARD_URL",
+		flag:  "dashboard-url",
+		usage: "public URL of the web dashboard, like https://bot.betauia.net. Add its /callback as a redirect of the Discord application",
+		set:   func(c *Config, value string) error { c.DashboardURL = value; return nil },
+		show:  func(c *Config) string { return c.DashboardURL },
+	},
+	{
+		name:  "discord_client_id",
+		env:   "DISCORD_CLIENT_ID",
+		flag:  "discord-client-id",
+		usage: "client ID of the Discord application, used to log in to the web dashboard",
+		set:   func(c *Config, value string) error { c.ClientID = value; return nil },
+		show:  func(c *Config) string { return c.ClientID },
+	},
+	{
+		name:  "discord_client_secret",
+		env:   "DISCORD_CLIENT_SECRET",
+		usage: "client secret of the Discord application, used to log in to the web dashboard",
+		set:   func(c *Config, value string) error { c.ClientSecret = value; return nil },
+		show:  func(c *Config) string { return redact(c.ClientSecret) },
+	},
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		DatabaseURL:       storage.DefaultDatabaseURL,
+		ReconcileInterval: 5 * time.Minute,
+		ShutdownTimeout:   30 * time.Second,
+		RemoveCommands:    true,
+		DefaultTimezone:   dateparse.DefaultTimezone,
+		LogLevel:          "info",
+		LogFormat:         logging.FormatText,
+		sources:           map[string]string{},
+	}
+}
+
+// Load builds the configuration from the defaults, the config file, the environment and the
+// command line arguments (without the program name). It returns flag.ErrHelp when -help was
+// passed. The result isn't validated, so --print-config can show a broken configuration.
+func Load(args []string) (*Config, error) {
+	c := Default()
+
+	fs := flag.NewFlagSet("betabot", flag.ContinueOnError)
+	fs.StringVar(&c.ConfigFile, "config", "", "YAML config file, also set with CONFIG_FILE")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	defaults := Default()
+	flagValues := map[string]*flagValue{}
+	for _, s := range settings {
+		if s.flag != "" {
+			flagValues[s.name] = &flagValue{bool: s.bool}
+			fs.Var(flagValues[s.name], s.flag, fmt.Sprintf("%s (env %s, default %s)", s.usage, s.env, s.show(defaults)))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Variables in .env don't override the real environment
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+
+	if c.ConfigFile == "" {
+		c.ConfigFile = os.Getenv("CONFIG_FILE")
+	}
+	if c.ConfigFile != "" {
+		values, err := readFile(c.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		source := "file " + c.ConfigFile
+		if err := c.apply(values, func(setting) string { return source }); err != nil {
+			return nil, err
+		}
+	}
+
+	// Empty variables count as unset, e.g. DATABASE_URL= in a .env file
+	env := map[string]string{}
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			env[s.name] = value
+		}
+	}
+	if err := c.apply(env, func(s setting) string { return "env " + s.env }); err != nil {
+		return nil, err
+	}
+
+	flags := map[string]string{}
+	for _, s := range settings {
+		if s.flag != "" && explicit[s.flag] {
+			flags[s.name] = flagValues[s.name].value
+		}
+	}
+	if err := c.apply(flags, func(s setting) string { return "flag -" + s.flag }); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// flagValue is a flag that is only applied when it was passed, see Load
+type flagValue struct {
+	value string
+	bool  bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) Set(v string) error { f.value = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.bool }
+
+// readFile reads the settings in a YAML config file
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		if lookup(key) == nil {
+			return nil, fmt.Errorf("config file %s: unknown setting %q, known settings are %s", path, key, strings.Join(names(), ", "))
+		}
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("config file %s: %s must be a single value", path, key)
+		case nil:
+			continue
+		}
+		values[key] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+// apply sets the given values, recording where each came from
+func (c *Config) apply(values map[string]string, source func(setting) string) error {
+	for _, s := range settings {
+		value, ok := values[s.name]
+		if !ok {
+			continue
+		}
+		if err := s.set(c, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("invalid %s from %s: %w", s.name, source(s), err)
+		}
+		c.sources[s.name] = source(s)
+	}
+	return nil
+}
+
+// Validate checks the configuration, reporting every problem at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.BotToken == "" {
+		errs = append(errs, errors.New("bot_token is not set: set BOT_TOKEN in the environment or .env file, or bot_token in the config file"))
+	}
+	if err := validateDatabaseURL(c.DatabaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("database_url %w", err))
+	}
+	if c.ReconcileInterval < time.Second {
+		errs = append(errs, fmt.Errorf("reconcile_interval must be at least 1s, got %v", c.ReconcileInterval))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout can't be negative, got %v", c.ShutdownTimeout))
+	}
+	if _, err := strconv.ParseUint(c.DevGuildID, 10, 64); c.DevGuildID != "" && err != nil {
+		errs = append(errs, fmt.Errorf("dev_guild_id %q is not a server ID, copy it with Developer Mode enabled in Discord", c.DevGuildID))
+	}
+	if _, err := strconv.ParseUint(c.AdminChannelID, 10, 64); c.AdminChannelID != "" && err != nil {
+		errs = append(errs, fmt.Errorf("admin_channel_id %q is not a channel ID, copy it with Developer Mode enabled in Discord", c.AdminChannelID))
+	}
+	if _, err := dateparse.LoadLocation(c.DefaultTimezone); err != nil {
+		errs = append(errs, fmt.Errorf("default_timezone %q is not a timezone, use an IANA name like Europe/Oslo", c.DefaultTimezone))
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if c.LogFormat != logging.FormatText && c.LogFormat != logging.FormatJSON {
+		errs = append(errs, fmt.Errorf("log_format %q is not supported, use text or json", c.LogFormat))
+	}
+	if _, _, err := net.SplitHostPort(c.MetricsAddr); c.MetricsAddr != "" && err != nil {
+		errs = append(errs, fmt.Errorf("metrics_addr %q is not an address, use host:port or :port like :9090", c.MetricsAddr))
+	}
+	if c.APIAddr != "" {
+		if _, _, err := net.SplitHostPort(c.APIAddr); err != nil {
+			errs = append(errs, fmt.Errorf("api_addr %q is not an address, use host:port or :port like :8080", c.APIAddr))
+		}
+		if len(c.APIToken) < minAPITokenLength {
+			errs = append(errs, fmt.Errorf("api_token must be at least %d characters when api_addr is set, generate one with e.g. openssl rand -hex 32", minAPITokenLength))
+		}
+	}
+	if c.DashboardAddr != "" {
+		if _, _, err := net.SplitHostPort(c.DashboardAddr); err != nil {
+			errs = append(errs, fmt.Errorf("dashboard_addr %q is not an address, use host:port or :port like :8081", c.DashboardAddr))
+		}
+		if u, err := url.Parse(c.DashboardURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("dashboard_url %q must be the URL people open the dashboard at, like https://bot.betauia.net", c.DashboardURL))
+		}
+		if _, err := strconv.ParseUint(c.ClientID, 10, 64); err != nil {
+			errs = append(errs, fmt.Errorf("discord_client_id %q is not a client ID, copy it from the OAuth2 page of the Discord application", c.ClientID))
+		}
+		if c.ClientSecret == "" {
+			errs = append(errs, errors.New("discord_client_secret is required when dashboard_addr is set, copy it from the OAuth2 page of the Discord application"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateDatabaseURL(databaseURL string) error {
+	if databaseURL == "" {
+		return errors.New("is empty")
+	}
+	if !strings.Contains(databaseURL, "://") {
+		return nil // a SQLite path
+	}
+
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		return fmt.Errorf("is not a valid URL: %v", err)
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		if u.Host == "" {
+			return errors.New("is missing the Postgres host")
+		}
+	case "sqlite":
+	default:
+		return fmt.Errorf("has unsupported scheme %q, use postgres:// or a SQLite path", u.Scheme)
+	}
+	return nil
+}
+
+// Print writes the effective configuration and where each value came from, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "# BetaBot %s\n", Version)
+	if c.ConfigFile != "" {
+		fmt.Fprintf(tw, "# config file: %s\n", c.ConfigFile)
+	}
+	for _, s := range settings {
+		source := c.sources[s.name]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(tw, "%s: %s\t# %s\n", s.name, quote(s.show(c)), source)
+	}
+	return tw.Flush()
+}
+
+// quote formats a value for YAML, so the printed configuration can be used as a config file
+func quote(value string) string {
+	if value == "" || strings.ContainsAny(value, ":#{}[],&*?|<>=!%@`'\"") || strings.TrimSpace(value) != value {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// redact hides a secret, only showing whether it is set
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// redactURL hides the password in a database URL, given either in the user info or as a parameter
+func redactURL(databaseURL string) string {
+	u, err := url.Parse(databaseURL)
+	if err != nil || u.Scheme == "" {
+		return databaseURL
+	}
+	if query := u.Query(); query.Has("password") {
+		query.Set("password", "xxxxx")
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+func lookup(name string) *setting {
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+func names() []string {
+	var list []string
+	for _, s := range settings {
+		list = append(list, s.name)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,268 @@
+package dashboard
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// loginDuration is how long a login lasts. The guilds and permissions of the user are those
+// of the time they logged in, so it is kept short enough for a revoked role to take effect soon.
+const loginDuration = 8 * time.Hour
+
+const (
+	loginCookie = "betabot_login"
+	stateCookie = "betabot_oauth_state"
+)
+
+// Identity is who logged in, with the guilds they are in and their permissions there
+type Identity struct {
+	User   *discordgo.User
+	Guilds []*discordgo.UserGuild
+}
+
+// CanManage reports whether the user may manage the scheduled messages of a guild, which
+// needs Manage Server like the /schedule command
+func (id *Identity) CanManage(guildID string) bool {
+	return id.guild(guildID) != nil
+}
+
+// ManagedGuilds returns the guilds the user may manage
+func (id *Identity) ManagedGuilds() []*discordgo.UserGuild {
+	var managed []*discordgo.UserGuild
+	for _, guild := range id.Guilds {
+		if canManage(guild) {
+			managed = append(managed, guild)
+		}
+	}
+	return managed
+}
+
+// guild returns a guild the user may manage, nil if they can't
+func (id *Identity) guild(guildID string) *discordgo.UserGuild {
+	for _, guild := range id.Guilds {
+		if guild.ID == guildID && canManage(guild) {
+			return guild
+		}
+	}
+	return nil
+}
+
+func canManage(guild *discordgo.UserGuild) bool {
+	return guild.Owner || guild.Permissions&(discordgo.PermissionManageGuild|discordgo.PermissionAdministrator) != 0
+}
+
+// Authenticator logs users in with an OAuth2 authorization code flow
+type Authenticator interface {
+	// LoginURL returns where to send the browser to log in. The provider redirects back to
+	// the dashboard's /callback with a code and the given state.
+	LoginURL(state string) string
+	// Exchange returns who logged in for the code passed to /callback
+	Exchange(ctx context.Context, code string) (*Identity, error)
+}
+
+// DiscordOAuth logs users in with their Discord account, see
+// https://discord.com/developers/docs/topics/oauth2#authorization-code-grant
+type DiscordOAuth struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // the dashboard's /callback, as registered in the Discord application
+	Client       *http.Client
+}
+
+// NewDiscordOAuth returns an authenticator for the Discord application with the given
+// credentials, redirecting back to baseURL + "/callback"
+func NewDiscordOAuth(clientID, clientSecret, baseURL string) *DiscordOAuth {
+	return &DiscordOAuth{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  strings.TrimSuffix(baseURL, "/") + "/callback",
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LoginURL asks for the identify and guilds scopes, the guild list includes the user's permissions
+func (o *DiscordOAuth) LoginURL(state string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"scope":         {"identify guilds"},
+		"redirect_uri":  {o.RedirectURL},
+		"state":         {state},
+		"prompt":        {"none"},
+	}
+	return "https://discord.com/oauth2/authorize?" + query.Encode()
+}
+
+// Exchange trades the code for an access token, and uses it to look up the user and their guilds
+func (o *DiscordOAuth) Exchange(ctx context.Context, code string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.RedirectURL},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, discordgo.EndpointOAuth2+"token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(o.ClientID, o.ClientSecret)
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := o.do(request, &token); err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+
+	identity := &Identity{}
+	if err := o.get(ctx, token.AccessToken, discordgo.EndpointUser("@me"), &identity.User); err != nil {
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+	if err := o.get(ctx, token.AccessToken, discordgo.EndpointUserGuilds("@me"), &identity.Guilds); err != nil {
+		return nil, fmt.Errorf("getting guilds: %w", err)
+	}
+	return identity, nil
+}
+
+// get fetches a Discord API endpoint with the user's access token
+func (o *DiscordOAuth) get(ctx context.Context, accessToken, endpoint string, value any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	return o.do(request, value)
+}
+
+func (o *DiscordOAuth) do(request *http.Request, value any) error {
+	response, err := o.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Discord answered %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, value)
+}
+
+// StubAuth logs everyone in as the same identity without asking Discord, for tests and local
+// development. Its login URL goes straight to /callback.
+type StubAuth struct {
+	Identity *Identity
+}
+
+func (a *StubAuth) LoginURL(state string) string {
+	return "/callback?" + url.Values{"code": {"stub"}, "state": {state}}.Encode()
+}
+
+func (a *StubAuth) Exchange(ctx context.Context, code string) (*Identity, error) {
+	if code != "stub" {
+		return nil, fmt.Errorf("unknown code %q", code)
+	}
+	return a.Identity, nil
+}
+
+// login is a logged in browser
+type login struct {
+	identity  *Identity
+	csrfToken string // sent with every form, so other sites can't post them
+	expiresAt time.Time
+}
+
+// logins keeps the logged in browsers by the token in their cookie. They are kept in memory,
+// so everyone has to log in again after a restart.
+type logins struct {
+	mu     sync.Mutex
+	byID   map[string]*login
+	secure bool // only send the cookie over HTTPS
+	now    func() time.Time
+}
+
+func newLogins(secure bool) *logins {
+	return &logins{byID: map[string]*login{}, secure: secure, now: time.Now}
+}
+
+// create logs a browser in
+func (l *logins) create(w http.ResponseWriter, identity *Identity) {
+	token := randomToken()
+	now := l.now()
+
+	l.mu.Lock()
+	for id, existing := range l.byID {
+		if now.After(existing.expiresAt) {
+			delete(l.byID, id)
+		}
+	}
+	l.byID[token] = &login{identity: identity, csrfToken: randomToken(), expiresAt: now.Add(loginDuration)}
+	l.mu.Unlock()
+
+	http.SetCookie(w, l.cookie(loginCookie, token, loginDuration))
+}
+
+// get returns the login of the request, nil if it isn't logged in
+func (l *logins) get(r *http.Request) *login {
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.byID[cookie.Value]
+	if current == nil || l.now().After(current.expiresAt) {
+		return nil
+	}
+	return current
+}
+
+// remove logs the browser out
+func (l *logins) remove(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(loginCookie); err == nil {
+		l.mu.Lock()
+		delete(l.byID, cookie.Value)
+		l.mu.Unlock()
+	}
+	http.SetCookie(w, l.cookie(loginCookie, "", -1))
+}
+
+// cookie returns a cookie only the dashboard can read. A negative maxAge deletes it.
+func (l *logins) cookie(name, value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   l.secure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// randomToken returns a token that can't be guessed
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
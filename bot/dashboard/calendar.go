@@ -0,0 +1,161 @@
+package dashboard
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/recurrence"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxOccurrenceSteps bounds the work of listing the occurrences of one recurring message in
+// a month, a rule like "* * * * *" has tens of thousands
+const maxOccurrenceSteps = 2000
+
+// maxOccurrencesPerDay limits what is shown for one message in a calendar day
+const maxOccurrencesPerDay = 3
+
+// occurrence is one post of a scheduled message
+type occurrence struct {
+	Time    time.Time // in the guild's timezone
+	Message *models.ScheduledMessage
+	Repeat  bool // a later occurrence of a recurring message, not its next post
+}
+
+type calendarDay struct {
+	Date        time.Time
+	InMonth     bool
+	Today       bool
+	Occurrences []occurrence
+	More        int // occurrences left out to keep the day readable
+}
+
+type calendarView struct {
+	Month    time.Time // first day of the month, in the guild's timezone
+	Prev     string    // the month before, as 2006-01
+	Next     string
+	Timezone string
+	Weeks    [][]calendarDay
+	Upcoming []occurrence // the next posts, also those after this month
+}
+
+// handleCalendar shows the upcoming posts of a guild in a month, ?month=2006-01 and this month by default
+func (s *Server) handleCalendar(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) {
+	loc := storage.GuildLocation(s.store, guild.ID)
+	now := s.now().In(loc)
+
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	if param := r.URL.Query().Get("month"); param != "" {
+		parsed, err := time.ParseInLocation("2006-01", param, loc)
+		if err != nil {
+			s.renderError(w, r, http.StatusBadRequest, current, "The month must be written like 2026-09.")
+			return
+		}
+		month = parsed
+	}
+
+	messages, err := s.store.ScheduledMessages().ListByGuild(guild.ID)
+	if err != nil {
+		s.renderInternalError(w, r, current, "Failed to list scheduled messages", err)
+		return
+	}
+	var active []*models.ScheduledMessage
+	for _, msg := range messages {
+		if msg.IsActive() || msg.Status == models.StatusSending {
+			active = append(active, msg)
+		}
+	}
+
+	view := buildCalendar(active, month, now, loc)
+	view.Timezone = loc.String()
+	for _, msg := range active {
+		view.Upcoming = append(view.Upcoming, occurrence{Time: msg.ScheduledTime.In(loc), Message: msg})
+	}
+	slices.SortFunc(view.Upcoming, func(a, b occurrence) int { return a.Time.Compare(b.Time) })
+
+	s.render(w, r, http.StatusOK, "calendar.html", newPage(guild.Name, current, guild, view))
+}
+
+// buildCalendar lays out a month in weeks starting on Monday, with the occurrences of the
+// messages on their days
+func buildCalendar(messages []*models.ScheduledMessage, month, now time.Time, loc *time.Location) *calendarView {
+	view := &calendarView{
+		Month: month,
+		Prev:  month.AddDate(0, -1, 0).Format("2006-01"),
+		Next:  month.AddDate(0, 1, 0).Format("2006-01"),
+	}
+
+	// The grid starts on the Monday before the first and ends on the Sunday after the last day
+	start := month.AddDate(0, 0, -((int(month.Weekday()) + 6) % 7))
+	end := month.AddDate(0, 1, 0)
+	end = end.AddDate(0, 0, (8-int(end.Weekday()))%7)
+
+	byDay := map[string][]occurrence{}
+	for _, msg := range messages {
+		for _, t := range occurrences(msg, start, end, loc) {
+			key := t.Format(time.DateOnly)
+			byDay[key] = append(byDay[key], occurrence{Time: t, Message: msg, Repeat: t.After(msg.ScheduledTime)})
+		}
+	}
+
+	today := now.Format(time.DateOnly)
+	var week []calendarDay
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		key := day.Format(time.DateOnly)
+		entry := calendarDay{Date: day, InMonth: day.Month() == month.Month(), Today: key == today}
+		dayOccurrences := byDay[key]
+		slices.SortFunc(dayOccurrences, func(a, b occurrence) int { return a.Time.Compare(b.Time) })
+		entry.Occurrences, entry.More = limitPerMessage(dayOccurrences)
+		week = append(week, entry)
+		if len(week) == 7 {
+			view.Weeks = append(view.Weeks, week)
+			week = nil
+		}
+	}
+	return view
+}
+
+// occurrences returns the posts of a message from from until to, in loc. A recurring message
+// continues like the scheduler would schedule it, see scheduler.NextOccurrence.
+func occurrences(msg *models.ScheduledMessage, from, to time.Time, loc *time.Location) []time.Time {
+	var rule recurrence.Rule
+	if msg.IsRecurring() {
+		rule, _ = recurrence.Parse(msg.Recurrence) // an invalid rule still shows the next post
+	}
+
+	var found []time.Time
+	posts := msg.Occurrences
+	for next, steps := msg.ScheduledTime.In(loc), 0; !next.IsZero() && next.Before(to) && steps < maxOccurrenceSteps; steps++ {
+		if !next.Before(from) {
+			found = append(found, next)
+		}
+		posts++
+		if rule == nil || (msg.MaxOccurrences > 0 && posts >= msg.MaxOccurrences) {
+			break
+		}
+		next = rule.Next(next)
+		if !msg.RecurrenceEnd.IsZero() && next.After(msg.RecurrenceEnd) {
+			break
+		}
+	}
+	return found
+}
+
+// limitPerMessage keeps a message that posts many times a day from filling the day
+func limitPerMessage(dayOccurrences []occurrence) ([]occurrence, int) {
+	var kept []occurrence
+	perMessage := map[int64]int{}
+	more := 0
+	for _, o := range dayOccurrences {
+		perMessage[o.Message.ID]++
+		if perMessage[o.Message.ID] > maxOccurrencesPerDay {
+			more++
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return kept, more
+}
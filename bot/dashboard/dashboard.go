@@ -0,0 +1,182 @@
+// Package dashboard serves a web dashboard where board members manage the scheduled messages
+// of their Discord servers: a calendar of what will be posted, forms to create and edit
+// messages with a preview of the Markdown, and the delivery history. The pages are rendered on
+// the server from the templates embedded in the binary, so it works without JavaScript.
+//
+// People log in with Discord (see DiscordOAuth, or StubAuth in tests) and can only manage the
+// servers where they have Manage Server, the permission the /schedule command requires.
+// Messages are checked like the ones entered with /schedule, see package schedule.
+package dashboard
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"embed"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/httpserver"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxFormSize limits the forms that are posted, a message with an embed is well below it
+const maxFormSize = 64 << 10
+
+//go:embed templates static
+var files embed.FS
+
+// pages are the templates of the pages, each parsed with the layout
+var pages = parsePages("login.html", "guilds.html", "calendar.html", "message.html", "history.html", "error.html")
+
+func parsePages(names ...string) map[string]*template.Template {
+	parsed := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		parsed[name] = template.Must(template.New(name).Funcs(templateFuncs).ParseFS(files, "templates/layout.html", "templates/"+name))
+	}
+	return parsed
+}
+
+var templateFuncs = template.FuncMap{
+	"markdown": func(text string) template.HTML { return renderMarkdown(text, nil) },
+	"guildIcon": func(guild *discordgo.UserGuild) string {
+		if guild.Icon == "" {
+			return ""
+		}
+		return discordgo.EndpointGuildIcon(guild.ID, guild.Icon)
+	},
+}
+
+// Server serves the dashboard
+type Server struct {
+	*httpserver.Server
+	store   storage.Store
+	discord discordapi.Session
+	auth    Authenticator
+	logins  *logins
+	now     func() time.Time
+}
+
+// NewServer returns a server that will listen on addr, e.g. ":8081". Channels and roles are
+// looked up with the bot's session, and people log in with auth. With secure the login cookie
+// is only sent over HTTPS, set it when the dashboard is served behind TLS.
+func NewServer(addr string, store storage.Store, discord discordapi.Session, auth Authenticator, secure bool) *Server {
+	s := &Server{store: store, discord: discord, auth: auth, logins: newLogins(secure), now: time.Now}
+
+	static, err := fs.Sub(files, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServerFS(static)))
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("GET /login", s.handleLogin)
+	mux.HandleFunc("GET /callback", s.handleCallback)
+	mux.HandleFunc("POST /logout", s.handleLogout)
+	mux.HandleFunc("GET /guilds/{guildID}", s.guildRoute(s.handleCalendar))
+	mux.HandleFunc("GET /guilds/{guildID}/history", s.guildRoute(s.handleHistory))
+	mux.HandleFunc("GET /guilds/{guildID}/messages/new", s.guildRoute(s.handleNew))
+	mux.HandleFunc("POST /guilds/{guildID}/messages", s.guildRoute(s.handleCreate))
+	mux.HandleFunc("GET /guilds/{guildID}/messages/{id}", s.guildRoute(s.handleEdit))
+	mux.HandleFunc("POST /guilds/{guildID}/messages/{id}", s.guildRoute(s.handleUpdate))
+	mux.HandleFunc("POST /guilds/{guildID}/messages/{id}/cancel", s.guildRoute(s.handleCancel))
+
+	s.Server = httpserver.New("dashboard", addr, mux)
+	return s
+}
+
+// page is what every page template is executed with
+type page struct {
+	Title     string
+	User      *discordgo.User      // nil when nobody is logged in
+	CSRFToken string               // sent with forms that change something
+	Guild     *discordgo.UserGuild // the guild being managed, nil outside a guild
+	Content   any                  // data of the page itself
+}
+
+// render answers with a page. It is rendered before anything is written, so a template error
+// still gets an error page.
+func (s *Server) render(w http.ResponseWriter, r *http.Request, status int, name string, data *page) {
+	var buf bytes.Buffer
+	if err := pages[name].ExecuteTemplate(&buf, "layout", data); err != nil {
+		slog.Error("Failed to render dashboard page", slog.String("page", name), slog.String("path", r.URL.Path), logging.Err(err))
+		http.Error(w, "Failed to render the page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// renderError answers with an error page
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, status int, current *login, message string) {
+	data := &page{Title: http.StatusText(status), Content: message}
+	if current != nil {
+		data.User = current.identity.User
+		data.CSRFToken = current.csrfToken
+	}
+	s.render(w, r, status, "error.html", data)
+}
+
+// renderInternalError logs an error that isn't the user's fault and answers with a 500
+func (s *Server) renderInternalError(w http.ResponseWriter, r *http.Request, current *login, message string, err error) {
+	slog.Error(message, slog.String("path", r.URL.Path), slog.String(logging.GuildID, r.PathValue("guildID")), logging.Err(err))
+	s.renderError(w, r, http.StatusInternalServerError, current, message+", please try again later.")
+}
+
+// newPage returns the page data for a logged in user
+func newPage(title string, current *login, guild *discordgo.UserGuild, content any) *page {
+	return &page{
+		Title:     title,
+		User:      current.identity.User,
+		CSRFToken: current.csrfToken,
+		Guild:     guild,
+		Content:   content,
+	}
+}
+
+// guildHandler handles a request about a guild the logged in user may manage
+type guildHandler func(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild)
+
+// guildRoute only lets logged in users through to the guilds they may manage, and checks
+// the CSRF token of forms
+func (s *Server) guildRoute(handler guildHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current := s.logins.get(r)
+		if current == nil {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+			s.renderError(w, r, http.StatusUnauthorized, nil, "Your login has expired, please log in again.")
+			return
+		}
+
+		guild := current.identity.guild(r.PathValue("guildID"))
+		if guild == nil {
+			s.renderError(w, r, http.StatusForbidden, current, "You need the Manage Server permission in that server to manage its scheduled messages.")
+			return
+		}
+		if r.Method == http.MethodPost {
+			r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+		}
+		if r.Method == http.MethodPost && !s.checkCSRF(r, current) {
+			s.renderError(w, r, http.StatusForbidden, current, "The form has expired, please go back, reload the page and try again.")
+			return
+		}
+		handler(w, r, current, guild)
+	}
+}
+
+// checkCSRF reports whether a form was sent from a dashboard page
+func (s *Server) checkCSRF(r *http.Request, current *login) bool {
+	return subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(current.csrfToken)) == 1
+}
@@ -0,0 +1,410 @@
+package dashboard
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi/discordtest"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/betauia/BetaBot.go/bot/storage/storagetest"
+	"github.com/bwmarrin/discordgo"
+)
+
+// unmanagedGuild is a guild the test user is in without Manage Server
+const unmanagedGuild = "200000000000000001"
+
+// testNow is the time of every request, early in the month so the calendar shows the posts
+var testNow = time.Date(2025, time.January, 6, 10, 0, 0, 0, time.UTC)
+
+var csrfField = regexp.MustCompile(`name="csrf" value="([0-9a-f]+)"`)
+
+// browser is a logged out browser on a dashboard that logs everyone in as the test user
+type browser struct {
+	t      *testing.T
+	store  storage.Store
+	server *httptest.Server
+	client *http.Client
+}
+
+func newBrowser(t *testing.T) *browser {
+	store := storagetest.OpenSQLite(t)
+
+	// The bot is only in the managed guild
+	auth := &StubAuth{Identity: &Identity{
+		User: &discordgo.User{ID: discordtest.UserID, Username: "board"},
+		Guilds: []*discordgo.UserGuild{
+			{ID: discordtest.GuildID, Name: "Test Server", Permissions: discordgo.PermissionManageGuild},
+			{ID: unmanagedGuild, Name: "Other Server", Permissions: discordgo.PermissionSendMessages},
+		},
+	}}
+
+	s := NewServer("127.0.0.1:0", store, discordtest.NewGuild(), auth, false)
+	s.now = func() time.Time { return testNow }
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Jar: jar,
+		// Redirects are followed by hand, to check where they go
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return &browser{t: t, store: store, server: server, client: client}
+}
+
+// get requests a page and returns its status, body and where it redirects to
+func (b *browser) get(path string) (int, string, string) {
+	b.t.Helper()
+	response, err := b.client.Get(b.server.URL + path)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return b.read(response)
+}
+
+// post sends a form
+func (b *browser) post(path string, form url.Values) (int, string, string) {
+	b.t.Helper()
+	response, err := b.client.PostForm(b.server.URL+path, form)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return b.read(response)
+}
+
+func (b *browser) read(response *http.Response) (int, string, string) {
+	b.t.Helper()
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return response.StatusCode, string(body), response.Header.Get("Location")
+}
+
+// login goes through the login flow and fails the test if it doesn't end on the server list
+func (b *browser) login() {
+	b.t.Helper()
+	status, _, callback := b.get("/login")
+	if status != http.StatusSeeOther || !strings.HasPrefix(callback, "/callback?") {
+		b.t.Fatalf("/login answered %d to %q, want a redirect to /callback", status, callback)
+	}
+	if status, _, location := b.get(callback); status != http.StatusSeeOther || location != "/" {
+		b.t.Fatalf("/callback answered %d to %q, want a redirect to /", status, location)
+	}
+}
+
+// csrf returns the CSRF token of the form on a page
+func (b *browser) csrf(path string) string {
+	b.t.Helper()
+	status, body, _ := b.get(path)
+	match := csrfField.FindStringSubmatch(body)
+	if status != http.StatusOK || match == nil {
+		b.t.Fatalf("%s answered %d without a CSRF token", path, status)
+	}
+	return match[1]
+}
+
+func formValues(csrf, title string) url.Values {
+	return url.Values{
+		"csrf":         {csrf},
+		"action":       {"save"},
+		"channel":      {discordtest.ChannelID},
+		"title":        {title},
+		"time":         {"07.01.2025 18:00"},
+		"message":      {"Meeting in the **usual** room"},
+		"roles":        {discordtest.RoleID},
+		"mass_mention": {""},
+	}
+}
+
+func listMessages(t *testing.T, store storage.Store) []*models.ScheduledMessage {
+	t.Helper()
+	list, err := store.ScheduledMessages().ListByGuild(discordtest.GuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestLogin(t *testing.T) {
+	b := newBrowser(t)
+	if status, body, _ := b.get("/"); status != http.StatusOK || !strings.Contains(body, `href="/login"`) {
+		t.Fatalf("logged out index answered %d without a login link", status)
+	}
+
+	b.login()
+	status, body, _ := b.get("/")
+	if status != http.StatusOK || !strings.Contains(body, "Test Server") {
+		t.Errorf("server list answered %d without the managed server", status)
+	}
+	if strings.Contains(body, "Other Server") {
+		t.Error("server list shows a server the user can't manage")
+	}
+}
+
+func TestCallbackChecksState(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    bool // go to /login first, which sets the state cookie
+		callback string
+	}{
+		{"no login started", false, "/callback?code=stub&state=abc"},
+		{"wrong state", true, "/callback?code=stub&state=abc"},
+		{"missing state", true, "/callback?code=stub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBrowser(t)
+			if tt.start {
+				b.get("/login")
+			}
+			if status, _, _ := b.get(tt.callback); status != http.StatusBadRequest {
+				t.Errorf("callback answered %d, want 400", status)
+			}
+			if status, _, location := b.get("/guilds/" + discordtest.GuildID); status != http.StatusSeeOther || location != "/login" {
+				t.Errorf("still logged out: answered %d to %q, want a redirect to /login", status, location)
+			}
+		})
+	}
+}
+
+func TestGuildAccess(t *testing.T) {
+	b := newBrowser(t)
+	if status, _, location := b.get("/guilds/" + discordtest.GuildID); status != http.StatusSeeOther || location != "/login" {
+		t.Errorf("logged out: answered %d to %q, want a redirect to /login", status, location)
+	}
+
+	b.login()
+	paths := []string{
+		"/guilds/" + unmanagedGuild,
+		"/guilds/" + unmanagedGuild + "/history",
+		"/guilds/" + unmanagedGuild + "/messages/new",
+		"/guilds/300000000000000001",
+	}
+	for _, path := range paths {
+		if status, body, _ := b.get(path); status != http.StatusForbidden || !strings.Contains(body, "Manage Server") {
+			t.Errorf("%s answered %d, want 403 asking for Manage Server", path, status)
+		}
+	}
+	if status, _, _ := b.get("/guilds/" + discordtest.GuildID); status != http.StatusOK {
+		t.Errorf("managed server answered %d, want 200", status)
+	}
+}
+
+func TestFormsNeedCSRFToken(t *testing.T) {
+	b := newBrowser(t)
+	b.login()
+	csrf := b.csrf("/guilds/" + discordtest.GuildID + "/messages/new")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"missing token", ""},
+		{"wrong token", strings.Repeat("0", len(csrf))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := formValues(tt.token, "Weekly meeting")
+			if tt.token == "" {
+				form.Del("csrf")
+			}
+			if status, _, _ := b.post("/guilds/"+discordtest.GuildID+"/messages", form); status != http.StatusForbidden {
+				t.Errorf("create answered %d, want 403", status)
+			}
+		})
+	}
+	if list := listMessages(t, b.store); len(list) != 0 {
+		t.Errorf("%d messages saved without a CSRF token", len(list))
+	}
+
+	if status, _, _ := b.post("/guilds/"+discordtest.GuildID+"/messages", formValues(csrf, "Weekly meeting")); status != http.StatusSeeOther {
+		t.Errorf("create with the token answered %d, want a redirect", status)
+	}
+}
+
+func TestCreateAndEdit(t *testing.T) {
+	b := newBrowser(t)
+	b.login()
+	csrf := b.csrf("/guilds/" + discordtest.GuildID + "/messages/new")
+
+	status, _, location := b.post("/guilds/"+discordtest.GuildID+"/messages", formValues(csrf, "Weekly meeting"))
+	if status != http.StatusSeeOther || location != "/guilds/"+discordtest.GuildID+"?month=2025-01" {
+		t.Fatalf("create answered %d to %q, want a redirect to the calendar", status, location)
+	}
+	list := listMessages(t, b.store)
+	if len(list) != 1 {
+		t.Fatalf("%d messages saved, want 1", len(list))
+	}
+	msg := list[0]
+	loc := storage.GuildLocation(b.store, discordtest.GuildID)
+	if msg.Title != "Weekly meeting" || msg.ChannelID != discordtest.ChannelID || msg.UserID != discordtest.UserID ||
+		!msg.ScheduledTime.Equal(time.Date(2025, time.January, 7, 18, 0, 0, 0, loc)) || !slices.Equal(msg.MentionRoleIDs(), []string{discordtest.RoleID}) {
+		t.Errorf("saved %+v", msg)
+	}
+
+	// The edit form is filled in with what was saved
+	path := "/guilds/" + discordtest.GuildID + "/messages/" + strconv.FormatInt(msg.ID, 10)
+	status, body, _ := b.get(path)
+	if status != http.StatusOK {
+		t.Fatalf("edit form answered %d", status)
+	}
+	for _, want := range []string{`value="Weekly meeting"`, `value="07.01.2025 18:00"`, "Meeting in the **usual** room", `value="` + discordtest.RoleID + `" checked`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("edit form is missing %s", want)
+		}
+	}
+
+	// A preview shows the change without saving it
+	form := formValues(csrf, "Board meeting")
+	form.Set("time", "08.01.2025 19:30")
+	form.Set("action", "preview")
+	status, body, _ = b.post(path, form)
+	if status != http.StatusOK || !strings.Contains(body, "<strong>usual</strong>") {
+		t.Errorf("preview answered %d without the rendered Markdown", status)
+	}
+	if got, _ := b.store.ScheduledMessages().GetByID(msg.ID); got.Title != "Weekly meeting" {
+		t.Errorf("the preview saved the title %q", got.Title)
+	}
+
+	form.Set("action", "save")
+	if status, _, _ := b.post(path, form); status != http.StatusSeeOther {
+		t.Fatalf("save answered %d, want a redirect", status)
+	}
+	got, err := b.store.ScheduledMessages().GetByID(msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Board meeting" || !got.ScheduledTime.Equal(time.Date(2025, time.January, 8, 19, 30, 0, 0, loc)) {
+		t.Errorf("after editing: %q at %v", got.Title, got.ScheduledTime)
+	}
+}
+
+func TestFormErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(form url.Values)
+		want   int
+		error  string
+	}{
+		{"bad time", func(form url.Values) { form.Set("time", "someday") }, http.StatusBadRequest, ""},
+		{"unknown channel", func(form url.Values) { form.Set("channel", "300000000000000002") }, http.StatusBadRequest, "Choose the channel"},
+		{"unknown role", func(form url.Values) { form.Set("roles", "300000000000000004") }, http.StatusBadRequest, "300000000000000004"},
+		{"title taken", func(form url.Values) { form.Set("title", "Existing one") }, http.StatusConflict, "Existing one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBrowser(t)
+			b.login()
+			csrf := b.csrf("/guilds/" + discordtest.GuildID + "/messages/new")
+			b.post("/guilds/"+discordtest.GuildID+"/messages", formValues(csrf, "Existing one"))
+
+			form := formValues(csrf, "Weekly meeting")
+			tt.change(form)
+			status, body, _ := b.post("/guilds/"+discordtest.GuildID+"/messages", form)
+			if status != tt.want || !strings.Contains(body, tt.error) {
+				t.Errorf("create answered %d, want %d mentioning %q", status, tt.want, tt.error)
+			}
+			// The form is shown again with what was entered
+			if !strings.Contains(body, "Meeting in the **usual** room") {
+				t.Error("the form lost the message text")
+			}
+			if list := listMessages(t, b.store); len(list) != 1 {
+				t.Errorf("%d messages saved, want only the existing one", len(list))
+			}
+		})
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	b := newBrowser(t)
+	b.login()
+	csrf := b.csrf("/guilds/" + discordtest.GuildID + "/messages/new")
+
+	b.post("/guilds/"+discordtest.GuildID+"/messages", formValues(csrf, "Weekly meeting"))
+	form := formValues(csrf, "Daily standup")
+	form.Set("time", "08.01.2025 09:00")
+	form.Set("repeat", "daily")
+	b.post("/guilds/"+discordtest.GuildID+"/messages", form)
+	if list := listMessages(t, b.store); len(list) != 2 {
+		t.Fatalf("%d messages saved, want 2", len(list))
+	}
+
+	status, body, _ := b.get("/guilds/" + discordtest.GuildID)
+	if status != http.StatusOK {
+		t.Fatalf("calendar answered %d", status)
+	}
+	for _, want := range []string{"January 2025", "?month=2024-12", "?month=2025-02", "18:00</time> Weekly meeting", "09:00</time> Daily standup", "entry repeat"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("calendar is missing %s", want)
+		}
+	}
+	// The recurring message is on every day from the 8th to the end of the month
+	if count := strings.Count(body, "Daily standup"); count < 24 {
+		t.Errorf("the daily message is shown %d times, want every day from the 8th", count)
+	}
+
+	// In March only the recurring message is on the calendar, the list of next posts has both
+	status, body, _ = b.get("/guilds/" + discordtest.GuildID + "?month=2025-03")
+	if status != http.StatusOK || !strings.Contains(body, "March 2025") || !strings.Contains(body, "09:00</time> Daily standup") {
+		t.Errorf("March answered %d without the recurring message", status)
+	}
+	if strings.Contains(body, "18:00</time> Weekly meeting") {
+		t.Error("March shows the post of January 7th")
+	}
+	if status, _, _ := b.get("/guilds/" + discordtest.GuildID + "?month=soon"); status != http.StatusBadRequest {
+		t.Errorf("a bad month answered %d, want 400", status)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	b := newBrowser(t)
+	b.login()
+	if status, body, _ := b.get("/guilds/" + discordtest.GuildID + "/history"); status != http.StatusOK || !strings.Contains(body, "Nothing has been posted yet.") {
+		t.Errorf("empty history answered %d without saying so", status)
+	}
+
+	deliveries := []*models.Delivery{
+		{ScheduledMessageID: 1, GuildID: discordtest.GuildID, ChannelID: discordtest.ChannelID, Title: "Weekly meeting", PostedMessageID: "300000000000000001", Status: models.StatusSent, DeliveredAt: testNow.Add(-time.Hour)},
+		{ScheduledMessageID: 2, GuildID: discordtest.GuildID, ChannelID: "300000000000000002", Title: "Lost channel", Status: models.StatusFailed, Error: "Unknown Channel", DeliveredAt: testNow},
+		{ScheduledMessageID: 3, GuildID: unmanagedGuild, ChannelID: discordtest.ChannelID, Title: "Somewhere else", Status: models.StatusSent, DeliveredAt: testNow},
+	}
+	for _, delivery := range deliveries {
+		if err := b.store.Deliveries().Create(delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, body, _ := b.get("/guilds/" + discordtest.GuildID + "/history")
+	if status != http.StatusOK {
+		t.Fatalf("history answered %d", status)
+	}
+	for _, want := range []string{
+		"Weekly meeting", "#general", deliveries[0].JumpURL(),
+		"Lost channel", "#300000000000000002", "Unknown Channel",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("history is missing %s", want)
+		}
+	}
+	if strings.Index(body, "Lost channel") > strings.Index(body, "Weekly meeting") {
+		t.Error("history isn't newest first")
+	}
+	if strings.Contains(body, "Somewhere else") {
+		t.Error("history shows a delivery of another server")
+	}
+}
@@ -0,0 +1,40 @@
+package dashboard
+
+import (
+	"net/http"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// historyLimit is how many deliveries the history shows
+const historyLimit = 100
+
+type historyView struct {
+	Deliveries []*models.Delivery
+	Channels   map[string]string // channel names by ID, for the channels the bot can see
+	Timezone   string
+}
+
+// handleHistory shows the most recent delivery attempts of a guild, newest first
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) {
+	deliveries, err := s.store.Deliveries().ListRecentByGuild(guild.ID, historyLimit)
+	if err != nil {
+		s.renderInternalError(w, r, current, "Failed to list deliveries", err)
+		return
+	}
+
+	loc := storage.GuildLocation(s.store, guild.ID)
+	for _, delivery := range deliveries {
+		delivery.DeliveredAt = delivery.DeliveredAt.In(loc)
+	}
+
+	view := &historyView{Deliveries: deliveries, Channels: map[string]string{}, Timezone: loc.String()}
+	if loaded, err := s.discord.LoadGuild(guild.ID); err == nil {
+		for _, channel := range loaded.Channels {
+			view.Channels[channel.ID] = channel.Name
+		}
+	}
+	s.render(w, r, http.StatusOK, "history.html", newPage("History", current, guild, view))
+}
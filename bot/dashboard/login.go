@@ -0,0 +1,75 @@
+package dashboard
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/logging"
+)
+
+// stateDuration is how long a login may take on Discord's side
+const stateDuration = 10 * time.Minute
+
+// handleIndex shows the guilds the user may manage, or asks them to log in
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	current := s.logins.get(r)
+	if current == nil {
+		s.render(w, r, http.StatusOK, "login.html", &page{Title: "Log in"})
+		return
+	}
+
+	// Only list the servers the bot is in, it can't post anywhere else
+	guilds := current.identity.ManagedGuilds()
+	joined := guilds[:0:0]
+	for _, guild := range guilds {
+		if _, err := s.discord.LoadGuild(guild.ID); err == nil {
+			joined = append(joined, guild)
+		}
+	}
+	s.render(w, r, http.StatusOK, "guilds.html", newPage("Servers", current, nil, joined))
+}
+
+// handleLogin sends the browser to log in, remembering the state to check in the callback
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	state := randomToken()
+	http.SetCookie(w, s.logins.cookie(stateCookie, state, stateDuration))
+	http.Redirect(w, r, s.auth.LoginURL(state), http.StatusSeeOther)
+}
+
+// handleCallback logs the browser in when it comes back from logging in
+func (s *Server) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		s.renderError(w, r, http.StatusUnauthorized, nil, "Logging in with Discord was cancelled: "+query.Get("error_description"))
+		return
+	}
+
+	// The state ties the callback to a login started in this browser
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		s.renderError(w, r, http.StatusBadRequest, nil, "The login has expired or was started somewhere else, please log in again.")
+		return
+	}
+	http.SetCookie(w, s.logins.cookie(stateCookie, "", -1))
+
+	identity, err := s.auth.Exchange(r.Context(), query.Get("code"))
+	if err != nil {
+		slog.Warn("Dashboard login failed", logging.Err(err))
+		s.renderError(w, r, http.StatusBadGateway, nil, "Discord didn't let you log in, please try again.")
+		return
+	}
+
+	s.logins.create(w, identity)
+	slog.Info("Logged in to the dashboard", slog.String(logging.UserID, identity.User.ID), slog.Int("managed_guilds", len(identity.ManagedGuilds())))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleLogout logs the browser out
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if current := s.logins.get(r); current != nil && s.checkCSRF(r, current) {
+		s.logins.remove(w, r)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
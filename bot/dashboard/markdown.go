@@ -0,0 +1,233 @@
+package dashboard
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// names resolves the mentions and timestamps in a message for its preview, nil shows them plainly
+type names struct {
+	roles    map[string]string // role names by ID
+	channels map[string]string // channel names by ID
+	loc      *time.Location
+}
+
+var (
+	codeSpan = regexp.MustCompile("``(.+?)``|`([^`]+)`")
+	link     = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^\s)]+)\)|https?://[^\s<]+`)
+	mention  = regexp.MustCompile(`&lt;(@!?|@&amp;|#)(\d+)&gt;|&lt;t:(-?\d+)(?::([tTdDfFR]))?&gt;`)
+
+	// Applied in order, so ** is bold before * is italic
+	emphasis = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`\*\*(.+?)\*\*`), "<strong>$1</strong>"},
+		{regexp.MustCompile(`__(.+?)__`), "<u>$1</u>"},
+		{regexp.MustCompile(`\*(.+?)\*`), "<em>$1</em>"},
+		{regexp.MustCompile(`\b_(.+?)_\b`), "<em>$1</em>"},
+		{regexp.MustCompile(`~~(.+?)~~`), "<s>$1</s>"},
+		{regexp.MustCompile(`\|\|(.+?)\|\|`), `<span class="spoiler">$1</span>`},
+	}
+
+	orderedItem = regexp.MustCompile(`^\d+\. `)
+)
+
+// renderMarkdown renders the Markdown Discord supports in messages: emphasis, code, headings,
+// lists, quotes, links, mentions and timestamps. Everything else is shown as written, so the
+// preview never shows more than Discord would.
+func renderMarkdown(text string, names *names) template.HTML {
+	var out strings.Builder
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	// list is the open <ul> or <ol>, quote whether a <blockquote> is open
+	list, quote := "", false
+	closeBlocks := func() {
+		if list != "" {
+			out.WriteString("</" + list + ">")
+			list = ""
+		}
+		if quote {
+			out.WriteString("</blockquote>")
+			quote = false
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		// A code block runs until the closing fence, or the end of the message
+		if strings.HasPrefix(line, "```") {
+			closeBlocks()
+			var code []string
+			if rest := strings.TrimPrefix(line, "```"); strings.Contains(rest, "```") {
+				code = append(code, rest[:strings.Index(rest, "```")])
+			} else {
+				for i++; i < len(lines) && !strings.HasPrefix(lines[i], "```"); i++ {
+					code = append(code, lines[i])
+				}
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>")
+			continue
+		}
+
+		// >>> quotes the rest of the message, > a single line
+		if rest, ok := strings.CutPrefix(line, ">>> "); ok {
+			closeBlocks()
+			lines[i] = rest
+			out.WriteString("<blockquote>" + string(renderMarkdown(strings.Join(lines[i:], "\n"), names)) + "</blockquote>")
+			break
+		}
+		if rest, ok := strings.CutPrefix(line, "> "); ok {
+			if list != "" {
+				closeBlocks()
+			}
+			if !quote {
+				out.WriteString("<blockquote>")
+				quote = true
+			} else {
+				out.WriteString("<br>")
+			}
+			out.WriteString(renderInline(rest, names))
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* "):
+			openList(&out, &list, &quote, "ul")
+			out.WriteString("<li>" + renderInline(line[2:], names) + "</li>")
+		case orderedItem.MatchString(line):
+			openList(&out, &list, &quote, "ol")
+			out.WriteString("<li>" + renderInline(orderedItem.ReplaceAllString(line, ""), names) + "</li>")
+		case strings.HasPrefix(line, "### "):
+			closeBlocks()
+			out.WriteString("<h3>" + renderInline(line[4:], names) + "</h3>")
+		case strings.HasPrefix(line, "## "):
+			closeBlocks()
+			out.WriteString("<h2>" + renderInline(line[3:], names) + "</h2>")
+		case strings.HasPrefix(line, "# "):
+			closeBlocks()
+			out.WriteString("<h1>" + renderInline(line[2:], names) + "</h1>")
+		case strings.HasPrefix(line, "-# "):
+			closeBlocks()
+			out.WriteString(`<div class="subtext">` + renderInline(line[3:], names) + "</div>")
+		default:
+			closeBlocks()
+			out.WriteString(`<div class="line">` + renderInline(line, names) + "</div>")
+		}
+	}
+	closeBlocks()
+	return template.HTML(out.String())
+}
+
+// openList starts a list of the given kind, unless one is open already
+func openList(out *strings.Builder, list *string, quote *bool, kind string) {
+	if *quote {
+		out.WriteString("</blockquote>")
+		*quote = false
+	}
+	if *list == kind {
+		return
+	}
+	if *list != "" {
+		out.WriteString("</" + *list + ">")
+	}
+	out.WriteString("<" + kind + ">")
+	*list = kind
+}
+
+// renderInline renders the Markdown within a line. Code, links and mentions are swapped for
+// placeholders while the emphasis is applied, so it never reaches into their HTML.
+func renderInline(text string, names *names) string {
+	text = strings.ReplaceAll(text, "\x00", "")
+	var kept []string
+	keep := func(fragment string) string {
+		kept = append(kept, fragment)
+		return fmt.Sprintf("\x00%d\x00", len(kept)-1)
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, match := range codeSpan.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(html.EscapeString(text[last:match[0]]))
+		code := text[match[4]:match[5]]
+		if match[2] >= 0 {
+			code = text[match[2]:match[3]]
+		}
+		out.WriteString(keep("<code>" + html.EscapeString(code) + "</code>"))
+		last = match[1]
+	}
+	out.WriteString(html.EscapeString(text[last:]))
+	escaped := out.String()
+
+	escaped = link.ReplaceAllStringFunc(escaped, func(match string) string {
+		parts := link.FindStringSubmatch(match)
+		label, href := parts[1], parts[2]
+		if href == "" {
+			label, href = match, match
+		}
+		return keep(`<a href="` + href + `" target="_blank" rel="noopener noreferrer">` + label + "</a>")
+	})
+	escaped = mention.ReplaceAllStringFunc(escaped, func(match string) string {
+		return keep(renderMention(mention.FindStringSubmatch(match), names))
+	})
+	for _, e := range emphasis {
+		escaped = e.pattern.ReplaceAllString(escaped, e.replacement)
+	}
+
+	for i := len(kept) - 1; i >= 0; i-- {
+		escaped = strings.Replace(escaped, fmt.Sprintf("\x00%d\x00", i), kept[i], 1)
+	}
+	return escaped
+}
+
+// renderMention shows a mention like Discord does, with the name instead of the ID
+func renderMention(parts []string, names *names) string {
+	kind, id := parts[1], parts[2]
+	if parts[3] != "" {
+		unix, _ := strconv.ParseInt(parts[3], 10, 64)
+		return `<span class="mention">` + html.EscapeString(formatTimestamp(time.Unix(unix, 0), parts[4], names)) + "</span>"
+	}
+
+	var label string
+	switch kind {
+	case "@&amp;":
+		label = "@role"
+		if names != nil && names.roles[id] != "" {
+			label = "@" + names.roles[id]
+		}
+	case "#":
+		label = "#channel"
+		if names != nil && names.channels[id] != "" {
+			label = "#" + names.channels[id]
+		}
+	default:
+		label = "@user"
+	}
+	return `<span class="mention">` + html.EscapeString(label) + "</span>"
+}
+
+// formatTimestamp formats a <t:unix:style> timestamp, which Discord shows in each reader's timezone
+func formatTimestamp(t time.Time, style string, names *names) string {
+	if names != nil && names.loc != nil {
+		t = t.In(names.loc)
+	}
+	switch style {
+	case "t":
+		return t.Format("15:04")
+	case "T":
+		return t.Format("15:04:05")
+	case "d":
+		return t.Format("02.01.2006")
+	case "D":
+		return t.Format("2 January 2006")
+	case "F":
+		return t.Format("Monday, 2 January 2006 15:04")
+	}
+	return t.Format("2 January 2006 15:04")
+}
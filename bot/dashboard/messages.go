@@ -0,0 +1,367 @@
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/betauia/BetaBot.go/bot/embeds"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/schedule"
+	"github.com/betauia/BetaBot.go/bot/scheduler"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// formTimeLayout is how the scheduled time is filled in when editing, dateparse reads it back
+const formTimeLayout = "02.01.2006 15:04"
+
+// messageForm is the form to create or edit a message, as entered
+type messageForm struct {
+	Message     *models.ScheduledMessage // the message being edited, nil for a new one
+	ReadOnly    bool                     // the message was sent or cancelled and can't be edited
+	Attachments int                      // files added in Discord, kept when the message is edited
+
+	ChannelID   string
+	Title       string
+	Time        string
+	Repeat      string
+	Text        string
+	Embed       string
+	RoleIDs     []string
+	MassMention string
+
+	Channels []*discordgo.Channel // the text channels messages can be posted in
+	Roles    []*discordgo.Role    // the roles that can be pinged
+	Timezone string
+
+	Error   string
+	Preview *preview
+}
+
+// HasRole reports whether the role is pinged, for the role checkboxes
+func (f *messageForm) HasRole(roleID string) bool {
+	return slices.Contains(f.RoleIDs, roleID)
+}
+
+// preview is a message as it will be posted
+type preview struct {
+	Heading string
+	Content template.HTML
+	Embed   *embedPreview
+	Warning string // the message can be saved, but something in it won't be posted as written
+}
+
+type embedPreview struct {
+	Title       string
+	URL         string
+	Color       string // CSS color of the left border
+	Description template.HTML
+	Fields      []embedFieldPreview
+	Image       string
+	Thumbnail   string
+	Footer      string
+}
+
+type embedFieldPreview struct {
+	Name   string
+	Value  template.HTML
+	Inline bool
+}
+
+// handleNew shows an empty form
+func (s *Server) handleNew(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) {
+	form := &messageForm{}
+	if !s.loadChoices(w, r, current, guild, form) {
+		return
+	}
+	s.render(w, r, http.StatusOK, "message.html", newPage("New message", current, guild, form))
+}
+
+// handleCreate previews or schedules a new message
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) {
+	msg := &models.ScheduledMessage{GuildID: guild.ID, UserID: current.identity.User.ID}
+	s.submit(w, r, current, guild, msg, 0, "New message")
+}
+
+// handleEdit shows the form filled in with a message
+func (s *Server) handleEdit(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) {
+	msg, ok := s.lookupMessage(w, r, current, guild)
+	if !ok {
+		return
+	}
+	attachments, err := s.store.Attachments().ListByMessage(msg.ID)
+	if err != nil {
+		s.renderInternalError(w, r, current, "Failed to load attachments", err)
+		return
+	}
+
+	loc := storage.GuildLocation(s.store, guild.ID)
+	form := &messageForm{
+		Message:     msg,
+		ReadOnly:    !msg.IsActive(),
+		Attachments: len(attachments),
+		ChannelID:   msg.ChannelID,
+		Title:       msg.Title,
+		Time:        msg.ScheduledTime.In(loc).Format(formTimeLayout),
+		Text:        msg.Message,
+		Embed:       msg.Embed,
+		RoleIDs:     msg.MentionRoleIDs(),
+		MassMention: string(msg.MassMention),
+	}
+	if spec := schedule.Recurrence(msg, loc); spec != nil {
+		form.Repeat = spec.String()
+	}
+	if !s.loadChoices(w, r, current, guild, form) {
+		return
+	}
+	s.render(w, r, http.StatusOK, "message.html", newPage(msg.Title, current, guild, form))
+}
+
+// handleUpdate previews or saves the changes to a pending or failed message, which
+// reschedules a failed one
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) {
+	msg, ok := s.lookupMessage(w, r, current, guild)
+	if !ok {
+		return
+	}
+	if !msg.IsActive() {
+		s.renderError(w, r, http.StatusConflict, current, fmt.Sprintf("**%s** is already %s and can't be edited.", msg.Title, msg.Status))
+		return
+	}
+	attachments, err := s.store.Attachments().ListByMessage(msg.ID)
+	if err != nil {
+		s.renderInternalError(w, r, current, "Failed to load attachments", err)
+		return
+	}
+	s.submit(w, r, current, guild, msg, len(attachments), msg.Title)
+}
+
+// handleCancel cancels a message, keeping it in the history
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) {
+	msg, ok := s.lookupMessage(w, r, current, guild)
+	if !ok {
+		return
+	}
+	if err := s.store.ScheduledMessages().Cancel(msg); errors.Is(err, models.ErrNotActive) {
+		s.renderError(w, r, http.StatusConflict, current, fmt.Sprintf("**%s** is no longer pending, so it can't be cancelled.", msg.Title))
+		return
+	} else if err != nil {
+		s.renderInternalError(w, r, current, "Failed to cancel the scheduled message", err)
+		return
+	}
+	scheduler.ScheduleMessage(msg)
+
+	slog.Info("Scheduled message cancelled on the dashboard", slog.String(logging.GuildID, guild.ID), slog.Int64(logging.ScheduledMessageID, msg.ID), slog.String(logging.UserID, current.identity.User.ID))
+	http.Redirect(w, r, "/guilds/"+guild.ID, http.StatusSeeOther)
+}
+
+// submit checks the form like /schedule does, and shows a preview or saves it depending on
+// the button that was pressed. A new message has ID 0.
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild, msg *models.ScheduledMessage, attachments int, title string) {
+	form := &messageForm{
+		Attachments: attachments,
+		ChannelID:   r.PostFormValue("channel"),
+		Title:       r.PostFormValue("title"),
+		Time:        r.PostFormValue("time"),
+		Repeat:      r.PostFormValue("repeat"),
+		Text:        r.PostFormValue("message"),
+		Embed:       r.PostFormValue("embed"),
+		RoleIDs:     r.PostForm["roles"],
+		MassMention: r.PostFormValue("mass_mention"),
+	}
+	if msg.ID != 0 {
+		form.Message = msg
+	}
+	if !s.loadChoices(w, r, current, guild, form) {
+		return
+	}
+
+	// Check a copy, so a failed check leaves nothing behind on the message
+	checked := *msg
+	status, err := s.applyForm(&checked, form)
+	if err != nil && status == http.StatusInternalServerError {
+		s.renderInternalError(w, r, current, "Failed to check the scheduled message", err)
+		return
+	}
+	if err != nil {
+		form.Error = err.Error()
+		s.render(w, r, status, "message.html", newPage(title, current, guild, form))
+		return
+	}
+
+	if r.PostFormValue("action") == "preview" {
+		form.Preview = s.buildPreview(&checked, form)
+		s.render(w, r, http.StatusOK, "message.html", newPage(title, current, guild, form))
+		return
+	}
+
+	*msg = checked
+	if msg.ID == 0 {
+		err = s.store.ScheduledMessages().Create(msg)
+	} else {
+		msg.ResetDelivery()
+		err = s.store.ScheduledMessages().Update(msg)
+	}
+	if errors.Is(err, models.ErrNotActive) {
+		form.Error = "The message is being sent right now, so it can't be edited. Reload the page to see what was posted."
+		s.render(w, r, http.StatusConflict, "message.html", newPage(title, current, guild, form))
+		return
+	}
+	if err != nil {
+		s.renderInternalError(w, r, current, "Failed to save the scheduled message", err)
+		return
+	}
+	scheduler.ScheduleMessage(msg)
+
+	slog.Info("Scheduled message saved on the dashboard", slog.String(logging.GuildID, guild.ID), slog.Int64(logging.ScheduledMessageID, msg.ID), slog.String(logging.UserID, current.identity.User.ID))
+	loc := storage.GuildLocation(s.store, guild.ID)
+	http.Redirect(w, r, fmt.Sprintf("/guilds/%s?month=%s", guild.ID, msg.ScheduledTime.In(loc).Format("2006-01")), http.StatusSeeOther)
+}
+
+// applyForm checks the form and copies it onto msg. The status is the one to answer with
+// when it can't be used, a 500 for errors that aren't the user's.
+func (s *Server) applyForm(msg *models.ScheduledMessage, form *messageForm) (int, error) {
+	parsed, err := schedule.Parse(schedule.Input{
+		Title:   form.Title,
+		Message: form.Text,
+		Time:    form.Time,
+		Repeat:  form.Repeat,
+		Embed:   form.Embed,
+	}, s.now(), storage.GuildLocation(s.store, msg.GuildID))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := schedule.CheckContent(parsed.Message, parsed.Embed, form.Attachments > 0); err != nil {
+		return http.StatusBadRequest, errors.New("The message needs text or an embed.")
+	}
+	mass := models.MassMention(form.MassMention)
+	if err := schedule.CheckMassMention(mass); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if !slices.ContainsFunc(form.Channels, func(channel *discordgo.Channel) bool { return channel.ID == form.ChannelID }) {
+		return http.StatusBadRequest, errors.New("Choose the channel to post the message in.")
+	}
+	for _, roleID := range form.RoleIDs {
+		if !slices.ContainsFunc(form.Roles, func(role *discordgo.Role) bool { return role.ID == roleID }) {
+			return http.StatusBadRequest, fmt.Errorf("The role %s doesn't exist any more, reload the page and choose again.", roleID)
+		}
+	}
+
+	if err := schedule.CheckTitle(s.store, msg.GuildID, parsed.Title, msg.ID); errors.Is(err, schedule.ErrTitleTaken) {
+		return http.StatusConflict, fmt.Errorf("Another scheduled message is already called **%s**, please choose another title.", parsed.Title)
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	parsed.Apply(msg)
+	msg.ChannelID = form.ChannelID
+	msg.SetMentions(form.RoleIDs, msg.MentionUsers(), mass)
+	return 0, nil
+}
+
+// buildPreview renders a checked message the way Discord will show it
+func (s *Server) buildPreview(msg *models.ScheduledMessage, form *messageForm) *preview {
+	loc := storage.GuildLocation(s.store, msg.GuildID)
+	resolve := &names{roles: map[string]string{}, channels: map[string]string{}, loc: loc}
+	for _, role := range form.Roles {
+		resolve.roles[role.ID] = role.Name
+	}
+	for _, channel := range form.Channels {
+		resolve.channels[channel.ID] = channel.Name
+	}
+
+	result := &preview{
+		Heading: fmt.Sprintf("Posted in #%s on %s", resolve.channels[msg.ChannelID], msg.ScheduledTime.In(loc).Format("Monday 02.01.2006 at 15:04 MST")),
+	}
+	if spec := schedule.Recurrence(msg, loc); spec != nil {
+		result.Heading += ", repeating " + spec.String()
+	}
+
+	content, err := scheduler.BuildMessageContent(msg, scheduler.TemplateData(s.discord, s.store, msg))
+	if err != nil {
+		result.Warning = fmt.Sprintf("This message can't be posted as written: %v", err)
+		content = msg.Message
+	}
+	result.Content = renderMarkdown(content, resolve)
+
+	if spec, err := embeds.Parse(msg.Embed); msg.Embed != "" && err == nil {
+		embed := &embedPreview{
+			Title:       spec.Title,
+			URL:         spec.URL,
+			Description: renderMarkdown(spec.Description, resolve),
+			Image:       spec.ImageURL,
+			Thumbnail:   spec.ThumbnailURL,
+			Footer:      spec.Footer,
+		}
+		if spec.Color != 0 {
+			embed.Color = fmt.Sprintf("#%06x", int(spec.Color))
+		}
+		if spec.Timestamp {
+			if embed.Footer != "" {
+				embed.Footer += " • "
+			}
+			embed.Footer += msg.ScheduledTime.In(loc).Format("02.01.2006 15:04")
+		}
+		for _, field := range spec.Fields {
+			embed.Fields = append(embed.Fields, embedFieldPreview{Name: field.Name, Value: renderMarkdown(field.Value, resolve), Inline: field.Inline})
+		}
+		result.Embed = embed
+	}
+	return result
+}
+
+// loadChoices fills in the channels and roles the form offers
+func (s *Server) loadChoices(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild, form *messageForm) bool {
+	loaded, err := s.discord.LoadGuild(guild.ID)
+	if err != nil {
+		s.renderInternalError(w, r, current, "Failed to load the channels and roles of the server", err)
+		return false
+	}
+	channels, roles := loaded.Channels, loaded.Roles
+
+	form.Channels = nil
+	for _, channel := range channels {
+		if channel.Type == discordgo.ChannelTypeGuildText || channel.Type == discordgo.ChannelTypeGuildNews {
+			form.Channels = append(form.Channels, channel)
+		}
+	}
+	slices.SortFunc(form.Channels, func(a, b *discordgo.Channel) int { return a.Position - b.Position })
+
+	// @everyone is offered as a mass mention instead, and bot roles can't be pinged usefully
+	form.Roles = nil
+	for _, role := range roles {
+		if role.ID != guild.ID && !role.Managed {
+			form.Roles = append(form.Roles, role)
+		}
+	}
+	slices.SortFunc(form.Roles, func(a, b *discordgo.Role) int { return b.Position - a.Position })
+
+	form.Timezone = storage.GuildLocation(s.store, guild.ID).String()
+	return true
+}
+
+// lookupMessage loads the message in the path, answering with a 404 if it isn't in the guild
+func (s *Server) lookupMessage(w http.ResponseWriter, r *http.Request, current *login, guild *discordgo.UserGuild) (*models.ScheduledMessage, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound, current, "There is no such scheduled message.")
+		return nil, false
+	}
+	msg, err := s.store.ScheduledMessages().GetByID(id)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && msg.GuildID != guild.ID) {
+		s.renderError(w, r, http.StatusNotFound, current, "There is no such scheduled message in this server.")
+		return nil, false
+	}
+	if err != nil {
+		s.renderInternalError(w, r, current, "Failed to get the scheduled message", err)
+		return nil, false
+	}
+	return msg, true
+}
@@ -1,38 +1,28 @@
 package bot
 
 import (
-	"database/sql"
-	"log"
+	"log/slog"
+	"os"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/storage"
 )
 
-var DB *sql.DB
+var Store storage.Store
 
-func InitDatabase() {
+// InitDatabase opens the database at databaseURL, see storage.Open
+func InitDatabase(databaseURL string) {
 	var err error
-	DB, err = sql.Open("sqlite3", "./db/betabot.db")
+	Store, err = storage.Open(databaseURL)
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+		slog.Error("Failed to open database", logging.Err(err))
+		os.Exit(1)
 	}
 
-	// Create the scheduled_messages table if it doesn't exist
-	query := `
-	CREATE TABLE IF NOT EXISTS scheduled_messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		title TEXT NOT NULL UNIQUE,
-		guild_id TEXT NOT NULL,
-		role_id TEXT NOT NULL,
-		user_id TEXT NOT NULL,
-		message TEXT NOT NULL,
-		scheduled_time DATETIME NOT NULL,
-		channel_id TEXT NOT NULL
-	);
-	`
-	_, err = DB.Exec(query)
+	version, err := Store.SchemaVersion()
 	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+		slog.Error("Failed to read database schema version", slog.String("backend", Store.Backend()), logging.Err(err))
+		os.Exit(1)
 	}
-
-	log.Println("Database initialized successfully")
+	slog.Info("Database initialized", slog.String("backend", Store.Backend()), slog.Int("schema_version", version))
 }
@@ -0,0 +1,274 @@
+package dateparse
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // make sure Europe/Oslo is available even without system tzdata
+)
+
+// DefaultTimezone is used for guilds that haven't configured their own timezone,
+// unless another default is configured with SetDefaultTimezone
+const DefaultTimezone = "Europe/Oslo"
+
+// DefaultLocation is the loaded default timezone
+var DefaultLocation = mustLoadLocation(DefaultTimezone)
+
+// ErrInPast is returned when the parsed time is not in the future
+var ErrInPast = errors.New("the time is in the past")
+
+// CommonTimezones are suggested when configuring a guild timezone; any IANA name is accepted
+var CommonTimezones = []string{
+	"Europe/Oslo", "Europe/Stockholm", "Europe/Copenhagen", "Europe/Helsinki", "Europe/London",
+	"Europe/Dublin", "Europe/Berlin", "Europe/Amsterdam", "Europe/Paris", "Europe/Madrid",
+	"Europe/Rome", "Europe/Warsaw", "Europe/Athens", "Atlantic/Reykjavik", "UTC",
+	"America/New_York", "America/Chicago", "America/Denver", "America/Los_Angeles",
+	"Asia/Tokyo", "Asia/Singapore", "Asia/Kolkata", "Australia/Sydney",
+}
+
+var absoluteLayouts = []string{
+	"2.1.2006 15:04",
+	"2.1.2006 15.04",
+	"2.1.2006",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// layouts without a year, which resolve to the next matching date
+var yearlessLayouts = []string{
+	"2.1 15:04",
+	"2.1 15.04",
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "søndag": time.Sunday, "sondag": time.Sunday,
+	"monday": time.Monday, "mandag": time.Monday,
+	"tuesday": time.Tuesday, "tirsdag": time.Tuesday,
+	"wednesday": time.Wednesday, "onsdag": time.Wednesday,
+	"thursday": time.Thursday, "torsdag": time.Thursday,
+	"friday": time.Friday, "fredag": time.Friday,
+	"saturday": time.Saturday, "lørdag": time.Saturday, "lordag": time.Saturday,
+}
+
+// dayOffsets maps relative day words to the number of days from today
+var dayOffsets = map[string]int{
+	"today": 0, "i dag": 0, "idag": 0,
+	"tomorrow": 1, "i morgen": 1, "imorgen": 1,
+	"day after tomorrow": 2, "i overmorgen": 2, "overmorgen": 2,
+}
+
+var durationUnits = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"minutt": time.Minute, "minutter": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"t": time.Hour, "time": time.Hour, "timer": time.Hour,
+}
+
+// calendar units are added with AddDate so they keep the wall clock across DST changes
+var calendarUnits = map[string]int{
+	"d": 1, "day": 1, "days": 1, "dag": 1, "dager": 1, "døgn": 1,
+	"w": 7, "week": 7, "weeks": 7, "uke": 7, "uker": 7,
+}
+
+var durationPart = regexp.MustCompile(`^(\d+)\s*([a-zæøå]+)`)
+
+// LoadLocation loads an IANA timezone, rejecting the ambiguous "Local" zone
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" || strings.EqualFold(name, "local") {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return time.LoadLocation(name)
+}
+
+// SetDefaultTimezone changes the timezone used for guilds that haven't configured their own.
+// It is meant to be called once on startup, before any interactions are handled.
+func SetDefaultTimezone(name string) error {
+	loc, err := LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	DefaultLocation = loc
+	return nil
+}
+
+// Parse parses a user supplied time in loc and requires it to be after now.
+// Supported inputs include "31.12.2025 16:12", "28.02.2025", "2025-12-31 16:12",
+// RFC 3339, "16:12", "in 2h", "in 1h30m", "om 3 dager", "tomorrow 09:00",
+// "i morgen 09:00", "friday 18:00", "neste fredag 18:00" and "kl 18".
+// Missing times of day default to midnight.
+func Parse(input string, now time.Time, loc *time.Location) (time.Time, error) {
+	t, err := parse(input, now.In(loc), loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !t.After(now) {
+		return time.Time{}, ErrInPast
+	}
+	return t, nil
+}
+
+func parse(input string, now time.Time, loc *time.Location) (time.Time, error) {
+	text := strings.ToLower(strings.Join(strings.Fields(input), " "))
+	if text == "" {
+		return time.Time{}, errors.New("no time given")
+	}
+
+	// Explicit offsets win over the guild timezone
+	if t, err := time.Parse(time.RFC3339, strings.ToUpper(text)); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range absoluteLayouts {
+		if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	for _, layout := range yearlessLayouts {
+		if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+			t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+			if !t.After(now) {
+				t = t.AddDate(1, 0, 0)
+			}
+			return t, nil
+		}
+	}
+
+	if rest, ok := cutAnyPrefix(text, "in ", "om "); ok {
+		return parseDuration(rest, now)
+	}
+
+	return parseRelativeDay(text, now, loc)
+}
+
+// parseDuration parses "2h", "1h30m", "90 min", "2 hours and 15 minutes" or "3 dager"
+func parseDuration(text string, now time.Time) (time.Time, error) {
+	t := now
+	found := false
+	for text != "" {
+		match := durationPart.FindStringSubmatch(text)
+		if match == nil {
+			return time.Time{}, fmt.Errorf("unrecognized duration %q", text)
+		}
+		amount, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid number %q", match[1])
+		}
+
+		if unit, ok := durationUnits[match[2]]; ok {
+			t = t.Add(time.Duration(amount) * unit)
+		} else if days, ok := calendarUnits[match[2]]; ok {
+			t = t.AddDate(0, 0, amount*days)
+		} else {
+			return time.Time{}, fmt.Errorf("unknown unit %q", match[2])
+		}
+		found = true
+
+		text = strings.TrimSpace(text[len(match[0]):])
+		text, _ = cutAnyPrefix(text, ", ", "and ", "og ")
+		text = strings.TrimPrefix(text, ",")
+		text = strings.TrimSpace(text)
+	}
+
+	if !found {
+		return time.Time{}, errors.New("no duration given")
+	}
+	return t.Truncate(time.Minute), nil
+}
+
+// parseRelativeDay parses "tomorrow 09:00", "fredag 18:00", "next friday" or a bare "18:00"
+func parseRelativeDay(text string, now time.Time, loc *time.Location) (time.Time, error) {
+	dayPart, hour, minute, hasClock := splitClock(text)
+
+	var day time.Time
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	if offset, ok := dayOffsets[dayPart]; ok {
+		day = today.AddDate(0, 0, offset)
+	} else if dayPart == "" && hasClock {
+		// A bare time means the next time the clock shows it
+		day = today
+		if !time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc).After(now) {
+			day = day.AddDate(0, 0, 1)
+		}
+	} else {
+		name, next := cutAnyPrefix(dayPart, "next ", "neste ")
+		name, _ = cutAnyPrefix(name, "on ", "på ")
+		weekday, ok := weekdayNames[name]
+		if !ok {
+			return time.Time{}, fmt.Errorf("unrecognized time %q", text)
+		}
+
+		offset := (int(weekday) - int(today.Weekday()) + 7) % 7
+		candidate := time.Date(today.Year(), today.Month(), today.Day()+offset, hour, minute, 0, 0, loc)
+		// "next friday" never means today, and a weekday whose time has passed means next week
+		if (next && offset == 0) || !candidate.After(now) {
+			offset += 7
+		}
+		day = today.AddDate(0, 0, offset)
+	}
+
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc), nil
+}
+
+// splitClock separates a trailing "18:00", "18.00", "kl 18" or "at 18:00" from the day part
+func splitClock(text string) (dayPart string, hour, minute int, ok bool) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return "", 0, 0, false
+	}
+
+	last := words[len(words)-1]
+	rest := words[:len(words)-1]
+	hour, minute, ok = parseClock(last)
+	if !ok {
+		return text, 0, 0, false
+	}
+
+	// Bare hours are only accepted after "kl" or "at" to avoid confusing them with dates
+	hasPrefix := len(rest) > 0 && (rest[len(rest)-1] == "kl" || rest[len(rest)-1] == "kl." || rest[len(rest)-1] == "at")
+	if !strings.ContainsAny(last, ":.") && !hasPrefix {
+		return text, 0, 0, false
+	}
+	if hasPrefix {
+		rest = rest[:len(rest)-1]
+	}
+
+	return strings.Join(rest, " "), hour, minute, true
+}
+
+// parseClock parses "18:00", "18.00", "9:30" or a bare hour such as "18"
+func parseClock(s string) (hour, minute int, ok bool) {
+	hourText, minuteText, found := strings.Cut(strings.ReplaceAll(s, ".", ":"), ":")
+	if !found {
+		minuteText = "00"
+	}
+	hour, errH := strconv.Atoi(hourText)
+	minute, errM := strconv.Atoi(minuteText)
+	if errH != nil || errM != nil || len(minuteText) != 2 || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// cutAnyPrefix removes the first matching prefix and reports whether one was found
+func cutAnyPrefix(s string, prefixes ...string) (string, bool) {
+	for _, prefix := range prefixes {
+		if after, found := strings.CutPrefix(s, prefix); found {
+			return after, true
+		}
+	}
+	return s, false
+}
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load timezone %s: %v", name, err))
+	}
+	return loc
+}
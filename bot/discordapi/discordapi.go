@@ -0,0 +1,75 @@
+// Package discordapi is the part of the Discord API the command handlers and the scheduler
+// use, so they can run against discordtest.Fake instead of a live connection. The methods have
+// the signatures of *discordgo.Session, which Client wraps.
+//
+// Registering commands and following the gateway connection stay on *discordgo.Session, they
+// only happen in bot.Run.
+package discordapi
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session is what the bot does on Discord
+type Session interface {
+	// Interactions
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+
+	// Messages
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+
+	// Lookups
+	Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error)
+	GuildRoles(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Role, error)
+	GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error)
+	// LoadGuild returns a guild with its roles, channels and member count
+	LoadGuild(guildID string) (*discordgo.Guild, error)
+	// BotUserID returns the ID of the bot's own user, empty before the gateway is ready
+	BotUserID() string
+}
+
+// Client is a Session backed by discordgo
+type Client struct {
+	*discordgo.Session
+}
+
+var _ Session = (*Client)(nil)
+
+// New wraps a discordgo session
+func New(session *discordgo.Session) *Client {
+	return &Client{Session: session}
+}
+
+// LoadGuild returns the guild from the session state when the gateway has sent it, and from
+// the API otherwise
+func (c *Client) LoadGuild(guildID string) (*discordgo.Guild, error) {
+	if c.State != nil {
+		if guild, err := c.State.Guild(guildID); err == nil {
+			return guild, nil
+		}
+	}
+
+	guild, err := c.GuildWithCounts(guildID)
+	if err != nil {
+		return nil, err
+	}
+	guild.MemberCount = guild.ApproximateMemberCount
+	guild.Channels, err = c.GuildChannels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return guild, nil
+}
+
+func (c *Client) BotUserID() string {
+	if c.State == nil || c.State.User == nil {
+		return ""
+	}
+	return c.State.User.ID
+}
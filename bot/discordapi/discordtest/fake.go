@@ -0,0 +1,406 @@
+// Package discordtest provides an in-memory Discord for tests of the command handlers and the
+// scheduler. Fake keeps the guilds it is given and the messages posted to it, and records every
+// call so tests can check what the bot did.
+package discordtest
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/bwmarrin/discordgo"
+)
+
+// discordEpoch is the start of Discord's snowflake IDs, in Unix milliseconds
+const discordEpoch = 1420070400000
+
+// Call is a call made to the fake, with its arguments except the request options
+type Call struct {
+	Method string
+	Args   []any
+}
+
+// Fake is a discordapi.Session that keeps everything in memory. The zero value isn't usable,
+// create it with New.
+type Fake struct {
+	// BotUser is the bot's own user, the author of the messages it posts
+	BotUser *discordgo.User
+
+	mu       sync.Mutex
+	calls    []Call
+	guilds   map[string]*discordgo.Guild
+	messages map[string][]*discordgo.Message // by channel ID, oldest first
+	dms      map[string]string               // DM channel IDs by user ID
+	errors   map[string]error                // by method
+	sequence int64
+	now      func() time.Time
+}
+
+var _ discordapi.Session = (*Fake)(nil)
+
+// New returns a fake without guilds
+func New() *Fake {
+	return &Fake{
+		BotUser:  &discordgo.User{ID: "1000000000000000001", Username: "BetaBot", Bot: true},
+		guilds:   map[string]*discordgo.Guild{},
+		messages: map[string][]*discordgo.Message{},
+		dms:      map[string]string{},
+		errors:   map[string]error{},
+		now:      time.Now,
+	}
+}
+
+// IDs of the guild, channels, role and member NewGuild sets up
+const (
+	GuildID        = "100000000000000001"
+	ChannelID      = "100000000000000002" // #general
+	OtherChannelID = "100000000000000003" // #announcements
+	RoleID         = "100000000000000004" // @Members, mentionable
+	UserID         = "100000000000000005" // owns the guild and may manage it
+)
+
+// NewGuild returns a fake with one guild, Test Server, that has the text channels #general and
+// #announcements, the @everyone and @Members roles, the bot, and UserID as a member who may
+// manage the server
+func NewGuild() *Fake {
+	f := New()
+	f.AddGuild(&discordgo.Guild{
+		ID:          GuildID,
+		Name:        "Test Server",
+		OwnerID:     UserID,
+		MemberCount: 2,
+		Channels: []*discordgo.Channel{
+			{ID: ChannelID, Name: "general", Type: discordgo.ChannelTypeGuildText},
+			{ID: OtherChannelID, Name: "announcements", Type: discordgo.ChannelTypeGuildText, Position: 1},
+		},
+		Roles: []*discordgo.Role{
+			{ID: GuildID, Name: "@everyone"},
+			{ID: RoleID, Name: "Members", Mentionable: true},
+		},
+		Members: []*discordgo.Member{
+			{
+				GuildID:     GuildID,
+				User:        &discordgo.User{ID: UserID, Username: "tester"},
+				Permissions: discordgo.PermissionManageGuild | discordgo.PermissionMentionEveryone | discordgo.PermissionSendMessages | discordgo.PermissionViewChannel,
+			},
+			{GuildID: GuildID, User: f.BotUser, Permissions: discordgo.PermissionAll},
+		},
+	})
+	return f
+}
+
+// AddGuild adds a guild with its channels, roles and members. The channels get the guild's ID.
+func (f *Fake) AddGuild(guild *discordgo.Guild) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, channel := range guild.Channels {
+		channel.GuildID = guild.ID
+	}
+	f.guilds[guild.ID] = guild
+}
+
+// SetClock sets the time the IDs and timestamps of new messages are based on, for tests
+// that compare them with golden files
+func (f *Fake) SetClock(now func() time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// Fail makes every call of method return err, until it is called again with a nil err
+func (f *Fake) Fail(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errors, method)
+		return
+	}
+	f.errors[method] = err
+}
+
+// Calls returns the calls made so far, in order
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// CallsTo returns the calls of one method
+func (f *Fake) CallsTo(method string) []Call {
+	var matching []Call
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			matching = append(matching, call)
+		}
+	}
+	return matching
+}
+
+// ClearCalls forgets the calls made so far, e.g. after setting up a test
+func (f *Fake) ClearCalls() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// Responses returns the responses to an interaction, in order
+func (f *Fake) Responses(interactionID string) []*discordgo.InteractionResponse {
+	var responses []*discordgo.InteractionResponse
+	for _, call := range f.CallsTo("InteractionRespond") {
+		if call.Args[0] == interactionID {
+			responses = append(responses, call.Args[1].(*discordgo.InteractionResponse))
+		}
+	}
+	return responses
+}
+
+// Sent returns the messages posted in a channel, oldest first
+func (f *Fake) Sent(channelID string) []*discordgo.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.messages[channelID])
+}
+
+// DirectMessages returns the messages sent to a user, oldest first
+func (f *Fake) DirectMessages(userID string) []*discordgo.Message {
+	f.mu.Lock()
+	channelID := f.dms[userID]
+	f.mu.Unlock()
+	if channelID == "" {
+		return nil
+	}
+	return f.Sent(channelID)
+}
+
+// RESTError returns an error like the ones discordgo returns for a failed request, e.g.
+// RESTError(http.StatusForbidden, discordgo.ErrCodeMissingPermissions)
+func RESTError(status, code int) *discordgo.RESTError {
+	message := &discordgo.APIErrorMessage{Code: code, Message: http.StatusText(status)}
+	body, _ := json.Marshal(message)
+	return &discordgo.RESTError{
+		Response:     &http.Response{StatusCode: status, Status: strconv.Itoa(status) + " " + http.StatusText(status)},
+		ResponseBody: body,
+		Message:      message,
+	}
+}
+
+// record adds a call and returns the error the method was set to fail with. The caller holds f.mu.
+func (f *Fake) record(method string, args ...any) error {
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+	return f.errors[method]
+}
+
+// newID returns a snowflake for the current time, so IDs sort like Discord's
+func (f *Fake) newID() string {
+	f.sequence++
+	return strconv.FormatInt((f.now().UnixMilli()-discordEpoch)<<22|f.sequence&0xfff, 10)
+}
+
+// newMessage returns a message from the bot
+func (f *Fake) newMessage(channelID, content string, embeds []*discordgo.MessageEmbed) *discordgo.Message {
+	msg := &discordgo.Message{
+		ID:        f.newID(),
+		ChannelID: channelID,
+		Content:   content,
+		Embeds:    embeds,
+		Author:    f.BotUser,
+		Timestamp: f.now(),
+	}
+	if channel := f.channel(channelID); channel != nil {
+		msg.GuildID = channel.GuildID
+	}
+	return msg
+}
+
+// channel finds a channel of a guild, nil if there is none. The caller holds f.mu.
+func (f *Fake) channel(channelID string) *discordgo.Channel {
+	for _, guild := range f.guilds {
+		for _, channel := range guild.Channels {
+			if channel.ID == channelID {
+				return channel
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Fake) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("InteractionRespond", interaction.ID, resp)
+}
+
+func (f *Fake) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("InteractionResponseEdit", interaction.ID, newresp); err != nil {
+		return nil, err
+	}
+	msg := f.newMessage(interaction.ChannelID, "", nil)
+	if newresp.Content != nil {
+		msg.Content = *newresp.Content
+	}
+	if newresp.Embeds != nil {
+		msg.Embeds = *newresp.Embeds
+	}
+	return msg, nil
+}
+
+func (f *Fake) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("InteractionResponseDelete", interaction.ID)
+}
+
+func (f *Fake) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("FollowupMessageCreate", interaction.ID, data); err != nil {
+		return nil, err
+	}
+	return f.newMessage(interaction.ChannelID, data.Content, data.Embeds), nil
+}
+
+func (f *Fake) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ChannelMessageSendComplex", channelID, data); err != nil {
+		return nil, err
+	}
+	if f.channel(channelID) == nil && !slices.Contains(slices.Collect(maps.Values(f.dms)), channelID) {
+		return nil, RESTError(http.StatusNotFound, discordgo.ErrCodeUnknownChannel)
+	}
+
+	msg := f.newMessage(channelID, data.Content, data.Embeds)
+	msg.Components = data.Components
+	for _, file := range data.Files {
+		msg.Attachments = append(msg.Attachments, &discordgo.MessageAttachment{ID: f.newID(), Filename: file.Name, ContentType: file.ContentType})
+	}
+	f.messages[channelID] = append(f.messages[channelID], msg)
+	return msg, nil
+}
+
+// ChannelMessages returns the posted messages of a channel newest first, like Discord
+func (f *Fake) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ChannelMessages", channelID, limit, beforeID, afterID, aroundID); err != nil {
+		return nil, err
+	}
+
+	var found []*discordgo.Message
+	for _, msg := range slices.Backward(f.messages[channelID]) {
+		if (beforeID != "" && !idBefore(msg.ID, beforeID)) || (afterID != "" && !idBefore(afterID, msg.ID)) {
+			continue
+		}
+		found = append(found, msg)
+		if len(found) == limit {
+			break
+		}
+	}
+	return found, nil
+}
+
+func (f *Fake) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("UserChannelCreate", recipientID); err != nil {
+		return nil, err
+	}
+	if f.dms[recipientID] == "" {
+		f.dms[recipientID] = f.newID()
+	}
+	return &discordgo.Channel{
+		ID:         f.dms[recipientID],
+		Type:       discordgo.ChannelTypeDM,
+		Recipients: []*discordgo.User{{ID: recipientID}},
+	}, nil
+}
+
+func (f *Fake) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Channel", channelID); err != nil {
+		return nil, err
+	}
+	if channel := f.channel(channelID); channel != nil {
+		return channel, nil
+	}
+	return nil, RESTError(http.StatusNotFound, discordgo.ErrCodeUnknownChannel)
+}
+
+func (f *Fake) GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GuildChannels", guildID); err != nil {
+		return nil, err
+	}
+	guild, ok := f.guilds[guildID]
+	if !ok {
+		return nil, RESTError(http.StatusNotFound, discordgo.ErrCodeUnknownGuild)
+	}
+	return slices.Clone(guild.Channels), nil
+}
+
+func (f *Fake) GuildRoles(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Role, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GuildRoles", guildID); err != nil {
+		return nil, err
+	}
+	guild, ok := f.guilds[guildID]
+	if !ok {
+		return nil, RESTError(http.StatusNotFound, discordgo.ErrCodeUnknownGuild)
+	}
+	return slices.Clone(guild.Roles), nil
+}
+
+func (f *Fake) GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GuildMember", guildID, userID); err != nil {
+		return nil, err
+	}
+	if guild, ok := f.guilds[guildID]; ok {
+		for _, member := range guild.Members {
+			if member.User != nil && member.User.ID == userID {
+				return member, nil
+			}
+		}
+	}
+	return nil, RESTError(http.StatusNotFound, discordgo.ErrCodeUnknownMember)
+}
+
+// LoadGuild returns the guild, with its member count set from its members if it has none
+func (f *Fake) LoadGuild(guildID string) (*discordgo.Guild, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("LoadGuild", guildID); err != nil {
+		return nil, err
+	}
+	guild, ok := f.guilds[guildID]
+	if !ok {
+		return nil, RESTError(http.StatusNotFound, discordgo.ErrCodeUnknownGuild)
+	}
+	loaded := *guild
+	if loaded.MemberCount == 0 {
+		loaded.MemberCount = len(loaded.Members)
+	}
+	return &loaded, nil
+}
+
+func (f *Fake) BotUserID() string {
+	return f.BotUser.ID
+}
+
+// idBefore reports whether snowflake a was created before b
+func idBefore(a, b string) bool {
+	x, _ := strconv.ParseUint(a, 10, 64)
+	y, _ := strconv.ParseUint(b, 10, 64)
+	return x < y
+}
@@ -0,0 +1,194 @@
+package embeds
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord limits for embeds, see https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+const (
+	maxTitle       = 256
+	maxDescription = 4096
+	maxFields      = 25
+	maxFieldName   = 256
+	maxFieldValue  = 1024
+	maxFooter      = 2048
+	maxTotal       = 6000
+)
+
+// Spec is an embed attached to a scheduled message. It is written and stored as JSON, e.g.
+//
+//	{"title": "Weekly meeting", "description": "See you there!", "color": "#5865F2",
+//	 "fields": [{"name": "Where", "value": "Room 101", "inline": true}], "timestamp": true}
+type Spec struct {
+	Title        string  `json:"title,omitempty"`
+	Description  string  `json:"description,omitempty"`
+	URL          string  `json:"url,omitempty"`
+	Color        Color   `json:"color,omitempty"`
+	ImageURL     string  `json:"image,omitempty"`
+	ThumbnailURL string  `json:"thumbnail,omitempty"`
+	Footer       string  `json:"footer,omitempty"`
+	Fields       []Field `json:"fields,omitempty"`
+	Timestamp    bool    `json:"timestamp,omitempty"` // show the scheduled time in the embed
+}
+
+// Field is a name/value pair shown in an embed
+type Field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// Color is an RGB embed color. It is written as "#5865F2" and also accepts a plain number.
+type Color int
+
+func (c Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("#%06X", int(c)))
+}
+
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		return c.set(int64(number))
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("color must be a hex string like \"#5865F2\"")
+	}
+	value, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(text), "#"), 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid color %q, use a hex string like \"#5865F2\"", text)
+	}
+	return c.set(value)
+}
+
+func (c *Color) set(value int64) error {
+	if value < 0 || value > 0xFFFFFF {
+		return errors.New("color must be between #000000 and #FFFFFF")
+	}
+	*c = Color(value)
+	return nil
+}
+
+// Parse parses and validates an embed written as JSON. Unknown keys are rejected so typos don't go unnoticed.
+func Parse(input string) (*Spec, error) {
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.DisallowUnknownFields()
+
+	spec := &Spec{}
+	if err := decoder.Decode(spec); err != nil {
+		return nil, fmt.Errorf("invalid embed JSON: %w", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid embed JSON: unexpected data after the embed object")
+	}
+
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func (s *Spec) validate() error {
+	if s.Title == "" && s.Description == "" && len(s.Fields) == 0 && s.ImageURL == "" {
+		return errors.New("the embed needs at least a title, description, field or image")
+	}
+
+	total := 0
+	check := func(name, value string, limit int) error {
+		n := utf8.RuneCountInString(value)
+		if n > limit {
+			return fmt.Errorf("embed %s is %d characters long, the limit is %d", name, n, limit)
+		}
+		total += n
+		return nil
+	}
+
+	if err := check("title", s.Title, maxTitle); err != nil {
+		return err
+	}
+	if err := check("description", s.Description, maxDescription); err != nil {
+		return err
+	}
+	if err := check("footer", s.Footer, maxFooter); err != nil {
+		return err
+	}
+	if len(s.Fields) > maxFields {
+		return fmt.Errorf("the embed has %d fields, the limit is %d", len(s.Fields), maxFields)
+	}
+	for i, field := range s.Fields {
+		if strings.TrimSpace(field.Name) == "" || strings.TrimSpace(field.Value) == "" {
+			return fmt.Errorf("embed field %d needs both a name and a value", i+1)
+		}
+		if err := check(fmt.Sprintf("field %d name", i+1), field.Name, maxFieldName); err != nil {
+			return err
+		}
+		if err := check(fmt.Sprintf("field %d value", i+1), field.Value, maxFieldValue); err != nil {
+			return err
+		}
+	}
+	if total > maxTotal {
+		return fmt.Errorf("the embed text is %d characters long in total, the limit is %d", total, maxTotal)
+	}
+
+	for name, link := range map[string]string{"url": s.URL, "image": s.ImageURL, "thumbnail": s.ThumbnailURL} {
+		if link == "" {
+			continue
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("embed %s must be an http or https link", name)
+		}
+	}
+
+	return nil
+}
+
+// String returns the embed as indented JSON, the form it is stored and edited in
+func (s *Spec) String() string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// MessageEmbed builds the Discord embed. The timestamp, if enabled, shows the given time.
+func (s *Spec) MessageEmbed(timestamp time.Time) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       s.Title,
+		Description: s.Description,
+		URL:         s.URL,
+		Color:       int(s.Color),
+	}
+	if s.ImageURL != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: s.ImageURL}
+	}
+	if s.ThumbnailURL != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: s.ThumbnailURL}
+	}
+	if s.Footer != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: s.Footer}
+	}
+	for _, field := range s.Fields {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: field.Name, Value: field.Value, Inline: field.Inline})
+	}
+	if s.Timestamp && !timestamp.IsZero() {
+		embed.Timestamp = timestamp.UTC().Format(time.RFC3339)
+	}
+	return embed
+}
@@ -0,0 +1,60 @@
+// Package httpserver runs the bot's optional HTTP servers, such as the metrics endpoint, the
+// admin API and the dashboard, the same way: listening right away, so a port that is taken stops
+// the bot at startup, and serving in the background until shut down.
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/logging"
+)
+
+// Server is an HTTP server that serves in the background
+type Server struct {
+	name string
+	http *http.Server
+}
+
+// New returns a server that will serve handler on addr, e.g. ":8080". name says which
+// server it is in the logs, e.g. "api".
+func New(name, addr string, handler http.Handler) *Server {
+	return &Server{
+		name: name,
+		http: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Handler returns the server's routes, e.g. for httptest
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+// Start listens on the server's address and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return fmt.Errorf("listening for %s requests: %w", s.name, err)
+	}
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", slog.String("server", s.name), logging.Err(err))
+		}
+	}()
+	slog.Info("Serving HTTP", slog.String("server", s.name), slog.String("addr", listener.Addr().String()))
+	return nil
+}
+
+// Shutdown stops the server, waiting for requests in progress until ctx expires
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
@@ -0,0 +1,154 @@
+// Package logging sets up the bot's structured logs. Records are written with log/slog as text or
+// JSON, and share attribute names, such as guild_id, so the logs of one guild, interaction or
+// scheduled message can be grepped for or filtered in a log collector.
+//
+// Debug logs can be turned on for single guilds with SetGuildDebug, without turning them on for
+// everyone. Records count as belonging to a guild when they, or the logger they were written
+// with, have a guild_id attribute.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Attribute names shared by every package
+const (
+	GuildID            = "guild_id"
+	ChannelID          = "channel_id"
+	UserID             = "user_id"
+	InteractionID      = "interaction_id"
+	ScheduledMessageID = "scheduled_message_id"
+	Command            = "command"
+	CustomID           = "custom_id"
+)
+
+// Formats of the log output
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Err is the attribute for an error
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+// debugGuilds are the guilds that get debug logs regardless of the level
+var debugGuilds struct {
+	sync.RWMutex
+	ids map[string]bool
+}
+
+// SetGuildDebug turns debug logs on or off for one guild
+func SetGuildDebug(guildID string, enabled bool) {
+	debugGuilds.Lock()
+	defer debugGuilds.Unlock()
+	if debugGuilds.ids == nil {
+		debugGuilds.ids = map[string]bool{}
+	}
+	if enabled {
+		debugGuilds.ids[guildID] = true
+	} else {
+		delete(debugGuilds.ids, guildID)
+	}
+}
+
+// guildDebug reports whether guildID has debug logs on, or with an empty guildID, whether any guild has
+func guildDebug(guildID string) bool {
+	debugGuilds.RLock()
+	defer debugGuilds.RUnlock()
+	if guildID == "" {
+		return len(debugGuilds.ids) > 0
+	}
+	return debugGuilds.ids[guildID]
+}
+
+// ParseLevel parses a level name: debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// New returns a logger writing to w in the given format, dropping records below level
+// unless they belong to a guild with debug logs on
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	// The inner handler sees every record, guildHandler decides what is written
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+	return slog.New(&guildHandler{Handler: handler, level: level}), nil
+}
+
+// Setup makes a logger writing to stderr the default, also for the log package
+func Setup(format, level string) error {
+	minLevel, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger, err := New(os.Stderr, format, minLevel)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// guildHandler filters records by level, letting debug records of guilds with debug logs on through
+type guildHandler struct {
+	slog.Handler
+	level   slog.Level
+	guildID string // from the logger's attributes, empty if it has none
+}
+
+func (h *guildHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level || guildDebug(h.guildID)
+}
+
+func (h *guildHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < h.level {
+		guildID := h.guildID
+		if guildID == "" {
+			record.Attrs(func(attr slog.Attr) bool {
+				if attr.Key == GuildID {
+					guildID = attr.Value.String()
+					return false
+				}
+				return true
+			})
+		}
+		if guildID == "" || !guildDebug(guildID) {
+			return nil
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *guildHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	guildID := h.guildID
+	for _, attr := range attrs {
+		if attr.Key == GuildID {
+			guildID = attr.Value.String()
+		}
+	}
+	return &guildHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level, guildID: guildID}
+}
+
+func (h *guildHandler) WithGroup(name string) slog.Handler {
+	return &guildHandler{Handler: h.Handler.WithGroup(name), level: h.level, guildID: h.guildID}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// latencyInterval is how often the gateway latency is read from the session
+const latencyInterval = 15 * time.Second
+
+// Gateway follows the state of a session's gateway connection, for the metrics and health checks.
+// discordgo reconnects on its own, so a short disconnect is normal.
+type Gateway struct {
+	mu           sync.Mutex
+	connected    bool
+	everUp       bool
+	disconnected time.Time // when the connection was lost, or when watching started
+}
+
+// WatchGateway starts following the gateway connection of session until ctx is cancelled.
+// It has to be called before the session is opened, so the first connect is seen.
+func WatchGateway(ctx context.Context, session *discordgo.Session) *Gateway {
+	g := &Gateway{disconnected: time.Now()}
+	session.AddHandler(func(_ *discordgo.Session, _ *discordgo.Connect) { g.up() })
+	session.AddHandler(func(_ *discordgo.Session, _ *discordgo.Disconnect) { g.down() })
+
+	go func() {
+		ticker := time.NewTicker(latencyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// The latency is negative while a heartbeat waits for its acknowledgement
+			if latency := session.HeartbeatLatency(); latency > 0 {
+				GatewayLatency.Set(latency.Seconds())
+			}
+		}
+	}()
+	return g
+}
+
+func (g *Gateway) up() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.connected {
+		return
+	}
+	if g.everUp {
+		GatewayReconnects.Inc()
+	}
+	g.connected, g.everUp = true, true
+	GatewayConnected.Set(1)
+}
+
+func (g *Gateway) down() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.connected {
+		return
+	}
+	g.connected = false
+	g.disconnected = time.Now()
+	GatewayConnected.Set(0)
+}
+
+// Connected is a readiness check that fails while the gateway isn't connected
+func (g *Gateway) Connected(context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.connected {
+		return fmt.Errorf("disconnected for %s", time.Since(g.disconnected).Round(time.Second))
+	}
+	return nil
+}
+
+// ConnectedWithin returns a liveness check that fails once the gateway has been disconnected
+// for longer than grace, i.e. when discordgo doesn't seem to manage to reconnect
+func (g *Gateway) ConnectedWithin(grace time.Duration) Check {
+	return func(context.Context) error {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if down := time.Since(g.disconnected); !g.connected && down > grace {
+			return fmt.Errorf("disconnected for %s", down.Round(time.Second))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+// Package metrics exposes how the bot is doing to Prometheus, and serves the health checks
+// used by Docker, Kubernetes or an uptime monitor. Both are served by an optional HTTP server,
+// see Server.
+//
+// The metrics are package variables updated by the packages that do the work, e.g. the
+// scheduler counts the messages it sends. Their labels only take a small set of values, such as
+// command names, never IDs.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "betabot"
+
+// Outcomes of handling an interaction, the outcome label of CommandsHandled
+const (
+	OutcomeOK            = "ok"             // handled without errors
+	OutcomeUserError     = "user_error"     // the user was shown an error that is their own, e.g. an invalid date
+	OutcomeInternalError = "internal_error" // the user was shown an error that isn't their fault, e.g. a database error
+	OutcomePanic         = "panic"          // the handler panicked
+)
+
+// registry holds the bot's metrics, and the Go runtime and process metrics
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	// CommandsHandled counts handled interactions by command, kind and outcome. The kind is
+	// command, autocomplete, component or modal.
+	CommandsHandled = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_handled_total",
+		Help:      "Interactions handled, by command, kind (command, autocomplete, component or modal) and outcome.",
+	}, []string{"command", "kind", "outcome"})
+
+	// CommandDuration is how long handlers took, by command and kind
+	CommandDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "How long interaction handlers took, by command and kind.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"command", "kind"})
+
+	// ScheduledMessagesPending is the number of active messages, updated when the scheduler reconciles
+	ScheduledMessagesPending = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduled_messages_pending",
+		Help:      "Active scheduled messages waiting to be sent or being sent, as of the scheduler's last reconcile.",
+	})
+
+	// ScheduledMessagesSent counts posted scheduled messages, every occurrence of recurring ones
+	ScheduledMessagesSent = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduled_messages_sent_total",
+		Help:      "Scheduled messages posted, counting every occurrence of recurring messages.",
+	})
+
+	// ScheduledMessageRetries counts failed sends that will be retried
+	ScheduledMessageRetries = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduled_message_retries_total",
+		Help:      "Failed sends of scheduled messages that will be retried.",
+	})
+
+	// ScheduledMessagesFailed counts messages the scheduler gave up on
+	ScheduledMessagesFailed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduled_messages_failed_total",
+		Help:      "Scheduled messages that could not be posted and were dead-lettered.",
+	})
+
+	// SchedulerTickDuration is how long the scheduler took to look for and start due messages
+	SchedulerTickDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduler_tick_duration_seconds",
+		Help:      "How long a scheduler tick took to reconcile with the database and claim due messages.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// SchedulerLastTick is when the scheduler last finished a tick, to alert on a stuck scheduler
+	SchedulerLastTick = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduler_last_tick_timestamp_seconds",
+		Help:      "Unix time the scheduler last finished a tick.",
+	})
+
+	// GatewayConnected is 1 while the Discord gateway is connected
+	GatewayConnected = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "gateway_connected",
+		Help:      "1 while the Discord gateway connection is up, 0 otherwise.",
+	})
+
+	// GatewayReconnects counts the times the gateway connected again after losing its connection
+	GatewayReconnects = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateway_reconnects_total",
+		Help:      "Times the Discord gateway connected again after losing its connection.",
+	})
+
+	// GatewayLatency is the time between the last gateway heartbeat and its acknowledgement
+	GatewayLatency = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "gateway_latency_seconds",
+		Help:      "Time between the last Discord gateway heartbeat and its acknowledgement.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/httpserver"
+)
+
+// checkTimeout bounds a single health check, e.g. a database ping
+const checkTimeout = 3 * time.Second
+
+// Check reports a problem with something the bot depends on, nil when it is fine
+type Check func(ctx context.Context) error
+
+// Server serves
+//   - /metrics, the metrics in the Prometheus text format
+//   - /healthz, liveness: fails when the bot should be restarted
+//   - /readyz, readiness: fails while the bot can't do its work, e.g. during startup or a gateway reconnect
+//
+// The health endpoints answer 200 or 503, with a line per check.
+type Server struct {
+	*httpserver.Server
+
+	mu        sync.Mutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+// NewServer returns a server that will listen on addr, e.g. ":9090"
+func NewServer(addr string) *Server {
+	s := &Server{liveness: map[string]Check{}, readiness: map[string]Check{}}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, r, false)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, r, true)
+	})
+
+	s.Server = httpserver.New("metrics", addr, mux)
+	return s
+}
+
+// AddLivenessCheck adds a check to /healthz. Liveness checks are also readiness checks.
+func (s *Server) AddLivenessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.liveness[name] = check
+}
+
+// AddReadinessCheck adds a check to /readyz
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readiness[name] = check
+}
+
+// serveChecks runs the liveness checks, and with ready the readiness checks too
+func (s *Server) serveChecks(w http.ResponseWriter, r *http.Request, ready bool) {
+	s.mu.Lock()
+	checks := maps.Clone(s.liveness)
+	if ready {
+		maps.Copy(checks, s.readiness)
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	// Run the checks at once, so a slow one doesn't delay the others
+	results := make(map[string]error, len(checks))
+	var (
+		wg        sync.WaitGroup
+		resultsMu sync.Mutex
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+			resultsMu.Lock()
+			results[name] = err
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	var report strings.Builder
+	status := http.StatusOK
+	for _, name := range slices.Sorted(maps.Keys(results)) {
+		if err := results[name]; err != nil {
+			status = http.StatusServiceUnavailable
+			fmt.Fprintf(&report, "failed %s: %v\n", name, err)
+		} else {
+			fmt.Fprintf(&report, "ok %s\n", name)
+		}
+	}
+	if status != http.StatusOK {
+		slog.Debug("Health check failed", slog.String("path", r.URL.Path), slog.String("report", strings.TrimSpace(report.String())))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	fmt.Fprint(w, report.String())
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// MaxAttachmentSize is the largest file that can be attached to a scheduled message.
+// It stays below Discord's default upload limit so the file can be posted in any server.
+const MaxAttachmentSize = 8 << 20
+
+// Attachment model for a file posted together with a scheduled message. The file is stored
+// in the database until the message has been delivered for the last time. A file uploaded
+// with /schedule add belongs to the command's draft until the message is saved.
+type Attachment struct {
+	ID                 int64
+	ScheduledMessageID int64  // 0 while the file belongs to a draft
+	DraftID            string // empty once the file belongs to a message
+	Filename           string
+	ContentType        string
+	Data               []byte
+	CreatedAt          time.Time
+}
+
+// Size returns the size of the file in bytes
+func (a *Attachment) Size() int {
+	return len(a.Data)
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Delivery model for a single attempt to post a scheduled message
+type Delivery struct {
+	ID                 int64
+	ScheduledMessageID int64
+	GuildID            string
+	ChannelID          string
+	Title              string
+	PostedMessageID    string        // empty when the attempt failed
+	Status             MessageStatus // StatusSent or StatusFailed
+	Error              string
+	DeliveredAt        time.Time
+}
+
+// JumpURL returns a link to the posted Discord message, or an empty string if nothing was posted
+func (d *Delivery) JumpURL() string {
+	if d.PostedMessageID == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", d.GuildID, d.ChannelID, d.PostedMessageID)
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// Draft model for the state of a multi-step command, such as /schedule add, between its steps.
+// The state is JSON owned by the command. Drafts expire when they haven't been touched for a while.
+type Draft struct {
+	ID        string // random, part of the custom IDs of the command's components and modals
+	Flow      string // the kind of command, e.g. "schedule"
+	GuildID   string
+	UserID    string // member who started the command, the only one who can continue it
+	State     []byte
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
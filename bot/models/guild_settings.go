@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/dateparse"
+)
+
+// GuildSettings model for per-guild bot configuration
+type GuildSettings struct {
+	GuildID      string
+	Timezone     string // IANA timezone name, empty for the default
+	DebugLogging bool   // write debug logs for this guild, regardless of the log level
+}
+
+// Location returns the configured timezone of the guild, falling back to the default timezone
+func (gs *GuildSettings) Location() *time.Location {
+	if gs.Timezone != "" {
+		if loc, err := dateparse.LoadLocation(gs.Timezone); err == nil {
+			return loc
+		}
+	}
+	return dateparse.DefaultLocation
+}
@@ -1,140 +1,138 @@
 package models
 
 import (
-	"database/sql"
+	"errors"
+	"strings"
 	"time"
 )
 
+// ErrLeaseLost is returned when a worker tries to finish a delivery whose lease was taken over by another worker
+var ErrLeaseLost = errors.New("lease on scheduled message was lost")
+
+// ErrNotActive is returned when modifying a message that is being sent or has already been sent or cancelled
+var ErrNotActive = errors.New("scheduled message is no longer pending")
+
+// MessageStatus is the delivery state of a scheduled message
+type MessageStatus string
+
+const (
+	StatusPending   MessageStatus = "pending"   // waiting for its scheduled time
+	StatusSending   MessageStatus = "sending"   // currently being posted
+	StatusSent      MessageStatus = "sent"      // posted, and not recurring any more
+	StatusFailed    MessageStatus = "failed"    // posting failed, see LastError
+	StatusCancelled MessageStatus = "cancelled" // removed before it was posted
+)
+
+// MassMention is the @everyone or @here mention added to a scheduled message
+type MassMention string
+
+const (
+	MentionNone     MassMention = ""
+	MentionEveryone MassMention = "everyone"
+	MentionHere     MassMention = "here"
+)
+
 // ScheduledMessage model for a scheduled Discord message
 type ScheduledMessage struct {
 	ID            int64
 	Title         string
 	GuildID       string
-	RoleID        string
-	UserID        string
+	RoleID        string // comma-separated IDs of the roles pinged when the message is posted
+	UserID        string // author of the message
 	Message       string
+	Embed         string // embed posted with the message as JSON, see package embeds; empty for plain messages
 	ScheduledTime time.Time
 	ChannelID     string
-}
-
-// Create inserts a new scheduled message into the database
-func (sm *ScheduledMessage) Create(db *sql.DB) error {
-	query := `
-		INSERT INTO scheduled_messages (title, guild_id, role_id, user_id, message, scheduled_time, channel_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
 
-	result, err := db.Exec(query, sm.Title, sm.GuildID, sm.RoleID, sm.UserID, sm.Message, sm.ScheduledTime, sm.ChannelID)
-	if err != nil {
-		return err
-	}
-
-	sm.ID, err = result.LastInsertId()
-	return err
+	MentionUserIDs string      // comma-separated IDs of the users pinged when the message is posted
+	MassMention    MassMention // @everyone or @here, if the message pings the whole channel
+
+	// Recurrence is the rule used to compute the next occurrence, empty for one-off messages
+	Recurrence     string
+	RecurrenceEnd  time.Time // zero when the recurrence has no end date
+	MaxOccurrences int       // 0 when the recurrence has no occurrence limit
+	Occurrences    int       // number of times the message has been posted
+
+	Status          MessageStatus
+	PostedMessageID string    // Discord ID of the most recently posted message
+	Attempts        int       // send attempts for the current occurrence
+	LastError       string    // error of the last failed attempt
+	SentAt          time.Time // time of the most recent successful post, zero if never sent
+	NextAttemptAt   time.Time // earliest time of the next retry after a failed attempt, zero if not retrying
+
+	// LeaseToken identifies the worker currently sending the message, see storage.ScheduledMessageRepository.ClaimDue
+	LeaseToken     string
+	LeaseExpiresAt time.Time
 }
 
-// GetByID retrieves a scheduled message by ID
-func GetScheduledMessageByID(db *sql.DB, id int64) (*ScheduledMessage, error) {
-	query := `SELECT id, title, guild_id, role_id, user_id, message, scheduled_time, channel_id FROM scheduled_messages WHERE id = ?`
-
-	sm := &ScheduledMessage{}
-	err := db.QueryRow(query, id).Scan(&sm.ID, &sm.Title, &sm.GuildID, &sm.RoleID, &sm.UserID, &sm.Message, &sm.ScheduledTime)
-	if err != nil {
-		return nil, err
-	}
-
-	return sm, nil
+// IsRecurring reports whether the message repeats after it has been posted
+func (sm *ScheduledMessage) IsRecurring() bool {
+	return sm.Recurrence != ""
 }
 
-// GetAllByGuild retrieves all scheduled messages for a guild
-func GetScheduledMessagesByGuild(db *sql.DB, guildID string) ([]*ScheduledMessage, error) {
-	query := `SELECT id, title, guild_id, role_id, user_id, message, scheduled_time, channel_id FROM scheduled_messages WHERE guild_id = ? ORDER BY scheduled_time ASC`
+// MentionRoleIDs returns the IDs of the roles pinged when the message is posted
+func (sm *ScheduledMessage) MentionRoleIDs() []string {
+	return splitIDs(sm.RoleID)
+}
 
-	rows, err := db.Query(query, guildID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var messages []*ScheduledMessage
-	for rows.Next() {
-		sm := &ScheduledMessage{}
-		err := rows.Scan(&sm.ID, &sm.Title, &sm.GuildID, &sm.RoleID, &sm.UserID, &sm.Message, &sm.ScheduledTime)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, sm)
-	}
+// MentionUsers returns the IDs of the users pinged when the message is posted
+func (sm *ScheduledMessage) MentionUsers() []string {
+	return splitIDs(sm.MentionUserIDs)
+}
 
-	return messages, rows.Err()
+// SetMentions replaces the roles and users pinged when the message is posted
+func (sm *ScheduledMessage) SetMentions(roleIDs, userIDs []string, mass MassMention) {
+	sm.RoleID = strings.Join(roleIDs, ",")
+	sm.MentionUserIDs = strings.Join(userIDs, ",")
+	sm.MassMention = mass
 }
 
-// Update modifies an existing scheduled message
-func (sm *ScheduledMessage) Update(db *sql.DB) error {
-	query := `
-        UPDATE scheduled_messages 
-        SET title = ?, guild_id = ?, role_id = ?, user_id = ?, message = ?, scheduled_time = ?, channel_id = ?
-        WHERE id = ?
-    `
-	_, err := db.Exec(query, sm.Title, sm.GuildID, sm.RoleID, sm.UserID, sm.Message, sm.ScheduledTime, sm.ChannelID, sm.ID)
-	return err
+// HasMentions reports whether posting the message pings anyone
+func (sm *ScheduledMessage) HasMentions() bool {
+	return sm.RoleID != "" || sm.MentionUserIDs != "" || sm.MassMention != MentionNone
 }
 
-// Delete removes a scheduled message from the database
-func (sm *ScheduledMessage) Delete(db *sql.DB) error {
-	query := `DELETE FROM scheduled_messages WHERE id = ?`
-	_, err := db.Exec(query, sm.ID)
-	return err
+// IsActive reports whether the message is still waiting to be posted or can be rescheduled
+func (sm *ScheduledMessage) IsActive() bool {
+	return sm.Status == StatusPending || sm.Status == StatusFailed
 }
 
-// GetUpcomingMessagesByGuild retrieves all messages scheduled for the future in a guild
-func GetUpcomingMessagesByGuild(db *sql.DB, guildID string) ([]*ScheduledMessage, error) {
-	query := `SELECT id, title, guild_id, role_id, user_id, message, scheduled_time, channel_id 
-        FROM scheduled_messages 
-        WHERE guild_id = ? AND scheduled_time > ? 
-        ORDER BY scheduled_time ASC`
+// MarkSent records a successful final delivery. Persist it by releasing the lease.
+func (sm *ScheduledMessage) MarkSent(postedMessageID string, sentAt time.Time) {
+	sm.Status = StatusSent
+	sm.PostedMessageID = postedMessageID
+	sm.SentAt = sentAt
+	sm.LastError = ""
+	sm.NextAttemptAt = time.Time{}
+}
 
-	rows, err := db.Query(query, guildID, time.Now())
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var messages []*ScheduledMessage
-	for rows.Next() {
-		sm := &ScheduledMessage{}
-		err := rows.Scan(&sm.ID, &sm.Title, &sm.GuildID, &sm.RoleID, &sm.UserID, &sm.Message, &sm.ScheduledTime, &sm.ChannelID)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, sm)
-	}
+// MarkRetry records a failed attempt that will be retried at the given time. Persist it by releasing the lease.
+func (sm *ScheduledMessage) MarkRetry(reason string, retryAt time.Time) {
+	sm.Status = StatusPending
+	sm.LastError = reason
+	sm.NextAttemptAt = retryAt
+}
 
-	return messages, rows.Err()
+// MarkFailed moves the message to the failed (dead-letter) state, so it is not retried again until
+// it is rescheduled. Persist it by releasing the lease.
+func (sm *ScheduledMessage) MarkFailed(reason string) {
+	sm.Status = StatusFailed
+	sm.LastError = reason
+	sm.NextAttemptAt = time.Time{}
 }
 
-// GetPendingMessages retrieves all messages that are ready to be sent
-func GetPendingMessages(db *sql.DB) ([]*ScheduledMessage, error) {
-	query := `SELECT id, title, guild_id, role_id, user_id, message, scheduled_time, channel_id 
-        FROM scheduled_messages 
-        WHERE scheduled_time <= ? 
-        ORDER BY scheduled_time ASC`
+// ResetDelivery clears the delivery state so the message is sent again at its scheduled time
+func (sm *ScheduledMessage) ResetDelivery() {
+	sm.Status = StatusPending
+	sm.Attempts = 0
+	sm.LastError = ""
+	sm.NextAttemptAt = time.Time{}
+}
 
-	rows, err := db.Query(query, time.Now())
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var messages []*ScheduledMessage
-	for rows.Next() {
-		sm := &ScheduledMessage{}
-		err := rows.Scan(&sm.ID, &sm.Title, &sm.GuildID, &sm.RoleID, &sm.UserID, &sm.Message, &sm.ScheduledTime, &sm.ChannelID)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, sm)
+// splitIDs splits a comma-separated list of Discord IDs
+func splitIDs(s string) []string {
+	if s == "" {
+		return nil
 	}
-
-	return messages, rows.Err()
+	return strings.Split(s, ",")
 }
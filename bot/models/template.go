@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// Template model for a reusable message text in a guild, referenced by name
+type Template struct {
+	ID        int64
+	GuildID   string
+	Name      string // unique within the guild
+	Content   string
+	UserID    string // member who created the template
+	CreatedAt time.Time
+}
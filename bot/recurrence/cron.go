@@ -0,0 +1,144 @@
+package recurrence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronRule is a standard five-field cron expression: minute hour day-of-month month day-of-week
+type cronRule struct {
+	minutes [60]bool
+	hours   [24]bool
+	dom     [32]bool
+	months  [13]bool
+	dow     [7]bool
+	domStar bool
+	dowStar bool
+}
+
+// looksLikeCron reports whether all five fields only use the characters of cron fields, so
+// presets like "1st monday of the month" aren't mistaken for one
+func looksLikeCron(fields []string) bool {
+	for _, field := range fields {
+		if strings.Trim(field, "0123456789*,/-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCron(fields []string) (Rule, error) {
+	r := cronRule{}
+	var err error
+
+	if err = parseCronField(fields[0], 0, 59, r.minutes[:]); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if err = parseCronField(fields[1], 0, 23, r.hours[:]); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if err = parseCronField(fields[2], 1, 31, r.dom[:]); err != nil {
+		return nil, fmt.Errorf("invalid day-of-month field: %w", err)
+	}
+	if err = parseCronField(fields[3], 1, 12, r.months[:]); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+
+	// Day of week accepts both 0 and 7 for Sunday
+	var dow [8]bool
+	if err = parseCronField(fields[4], 0, 7, dow[:]); err != nil {
+		return nil, fmt.Errorf("invalid day-of-week field: %w", err)
+	}
+	copy(r.dow[:], dow[:7])
+	r.dow[0] = r.dow[0] || dow[7]
+
+	r.domStar = strings.HasPrefix(fields[2], "*")
+	r.dowStar = strings.HasPrefix(fields[4], "*")
+	return r, nil
+}
+
+// parseCronField fills set for a field such as "*", "*/15", "1-5", "1,15" or "0-30/10"
+func parseCronField(field string, min, max int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return fmt.Errorf("bad step in %q", part)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+func (r cronRule) dayMatches(t time.Time) bool {
+	if !r.months[t.Month()] {
+		return false
+	}
+	domMatch, dowMatch := r.dom[t.Day()], r.dow[t.Weekday()]
+	// Like cron, when both day fields are restricted either one may match
+	switch {
+	case r.domStar && r.dowStar:
+		return true
+	case r.domStar:
+		return dowMatch
+	case r.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+func (r cronRule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	start := time.Date(after.Year(), after.Month(), after.Day(), 0, 0, 0, 0, loc)
+
+	for day := start; day.Sub(start) < maxSearch; day = day.AddDate(0, 0, 1) {
+		if !r.dayMatches(day) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if !r.hours[hour] {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if !r.minutes[minute] {
+					continue
+				}
+				t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+				if t.After(after) {
+					return t
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
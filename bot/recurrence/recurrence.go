@@ -0,0 +1,278 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds how far into the future Next looks for a matching occurrence
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Rule computes the occurrences of a recurring scheduled message
+type Rule interface {
+	// Next returns the first occurrence strictly after the given time, or the zero time if there is none.
+	// Rules without an explicit time of day reuse the clock of the given time.
+	Next(after time.Time) time.Time
+}
+
+// Spec is a parsed recurrence input: a rule plus optional end conditions
+type Spec struct {
+	Rule           string    // normalized rule text, stored with the message
+	Until          time.Time // zero when there is no end date
+	MaxOccurrences int       // 0 when there is no occurrence limit
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sundays": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mondays": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tuesdays": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wednesdays": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thursdays": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fridays": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "saturdays": time.Saturday, "sat": time.Saturday,
+}
+
+var ordinals = map[string]int{
+	"first": 1, "1st": 1,
+	"second": 2, "2nd": 2,
+	"third": 3, "3rd": 3,
+	"fourth": 4, "4th": 4,
+	"last": -1,
+}
+
+// ParseSpec parses user input such as "every Tuesday 18:00; until 31.12.2026" or "0 18 * * 2; 10 times".
+// End dates are interpreted as the end of that day in loc.
+func ParseSpec(input string, loc *time.Location) (*Spec, error) {
+	parts := strings.Split(input, ";")
+	ruleText := strings.TrimSpace(parts[0])
+	if _, err := Parse(ruleText); err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{Rule: ruleText}
+	for _, part := range parts[1:] {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch {
+		case part == "":
+			continue
+		case strings.HasPrefix(part, "until "):
+			until, err := parseDate(strings.TrimSpace(strings.TrimPrefix(part, "until ")), loc)
+			if err != nil {
+				return nil, err
+			}
+			spec.Until = until.AddDate(0, 0, 1).Add(-time.Second)
+		case strings.HasSuffix(part, " times"):
+			count, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(part, " times")))
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid occurrence count %q", part)
+			}
+			spec.MaxOccurrences = count
+		default:
+			return nil, fmt.Errorf("unknown recurrence option %q (use \"until DD.MM.YYYY\" or \"N times\")", part)
+		}
+	}
+
+	return spec, nil
+}
+
+// Parse parses a recurrence rule. Supported forms are five-field cron expressions
+// ("0 18 * * 2") and presets such as "daily", "weekly", "monthly", "every day 09:00",
+// "every Tuesday 18:00", "every Monday, Thursday at 12:15" and "first Monday of month 18:00".
+func Parse(rule string) (Rule, error) {
+	text := strings.ToLower(strings.TrimSpace(rule))
+	if text == "" {
+		return nil, errors.New("empty recurrence rule")
+	}
+
+	if fields := strings.Fields(text); len(fields) == 5 && looksLikeCron(fields) {
+		return parseCron(fields)
+	}
+
+	// Split off an optional trailing time of day
+	words := strings.Fields(strings.NewReplacer(",", " ", " at ", " ").Replace(" " + text + " "))
+	hour, minute, hasTime := -1, -1, false
+	if len(words) > 1 {
+		if h, m, ok := parseClock(words[len(words)-1]); ok {
+			hour, minute, hasTime = h, m, true
+			words = words[:len(words)-1]
+		}
+	}
+	clock := clock{hour: hour, minute: minute, set: hasTime}
+
+	switch strings.Join(words, " ") {
+	case "daily", "every day":
+		return weekdayRule{days: allDays(), clock: clock}, nil
+	case "weekly", "every week":
+		return weeklyRule{clock: clock}, nil
+	case "monthly", "every month":
+		return monthlyRule{clock: clock}, nil
+	}
+
+	// first/second/third/fourth/last <weekday> of [the|every] month
+	if n, ok := ordinals[words[0]]; ok && len(words) >= 3 {
+		switch strings.Join(words[2:], " ") {
+		case "of month", "of the month", "of every month":
+			day, isDay := weekdays[words[1]]
+			if !isDay {
+				return nil, fmt.Errorf("unknown weekday %q", words[1])
+			}
+			return nthWeekdayRule{n: n, weekday: day, clock: clock}, nil
+		}
+	}
+
+	// every <weekday> [<weekday>...]
+	if words[0] == "every" && len(words) > 1 {
+		var days [7]bool
+		for _, w := range words[1:] {
+			if w == "and" {
+				continue
+			}
+			day, isDay := weekdays[w]
+			if !isDay {
+				return nil, fmt.Errorf("unknown weekday %q", w)
+			}
+			days[day] = true
+		}
+		return weekdayRule{days: days, clock: clock}, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized recurrence rule %q", rule)
+}
+
+// String formats the spec in the same syntax accepted by ParseSpec
+func (s *Spec) String() string {
+	text := s.Rule
+	if !s.Until.IsZero() {
+		text += "; until " + s.Until.Format("02.01.2006")
+	}
+	if s.MaxOccurrences > 0 {
+		text += fmt.Sprintf("; %d times", s.MaxOccurrences)
+	}
+	return text
+}
+
+type clock struct {
+	hour, minute int
+	set          bool
+}
+
+// on returns the occurrence on the given day, using the clock of ref when no time was specified
+func (c clock) on(year int, month time.Month, day int, ref time.Time) time.Time {
+	hour, minute := ref.Hour(), ref.Minute()
+	if c.set {
+		hour, minute = c.hour, c.minute
+	}
+	return time.Date(year, month, day, hour, minute, 0, 0, ref.Location())
+}
+
+// weekdayRule fires on each selected weekday
+type weekdayRule struct {
+	days  [7]bool
+	clock clock
+}
+
+func (r weekdayRule) Next(after time.Time) time.Time {
+	for d := 0; d <= 7; d++ {
+		day := after.AddDate(0, 0, d)
+		if !r.days[day.Weekday()] {
+			continue
+		}
+		if t := r.clock.on(day.Year(), day.Month(), day.Day(), after); t.After(after) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// weeklyRule fires every seven days on the weekday of the previous occurrence
+type weeklyRule struct {
+	clock clock
+}
+
+func (r weeklyRule) Next(after time.Time) time.Time {
+	var days [7]bool
+	days[after.Weekday()] = true
+	return weekdayRule{days: days, clock: r.clock}.Next(after)
+}
+
+// monthlyRule fires on the day of month of the previous occurrence, skipping months that are too short
+type monthlyRule struct {
+	clock clock
+}
+
+func (r monthlyRule) Next(after time.Time) time.Time {
+	year, month, day := after.Date()
+	for i := 0; i <= 12*5; i++ {
+		first := time.Date(year, month+time.Month(i), 1, 0, 0, 0, 0, after.Location())
+		if daysIn(first.Year(), first.Month()) < day {
+			continue
+		}
+		if t := r.clock.on(first.Year(), first.Month(), day, after); t.After(after) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// nthWeekdayRule fires on e.g. the first Monday or last Friday of every month
+type nthWeekdayRule struct {
+	n       int // 1-4, or -1 for the last one
+	weekday time.Weekday
+	clock   clock
+}
+
+func (r nthWeekdayRule) Next(after time.Time) time.Time {
+	year, month, _ := after.Date()
+	for i := 0; i <= 12*5; i++ {
+		first := time.Date(year, month+time.Month(i), 1, 0, 0, 0, 0, after.Location())
+		day := 1 + (int(r.weekday)-int(first.Weekday())+7)%7 + (r.n-1)*7
+		if r.n < 0 {
+			day = 1 + (int(r.weekday)-int(first.Weekday())+7)%7
+			for day+7 <= daysIn(first.Year(), first.Month()) {
+				day += 7
+			}
+		}
+		if day > daysIn(first.Year(), first.Month()) {
+			continue
+		}
+		if t := r.clock.on(first.Year(), first.Month(), day, after); t.After(after) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func allDays() [7]bool {
+	return [7]bool{true, true, true, true, true, true, true}
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// parseClock parses "18:00", "18.00" or "9:30"
+func parseClock(s string) (hour, minute int, ok bool) {
+	sep := strings.IndexAny(s, ":.")
+	if sep < 1 {
+		return 0, 0, false
+	}
+	hour, errH := strconv.Atoi(s[:sep])
+	minute, errM := strconv.Atoi(s[sep+1:])
+	if errH != nil || errM != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 || len(s[sep+1:]) != 2 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// parseDate parses DD.MM.YYYY or YYYY-MM-DD in loc
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"02.01.2006", "2.1.2006", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (use DD.MM.YYYY)", s)
+}
@@ -0,0 +1,113 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+// monday is the time the rules are evaluated from, Monday 6 January 2025 at 10:00
+var monday = time.Date(2025, time.January, 6, 10, 0, 0, 0, time.UTC)
+
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2025, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want time.Time
+	}{
+		// Presets without a time keep the clock of the previous occurrence
+		{"daily", at(time.January, 7, 10, 0)},
+		{"every day 09:00", at(time.January, 7, 9, 0)},
+		{"weekly", at(time.January, 13, 10, 0)},
+		{"monthly", at(time.February, 6, 10, 0)},
+		{"every Tuesday 18:00", at(time.January, 7, 18, 0)},
+		{"every Monday, Thursday at 12:15", at(time.January, 6, 12, 15)},
+		{"every mon and fri 9.30", at(time.January, 10, 9, 30)},
+
+		// Ordinal weekdays, written out and as numbers
+		{"first Monday of month 18:00", at(time.January, 6, 18, 0)},
+		{"first monday of the month", at(time.February, 3, 10, 0)},
+		{"1st monday of the month", at(time.February, 3, 10, 0)},
+		{"2nd tuesday of every month", at(time.January, 14, 10, 0)},
+		{"2nd tuesday of every month 18:00", at(time.January, 14, 18, 0)},
+		{"3rd friday of month", at(time.January, 17, 10, 0)},
+		{"4th sunday of the month at 09:30", at(time.January, 26, 9, 30)},
+		{"last friday of month 17:00", at(time.January, 31, 17, 0)},
+
+		// Cron expressions
+		{"0 18 * * 2", at(time.January, 7, 18, 0)},
+		{"*/15 * * * *", at(time.January, 6, 10, 15)},
+		{"30 9 1,15 * *", at(time.January, 15, 9, 30)},
+		{"0 12 * * 1-5", at(time.January, 6, 12, 0)},
+		{"0 8 * * 0", at(time.January, 12, 8, 0)},
+		{"0 8 * * 7", at(time.January, 12, 8, 0)},
+		{"0 0 1 */3 *", at(time.April, 1, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := rule.Next(monday); !got.Equal(tt.want) {
+				t.Errorf("Next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	rules := []string{
+		"",
+		"someday",
+		"every funday",
+		"5th monday of the month",
+		"first moonday of the month",
+		"60 * * * *",
+		"0 24 * * *",
+		"0 18 * * 8",
+		"*/0 * * * *",
+	}
+	for _, rule := range rules {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", rule)
+		}
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantRule  string
+		wantUntil time.Time
+		wantTimes int
+		wantErr   bool
+	}{
+		{input: "weekly", wantRule: "weekly"},
+		{input: "1st monday of the month; until 31.12.2025", wantRule: "1st monday of the month", wantUntil: time.Date(2025, time.December, 31, 23, 59, 59, 0, time.UTC)},
+		{input: "0 18 * * 2; 10 times", wantRule: "0 18 * * 2", wantTimes: 10},
+		{input: "daily; 0 times", wantErr: true},
+		{input: "daily; x3", wantErr: true},
+		{input: "daily; until tomorrow", wantErr: true},
+		{input: "fortnightly", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			spec, err := ParseSpec(tt.input, time.UTC)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseSpec succeeded with %+v, want an error", spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSpec: %v", err)
+			}
+			if spec.Rule != tt.wantRule || !spec.Until.Equal(tt.wantUntil) || spec.MaxOccurrences != tt.wantTimes {
+				t.Errorf("ParseSpec = %+v", spec)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Package schedule checks scheduled messages entered by people, so the /schedule command and
+// the admin API accept the same messages. Errors are written to be shown to whoever entered the
+// message, in Discord or in an API response.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/betauia/BetaBot.go/bot/dateparse"
+	"github.com/betauia/BetaBot.go/bot/embeds"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/recurrence"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/betauia/BetaBot.go/bot/templates"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Limits of the fields, the same as the inputs of the /schedule modal. Discord posts at most
+// 2000 characters, MaxMessageLength leaves room for the mentions put on the first line and for
+// template variables, which expand to longer text.
+const (
+	MinTitleLength   = 5
+	MaxTitleLength   = 25
+	MaxMessageLength = 1500
+	MaxRepeatLength  = 100
+	MaxEmbedLength   = 4000
+)
+
+var (
+	// ErrEmptyMessage is returned for a message with nothing to post
+	ErrEmptyMessage = errors.New("the message needs content, an embed or an attachment")
+	// ErrTitleTaken is returned when another active message of the guild has the same title
+	ErrTitleTaken = errors.New("another scheduled message in this server already has that title")
+)
+
+// Input is a scheduled message as it was entered, in the /schedule modal or an API request
+type Input struct {
+	Title   string
+	Message string
+	Time    string // see dateparse.Parse, in the guild's timezone
+	Repeat  string // see recurrence.ParseSpec, empty for one-off messages
+	Embed   string // JSON, see embeds.Parse, empty for plain messages
+}
+
+// Message is a checked Input
+type Message struct {
+	Title         string
+	Message       string
+	Embed         *embeds.Spec // nil for plain messages
+	ScheduledTime time.Time
+	Recurrence    *recurrence.Spec // nil for one-off messages
+}
+
+// Parse checks an Input, interpreting its time in loc. It doesn't check that the message has
+// content, as an attachment may be added separately, see CheckContent.
+func Parse(input Input, now time.Time, loc *time.Location) (*Message, error) {
+	title := strings.TrimSpace(input.Title)
+	if n := utf8.RuneCountInString(title); n < MinTitleLength || n > MaxTitleLength {
+		return nil, fmt.Errorf("The title must be %d to %d characters long.", MinTitleLength, MaxTitleLength)
+	}
+	if utf8.RuneCountInString(input.Message) > MaxMessageLength {
+		return nil, fmt.Errorf("The message can be at most %d characters long.", MaxMessageLength)
+	}
+
+	// Validate time format, interpreting it in the guild's timezone
+	scheduledTime, err := dateparse.Parse(input.Time, now, loc)
+	if errors.Is(err, dateparse.ErrInPast) {
+		return nil, fmt.Errorf("The scheduled time must be in the future (it is now %s).", now.In(loc).Format("02.01.2006 15:04 MST"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time format (%v). Use formats like 31.12.2025 16:12, 28.02.2025, tomorrow 09:00, fredag 18:00 or in 2h", err)
+	}
+
+	// Validate the optional recurrence rule
+	var spec *recurrence.Spec
+	if repeat := strings.TrimSpace(input.Repeat); repeat != "" {
+		if utf8.RuneCountInString(repeat) > MaxRepeatLength {
+			return nil, fmt.Errorf("The repeat rule can be at most %d characters long.", MaxRepeatLength)
+		}
+		spec, err = recurrence.ParseSpec(repeat, loc)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid repeat rule: %v\nUse e.g. \"every Tuesday 18:00\", \"first Monday of month 18:00\" or a cron expression like \"0 18 * * 2\", optionally followed by \"; until 31.12.2026\" or \"; 10 times\".", err)
+		}
+	}
+
+	// Validate the optional embed
+	var embed *embeds.Spec
+	if embedJSON := strings.TrimSpace(input.Embed); embedJSON != "" {
+		if utf8.RuneCountInString(embedJSON) > MaxEmbedLength {
+			return nil, fmt.Errorf("The embed can be at most %d characters long.", MaxEmbedLength)
+		}
+		embed, err = embeds.Parse(embedJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid embed: %v\nWrite it as JSON, e.g. {\"title\": \"Weekly meeting\", \"description\": \"See you there!\", \"color\": \"#5865F2\"}", err)
+		}
+	}
+
+	// Check template variables before they are expanded
+	if err := templates.Validate(input.Message); err != nil {
+		return nil, fmt.Errorf("Invalid template variable: %v\n%s", err, VariableHelp())
+	}
+
+	return &Message{
+		Title:         title,
+		Message:       input.Message,
+		Embed:         embed,
+		ScheduledTime: scheduledTime,
+		Recurrence:    spec,
+	}, nil
+}
+
+// CheckContent returns ErrEmptyMessage when a message would post nothing
+func CheckContent(message string, embed *embeds.Spec, hasAttachment bool) error {
+	if strings.TrimSpace(message) == "" && embed == nil && !hasAttachment {
+		return ErrEmptyMessage
+	}
+	return nil
+}
+
+// CheckMassMention checks an @everyone or @here mention, which is empty, everyone or here
+func CheckMassMention(mass models.MassMention) error {
+	switch mass {
+	case models.MentionNone, models.MentionEveryone, models.MentionHere:
+		return nil
+	}
+	return fmt.Errorf("Unknown mass mention %q, use everyone, here or leave it empty.", mass)
+}
+
+// CheckTitle returns ErrTitleTaken when another active message of the guild has the title.
+// exceptID is the message being edited, 0 for new messages.
+func CheckTitle(store storage.Store, guildID, title string, exceptID int64) error {
+	messages, err := store.ScheduledMessages().ListByGuild(guildID)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if msg.ID != exceptID && msg.IsActive() && msg.Title == title {
+			return ErrTitleTaken
+		}
+	}
+	return nil
+}
+
+// Apply copies a checked message onto a scheduled message
+func (m *Message) Apply(msg *models.ScheduledMessage) {
+	msg.Title = m.Title
+	msg.Message = m.Message
+	msg.Embed = EmbedJSON(m.Embed)
+	msg.ScheduledTime = m.ScheduledTime
+	ApplyRecurrence(msg, m.Recurrence)
+}
+
+// ApplyRecurrence sets or clears the recurrence of a scheduled message
+func ApplyRecurrence(msg *models.ScheduledMessage, spec *recurrence.Spec) {
+	if spec == nil {
+		msg.Recurrence, msg.RecurrenceEnd, msg.MaxOccurrences = "", time.Time{}, 0
+		return
+	}
+	msg.Recurrence = spec.Rule
+	msg.RecurrenceEnd = spec.Until
+	msg.MaxOccurrences = spec.MaxOccurrences
+}
+
+// Recurrence returns the recurrence of a scheduled message with its end in loc, nil for one-off messages
+func Recurrence(msg *models.ScheduledMessage, loc *time.Location) *recurrence.Spec {
+	if !msg.IsRecurring() {
+		return nil
+	}
+	return &recurrence.Spec{
+		Rule:           msg.Recurrence,
+		Until:          msg.RecurrenceEnd.In(loc),
+		MaxOccurrences: msg.MaxOccurrences,
+	}
+}
+
+// EmbedJSON returns the stored form of an embed, empty for nil
+func EmbedJSON(spec *embeds.Spec) string {
+	if spec == nil {
+		return ""
+	}
+	return spec.String()
+}
+
+// ChannelLister lists the channels of a guild, it is implemented by *discordgo.Session
+type ChannelLister interface {
+	GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error)
+}
+
+// ResolveChannel finds a text or announcement channel in the guild by ID, mention or name
+func ResolveChannel(channels ChannelLister, guildID, input string) (string, error) {
+	input = strings.TrimSpace(input)
+	input = strings.TrimSuffix(strings.TrimPrefix(input, "<#"), ">")
+	input = strings.TrimPrefix(input, "#")
+
+	list, err := channels.GuildChannels(guildID)
+	if err != nil {
+		return "", fmt.Errorf("could not look up channels: %v", err)
+	}
+
+	for _, channel := range list {
+		if channel.Type != discordgo.ChannelTypeGuildText && channel.Type != discordgo.ChannelTypeGuildNews {
+			continue
+		}
+		if channel.ID == input || strings.EqualFold(channel.Name, input) {
+			return channel.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("no text channel named %q found in the server", input)
+}
+
+// VariableHelp lists the template variables that can be used in messages
+func VariableHelp() string {
+	help := "**Variables:**\n"
+	for _, variable := range templates.Variables {
+		help += fmt.Sprintf("`%s` %s\n", variable.Name, variable.Description)
+	}
+	return help
+}
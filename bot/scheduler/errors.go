@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// maxAttempts is the number of times a message is tried before it is dead-lettered
+	maxAttempts = 5
+
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff between attempts
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 30 * time.Minute
+)
+
+// errInvalidMessage wraps problems with the stored message itself, which no retry can fix
+var errInvalidMessage = errors.New("the message is invalid")
+
+// classifyError reports whether a failed send can't succeed by retrying, together with a
+// reason suitable for showing to the author of the message
+func classifyError(err error) (permanent bool, reason string) {
+	if errors.Is(err, errInvalidMessage) {
+		return true, err.Error()
+	}
+
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) {
+		// Network errors and timeouts are worth retrying
+		return false, err.Error()
+	}
+
+	if restErr.Message != nil {
+		switch restErr.Message.Code {
+		case discordgo.ErrCodeUnknownChannel:
+			return true, "the channel no longer exists"
+		case discordgo.ErrCodeMissingAccess:
+			return true, "the bot can no longer access the channel"
+		case discordgo.ErrCodeMissingPermissions:
+			return true, "the bot is missing permission to post in the channel"
+		case discordgo.ErrCodeRequestEntityTooLarge:
+			return true, "the attachment is too large to be posted in this server"
+		case discordgo.ErrCodeInvalidFormBody:
+			return true, "Discord rejected the message content: " + restErr.Message.Message
+		}
+	}
+
+	if restErr.Response == nil {
+		return false, err.Error()
+	}
+	status := restErr.Response.StatusCode
+	switch {
+	case status == http.StatusTooManyRequests || status >= 500:
+		return false, err.Error()
+	case status == http.StatusNotFound:
+		return true, "the channel no longer exists"
+	case status == http.StatusForbidden:
+		return true, "the bot is not allowed to post in the channel"
+	case status == http.StatusRequestEntityTooLarge:
+		return true, "the attachment is too large to be posted in this server"
+	case status >= 400:
+		return true, err.Error()
+	}
+	return false, err.Error()
+}
+
+// retryDelay returns the backoff before the next attempt, doubling with every attempt made so
+// far and randomized between half and the full delay so retries from many messages spread out
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, retryMaxDelay)
+
+	return delay/2 + rand.N(delay/2+1)
+}
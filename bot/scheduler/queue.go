@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+)
+
+// wakeup is the next time the scheduler has to look at a scheduled message
+type wakeup struct {
+	id    int64
+	at    time.Time
+	index int
+}
+
+// wakeupHeap is a min-heap of wakeups ordered by time, implementing heap.Interface
+type wakeupHeap []*wakeup
+
+func (h wakeupHeap) Len() int           { return len(h) }
+func (h wakeupHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h wakeupHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *wakeupHeap) Push(x any) {
+	w := x.(*wakeup)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *wakeupHeap) Pop() any {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return w
+}
+
+var (
+	queue    wakeupHeap
+	byID     = make(map[int64]*wakeup)
+	wakeChan = make(chan struct{}, 1)
+)
+
+// ScheduleMessage keeps the scheduler in sync with a message that was created, edited, rescheduled
+// or cancelled. It must be called after every change to a scheduled message so it fires on time.
+func ScheduleMessage(msg *models.ScheduledMessage) {
+	switch msg.Status {
+	case models.StatusPending:
+		at := msg.ScheduledTime
+		if msg.NextAttemptAt.After(at) {
+			at = msg.NextAttemptAt
+		}
+		schedule(msg.ID, at)
+	case models.StatusSending:
+		// Look again when the lease expires in case the worker sending it dies
+		schedule(msg.ID, msg.LeaseExpiresAt)
+	default:
+		unschedule(msg.ID)
+	}
+}
+
+// schedule adds or moves the wakeup for a message
+func schedule(id int64, at time.Time) {
+	mu.Lock()
+	if w, exists := byID[id]; exists {
+		w.at = at
+		heap.Fix(&queue, w.index)
+	} else {
+		w := &wakeup{id: id, at: at}
+		heap.Push(&queue, w)
+		byID[id] = w
+	}
+	mu.Unlock()
+
+	notify()
+}
+
+// unschedule removes the wakeup for a message, if any
+func unschedule(id int64) {
+	mu.Lock()
+	if w, exists := byID[id]; exists {
+		heap.Remove(&queue, w.index)
+		delete(byID, id)
+	}
+	mu.Unlock()
+
+	notify()
+}
+
+// notify wakes the scheduler loop so it recomputes its timer
+func notify() {
+	select {
+	case wakeChan <- struct{}{}:
+	default: // a wakeup is already pending
+	}
+}
+
+// resetQueue replaces all wakeups with the given messages
+func resetQueue(messages []*models.ScheduledMessage) {
+	mu.Lock()
+	queue = queue[:0]
+	byID = make(map[int64]*wakeup)
+	mu.Unlock()
+
+	for _, msg := range messages {
+		ScheduleMessage(msg)
+	}
+}
+
+// nextWakeup returns how long until the earliest wakeup, and false if there is none
+func nextWakeup() (time.Duration, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(queue) == 0 {
+		return 0, false
+	}
+	return max(time.Until(queue[0].at), 0), true
+}
+
+// popDue removes all wakeups at or before now and reports whether there were any
+func popDue(now time.Time) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	due := false
+	for len(queue) > 0 && !queue[0].at.After(now) {
+		w := heap.Pop(&queue).(*wakeup)
+		delete(byID, w.id)
+		due = true
+	}
+	return due
+}
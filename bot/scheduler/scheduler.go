@@ -1,85 +1,584 @@
 package scheduler
 
 import (
-	"database/sql"
-	"log"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/embeds"
+	"github.com/betauia/BetaBot.go/bot/logging"
+	"github.com/betauia/BetaBot.go/bot/metrics"
 	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/recurrence"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/betauia/BetaBot.go/bot/templates"
 	"github.com/bwmarrin/discordgo"
 )
 
+const (
+	// leaseDuration is how long a claimed message is reserved for this worker before
+	// another worker may assume it crashed and claim the message again
+	leaseDuration = 2 * time.Minute
+
+	// sendTimeout bounds a single Discord API call, including rate limit waits, so the
+	// send always finishes well before the lease expires
+	sendTimeout = time.Minute
+
+	// claimBatchSize is the maximum number of messages claimed per tick
+	claimBatchSize = 50
+)
+
 var (
 	isRunning bool
 	mu        sync.Mutex
+
+	stopRun    context.CancelFunc     // stops the scheduler loop
+	abortSends context.CancelFunc     // aborts in-flight sends when draining takes too long
+	sendCtx    = context.Background() // parent context of all sends, replaced while running
+	stopped    chan struct{}          // closed once the loop has exited and in-flight sends are done
+	inFlight   sync.WaitGroup
 )
 
-func Start(session *discordgo.Session, db *sql.DB, checkInterval time.Duration) {
+// Start runs the scheduler until ctx is cancelled or Stop is called. Messages are fired from an
+// in-memory queue of wakeup times kept in sync through ScheduleMessage, and the queue is rebuilt
+// from the database every reconcileInterval as a safety net for changes made elsewhere, e.g. by
+// another bot instance. The scheduler can be started again once Wait has returned.
+func Start(ctx context.Context, session discordapi.Session, store storage.Store, reconcileInterval time.Duration) {
 	mu.Lock()
 	if isRunning {
 		mu.Unlock()
-		log.Println("Scheduler is already running")
+		slog.Warn("Scheduler is already running")
 		return
 	}
 	isRunning = true
+
+	ctx, stopRun = context.WithCancel(ctx)
+	sendCtx, abortSends = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	stopped = done
 	mu.Unlock()
 
-	go run(session, db, checkInterval)
+	go func() {
+		run(ctx, session, store, reconcileInterval)
+		inFlight.Wait()
+
+		mu.Lock()
+		isRunning = false
+		abortSends()
+		sendCtx = context.Background()
+		mu.Unlock()
+
+		slog.Info("Scheduler stopped")
+		close(done)
+	}()
 }
 
-func run(session *discordgo.Session, db *sql.DB, checkInterval time.Duration) {
-	ticker := time.NewTicker(checkInterval)
+// Stop stops the scheduler from claiming new messages. Sends already in progress keep running,
+// use Wait to let them finish.
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if stopRun != nil {
+		stopRun()
+	}
+}
+
+// Wait blocks until the scheduler has stopped and its in-flight sends have finished. If ctx
+// expires first, the remaining sends are aborted and ctx's error is returned; aborted messages
+// are retried once their lease expires.
+func Wait(ctx context.Context) error {
+	mu.Lock()
+	done := stopped
+	mu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		mu.Lock()
+		abortSends()
+		mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func run(ctx context.Context, session discordapi.Session, store storage.Store, reconcileInterval time.Duration) {
+	ticker := time.NewTicker(reconcileInterval)
 	defer ticker.Stop()
 
-	log.Printf("✅ Scheduler started - checking every %v", checkInterval)
+	timer := time.NewTimer(reconcileInterval)
+	defer timer.Stop()
+
+	tick(func() {
+		reconcile(store)
+		checkAndSend(session, store)
+	})
+
+	slog.Info("Scheduler started", slog.Duration("reconcile_interval", reconcileInterval))
+
+	for {
+		if wait, ok := nextWakeup(); ok {
+			timer.Reset(wait)
+		} else {
+			timer.Reset(reconcileInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if popDue(time.Now()) {
+				tick(func() { checkAndSend(session, store) })
+			}
+		case <-wakeChan:
+			// The queue changed, recompute the timer
+		case <-ticker.C:
+			tick(func() {
+				reconcile(store)
+				checkAndSend(session, store)
+			})
+		}
+	}
+}
+
+// tick runs one round of the scheduler's work and records how long it took
+func tick(work func()) {
+	start := time.Now()
+	work()
+	metrics.SchedulerTickDuration.Observe(time.Since(start).Seconds())
+	metrics.SchedulerLastTick.SetToCurrentTime()
+}
+
+// reconcile rebuilds the wakeup queue from the database
+func reconcile(store storage.Store) {
+	messages, err := store.ScheduledMessages().ListActive()
+	if err != nil {
+		slog.Error("Failed to reconcile scheduled messages", logging.Err(err))
+		return
+	}
+	resetQueue(messages)
+	metrics.ScheduledMessagesPending.Set(float64(len(messages)))
+}
+
+// checkAndSend claims and sends due messages. Claiming is atomic, so a message that is
+// still being sent when the next tick runs, or that another bot instance picked up, is skipped.
+// Batches are claimed until one comes back short, since the wakeups of all due messages were
+// already taken off the queue.
+func checkAndSend(session discordapi.Session, store storage.Store) {
+	for {
+		dueMessages, err := store.ScheduledMessages().ClaimDue(time.Now(), leaseDuration, claimBatchSize)
+		if err != nil {
+			slog.Error("Failed to claim due messages", logging.Err(err))
+			return
+		}
+
+		if len(dueMessages) == 0 {
+			return
+		}
+
+		slog.Info("Claimed due messages", slog.Int("count", len(dueMessages)))
+
+		for _, msg := range dueMessages {
+			ScheduleMessage(msg)
+			inFlight.Add(1)
+			go func() {
+				defer inFlight.Done()
+				sendMessage(session, store, msg)
+			}()
+		}
 
-	for range ticker.C {
-		checkAndSend(session, db)
+		if len(dueMessages) < claimBatchSize {
+			return
+		}
 	}
 }
 
-// checkAndSend retrieves and sends due messages
-func checkAndSend(session *discordgo.Session, db *sql.DB) {
-	dueMessages, err := models.GetPendingMessages(db)
+// sendMessage sends a single scheduled message that has been claimed by this worker
+func sendMessage(session discordapi.Session, store storage.Store, msg *models.ScheduledMessage) {
+	messageLogger(msg).Info("Sending scheduled message", slog.String("title", msg.Title), slog.Int("attempt", msg.Attempts))
+
+	content, err := BuildMessageContent(msg, TemplateData(session, store, msg))
+	if err != nil {
+		// A role or channel used by the message may have been renamed, but the guild
+		// not being available right now is worth retrying
+		if !errors.Is(err, templates.ErrGuildUnavailable) {
+			err = fmt.Errorf("%w: %v", errInvalidMessage, err)
+		}
+		handleSendFailure(session, store, msg, err)
+		return
+	}
+	embeds, err := BuildMessageEmbeds(msg)
 	if err != nil {
-		log.Printf("❌ Error fetching due messages: %v", err)
+		handleSendFailure(session, store, msg, fmt.Errorf("%w: %v", errInvalidMessage, err))
 		return
 	}
+	attachments, err := store.Attachments().ListByMessage(msg.ID)
+	if err != nil {
+		handleSendFailure(session, store, msg, fmt.Errorf("loading attachments: %w", err))
+		return
+	}
+
+	mu.Lock()
+	parent := sendCtx
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(parent, sendTimeout)
+	defer cancel()
+
+	// A previous attempt may have reached Discord without being recorded, e.g. when the
+	// request timed out or the bot crashed, so check for an existing post before resending
+	var posted *discordgo.Message
+	if msg.Attempts > 1 {
+		posted = findExistingPost(ctx, session, msg, content, embeds)
+		if posted != nil {
+			messageLogger(msg).Info("Message was already posted, not sending again", slog.String("posted_message_id", posted.ID))
+		}
+	}
 
-	if len(dueMessages) == 0 {
+	if posted == nil {
+		posted, err = session.ChannelMessageSendComplex(msg.ChannelID, &discordgo.MessageSend{
+			Content:         content,
+			Embeds:          embeds,
+			Files:           buildFiles(attachments),
+			AllowedMentions: BuildAllowedMentions(msg),
+		}, discordgo.WithContext(ctx))
+	}
+	if err != nil {
+		handleSendFailure(session, store, msg, err)
 		return
 	}
 
-	log.Printf("📬 Found %d message(s) to send", len(dueMessages))
+	recordDelivery(store, msg, posted.ID, nil)
+	metrics.ScheduledMessagesSent.Inc()
 
-	for _, msg := range dueMessages {
-		go sendMessage(session, db, msg)
+	if msg.IsRecurring() {
+		if err := scheduleNextOccurrence(store, msg, posted.ID); err != nil {
+			messageLogger(msg).Error("Sent message but failed to schedule the next occurrence", logging.Err(err))
+			return
+		}
+	} else if err := markSent(store, msg, posted.ID); errors.Is(err, models.ErrLeaseLost) {
+		messageLogger(msg).Warn("Sent message but its lease expired and another worker claimed it")
+		return
+	} else if err != nil {
+		messageLogger(msg).Error("Sent message but failed to mark it as sent", logging.Err(err))
+		return
 	}
+
+	// Stored files are only kept until the last delivery
+	if msg.Status == models.StatusSent && len(attachments) > 0 {
+		deleteAttachments(store, msg)
+	}
+
+	ScheduleMessage(msg)
+	messageLogger(msg).Info("Sent scheduled message", slog.String("posted_message_id", posted.ID))
 }
 
-// sendMessage sends a single scheduled message
-func sendMessage(session *discordgo.Session, db *sql.DB, msg *models.ScheduledMessage) {
-	log.Printf("📤 Sending: [%d] %s", msg.ID, msg.Title)
+// markSent records the final delivery of a message and releases its lease
+func markSent(store storage.Store, msg *models.ScheduledMessage, postedMessageID string) error {
+	msg.MarkSent(postedMessageID, time.Now())
+	return store.ScheduledMessages().ReleaseLease(msg)
+}
 
-	content := buildMessageContent(msg)
+// buildFiles turns stored attachments into files for an upload
+func buildFiles(attachments []*models.Attachment) []*discordgo.File {
+	var files []*discordgo.File
+	for _, attachment := range attachments {
+		files = append(files, &discordgo.File{
+			Name:        attachment.Filename,
+			ContentType: attachment.ContentType,
+			Reader:      bytes.NewReader(attachment.Data),
+		})
+	}
+	return files
+}
+
+// deleteAttachments removes the stored files of a message that won't be posted again
+func deleteAttachments(store storage.Store, msg *models.ScheduledMessage) {
+	if err := store.Attachments().DeleteByMessage(msg.ID); err != nil {
+		messageLogger(msg).Warn("Failed to delete attachments", logging.Err(err))
+		return
+	}
+	messageLogger(msg).Debug("Deleted stored attachments")
+}
+
+// findExistingPost looks for a copy of the message posted by the bot since its scheduled time
+func findExistingPost(ctx context.Context, session discordapi.Session, msg *models.ScheduledMessage, content string, embeds []*discordgo.MessageEmbed) *discordgo.Message {
+	botUserID := session.BotUserID()
+	if botUserID == "" {
+		return nil
+	}
 
-	_, err := session.ChannelMessageSend(msg.ChannelID, content)
+	recent, err := session.ChannelMessages(msg.ChannelID, 100, "", snowflakeAt(msg.ScheduledTime), "", discordgo.WithContext(ctx))
 	if err != nil {
-		log.Printf("❌ Failed to send [%d]: %v", msg.ID, err)
+		messageLogger(msg).Warn("Could not check for an earlier post", logging.Err(err))
+		return nil
+	}
+
+	for _, m := range recent {
+		if m.Author != nil && m.Author.ID == botUserID && m.Content == content && sameEmbeds(m.Embeds, embeds) {
+			return m
+		}
+	}
+	return nil
+}
+
+// sameEmbeds compares the text of posted embeds with the embeds of a scheduled message
+func sameEmbeds(posted, embeds []*discordgo.MessageEmbed) bool {
+	if len(posted) != len(embeds) {
+		return false
+	}
+	for i := range embeds {
+		if posted[i].Title != embeds[i].Title || posted[i].Description != embeds[i].Description {
+			return false
+		}
+	}
+	return true
+}
+
+// snowflakeAt returns the smallest Discord snowflake ID that could have been created at t
+func snowflakeAt(t time.Time) string {
+	const discordEpoch = 1420070400000
+	ms := t.UnixMilli() - discordEpoch
+	if ms < 0 {
+		ms = 0
+	}
+	return strconv.FormatInt(ms<<22, 10)
+}
+
+// handleSendFailure schedules a retry with backoff for transient errors, and dead-letters the
+// message and notifies its author when the error is permanent or the attempts are used up
+func handleSendFailure(session discordapi.Session, store storage.Store, msg *models.ScheduledMessage, sendErr error) {
+	recordDelivery(store, msg, "", sendErr)
+
+	permanent, reason := classifyError(sendErr)
+	if !permanent && msg.Attempts < maxAttempts {
+		retryAt := time.Now().Add(retryDelay(msg.Attempts))
+		messageLogger(msg).Warn("Failed to send scheduled message, retrying", slog.Int("attempt", msg.Attempts), slog.Int("max_attempts", maxAttempts), slog.Time("retry_at", retryAt), logging.Err(sendErr))
+		metrics.ScheduledMessageRetries.Inc()
+		msg.MarkRetry(sendErr.Error(), retryAt)
+		if err := store.ScheduledMessages().ReleaseLease(msg); err != nil {
+			messageLogger(msg).Error("Failed to schedule retry", logging.Err(err))
+			return
+		}
+		ScheduleMessage(msg)
 		return
 	}
 
-	if err := msg.Delete(db); err != nil {
-		log.Printf("⚠️ Sent [%d] but failed to mark as sent: %v", msg.ID, err)
+	if !permanent {
+		reason = fmt.Sprintf("it still failed after %d attempts (%s)", msg.Attempts, reason)
+	}
+	messageLogger(msg).Error("Giving up on scheduled message", slog.String("reason", reason))
+	metrics.ScheduledMessagesFailed.Inc()
+	msg.MarkFailed(reason)
+	if err := store.ScheduledMessages().ReleaseLease(msg); err != nil {
+		messageLogger(msg).Error("Failed to mark message as failed", logging.Err(err))
 		return
 	}
+	ScheduleMessage(msg)
+
+	notifyAuthor(session, store, msg, reason)
+}
+
+// notifyAuthor tells the author of a dead-lettered message why it wasn't posted, with buttons to fix it
+func notifyAuthor(session discordapi.Session, store storage.Store, msg *models.ScheduledMessage, reason string) {
+	dm, err := session.UserChannelCreate(msg.UserID)
+	if err != nil {
+		messageLogger(msg).Warn("Could not open DM with author", slog.String(logging.UserID, msg.UserID), logging.Err(err))
+		return
+	}
+
+	loc := storage.GuildLocation(store, msg.GuildID)
+	_, err = session.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("⚠️ Your scheduled message **%s** (ID: %d) for <#%s> at %s could not be posted because %s.\n"+
+			"Reschedule it to pick a new time or channel, or cancel it.",
+			msg.Title, msg.ID, msg.ChannelID, msg.ScheduledTime.In(loc).Format("02.01.2006 15:04 MST"), reason),
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						CustomID: fmt.Sprintf("deadletter_reschedule:%d", msg.ID),
+						Label:    "Reschedule",
+						Style:    discordgo.PrimaryButton,
+					},
+					discordgo.Button{
+						CustomID: fmt.Sprintf("deadletter_cancel:%d", msg.ID),
+						Label:    "Cancel message",
+						Style:    discordgo.SecondaryButton,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		messageLogger(msg).Warn("Could not DM author", slog.String(logging.UserID, msg.UserID), logging.Err(err))
+	}
+}
+
+// recordDelivery stores the outcome of a send attempt in the delivery history
+func recordDelivery(store storage.Store, msg *models.ScheduledMessage, postedMessageID string, sendErr error) {
+	delivery := &models.Delivery{
+		ScheduledMessageID: msg.ID,
+		GuildID:            msg.GuildID,
+		ChannelID:          msg.ChannelID,
+		Title:              msg.Title,
+		PostedMessageID:    postedMessageID,
+		Status:             models.StatusSent,
+		DeliveredAt:        time.Now(),
+	}
+	if sendErr != nil {
+		delivery.Status = models.StatusFailed
+		delivery.Error = sendErr.Error()
+	}
+
+	if err := store.Deliveries().Create(delivery); err != nil {
+		messageLogger(msg).Warn("Failed to record delivery", logging.Err(err))
+	}
+}
+
+// scheduleNextOccurrence moves a recurring message to its next occurrence, or marks it as sent once the recurrence has ended
+func scheduleNextOccurrence(store storage.Store, msg *models.ScheduledMessage, postedMessageID string) error {
+	msg.Occurrences++
+	msg.PostedMessageID = postedMessageID
+	msg.SentAt = time.Now()
+
+	next, err := NextOccurrence(msg, time.Now(), storage.GuildLocation(store, msg.GuildID))
+	if err != nil {
+		// Don't leave the message leased, or it would be posted again once the lease expires
+		msg.MarkFailed(fmt.Sprintf("invalid recurrence rule: %v", err))
+		return store.ScheduledMessages().ReleaseLease(msg)
+	}
+	if next.IsZero() {
+		messageLogger(msg).Info("Recurrence has ended")
+		msg.Status = models.StatusSent
+		return store.ScheduledMessages().ReleaseLease(msg)
+	}
+
+	msg.ScheduledTime = next
+	msg.ResetDelivery()
+	if err := store.ScheduledMessages().ReleaseLease(msg); err != nil {
+		return err
+	}
+
+	messageLogger(msg).Info("Scheduled next occurrence", slog.Time("next", next))
+	return nil
+}
+
+// NextOccurrence returns the first occurrence of a recurring message after the given time,
+// evaluating the rule in loc and skipping occurrences missed while the bot was offline.
+// It returns the zero time when the message doesn't recur or the recurrence has ended.
+func NextOccurrence(msg *models.ScheduledMessage, after time.Time, loc *time.Location) (time.Time, error) {
+	if !msg.IsRecurring() {
+		return time.Time{}, nil
+	}
+	if msg.MaxOccurrences > 0 && msg.Occurrences >= msg.MaxOccurrences {
+		return time.Time{}, nil
+	}
+
+	rule, err := recurrence.Parse(msg.Recurrence)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-	log.Printf("✅ Successfully sent [%d] %s", msg.ID, msg.Title)
+	next := msg.ScheduledTime.In(loc)
+	for !next.After(after) {
+		next = rule.Next(next)
+		if next.IsZero() {
+			return time.Time{}, nil
+		}
+	}
+
+	if !msg.RecurrenceEnd.IsZero() && next.After(msg.RecurrenceEnd) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
+
+// BuildMessageContent constructs the Discord message. It is also used by the
+// /schedule preview command so previews match what is actually posted.
+// Template variables are expanded with data, the chosen mentions are put on the first
+// line, and @everyone and @here in the message body are defused so they show as text.
+func BuildMessageContent(msg *models.ScheduledMessage, data *templates.Data) (string, error) {
+	content, err := templates.Expand(msg.Message, data)
+	if err != nil {
+		return "", err
+	}
+	content = massMentionReplacer.Replace(content)
+
+	var mentions []string
+	if msg.MassMention != models.MentionNone {
+		mentions = append(mentions, "@"+string(msg.MassMention))
+	}
+	for _, roleID := range msg.MentionRoleIDs() {
+		mentions = append(mentions, "<@&"+roleID+">")
+	}
+	for _, userID := range msg.MentionUsers() {
+		mentions = append(mentions, "<@"+userID+">")
+	}
+	if len(mentions) > 0 {
+		content = strings.Join(mentions, " ") + "\n" + content
+	}
+
+	return content, nil
+}
+
+// TemplateData returns the values template variables in the message are expanded with
+func TemplateData(session discordapi.Session, store storage.Store, msg *models.ScheduledMessage) *templates.Data {
+	return &templates.Data{
+		Time:       msg.ScheduledTime.In(storage.GuildLocation(store, msg.GuildID)),
+		Occurrence: msg.Occurrences + 1,
+		Guild: func() (*discordgo.Guild, error) {
+			return session.LoadGuild(msg.GuildID)
+		},
+	}
+}
+
+// BuildMessageEmbeds constructs the embeds posted with the message, nil for plain messages.
+// Like BuildMessageContent it is shared with the previews.
+func BuildMessageEmbeds(msg *models.ScheduledMessage) ([]*discordgo.MessageEmbed, error) {
+	if msg.Embed == "" {
+		return nil, nil
+	}
+	spec, err := embeds.Parse(msg.Embed)
+	if err != nil {
+		return nil, err
+	}
+	return []*discordgo.MessageEmbed{spec.MessageEmbed(msg.ScheduledTime)}, nil
+}
+
+// massMentionReplacer inserts a zero-width space after the @ of @everyone and @here
+var massMentionReplacer = strings.NewReplacer("@everyone", "@\u200beveryone", "@here", "@\u200bhere")
+
+// BuildAllowedMentions returns the mentions Discord may ping when the message is posted:
+// only the roles, users and @everyone/@here chosen for it, never anything in the message body
+func BuildAllowedMentions(msg *models.ScheduledMessage) *discordgo.MessageAllowedMentions {
+	allowed := &discordgo.MessageAllowedMentions{
+		Roles: msg.MentionRoleIDs(),
+		Users: msg.MentionUsers(),
+	}
+	if msg.MassMention != models.MentionNone {
+		// Allows both @everyone and @here, but the body can't contain either, see BuildMessageContent
+		allowed.Parse = []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeEveryone}
+	}
+	return allowed
 }
 
-// buildMessageContent constructs the Discord message
-func buildMessageContent(msg *models.ScheduledMessage) string {
-	content := msg.Message
-	return content
+// messageLogger returns a logger with the attributes of a scheduled message, see package logging
+func messageLogger(msg *models.ScheduledMessage) *slog.Logger {
+	return slog.With(
+		slog.Int64(logging.ScheduledMessageID, msg.ID),
+		slog.String(logging.GuildID, msg.GuildID),
+		slog.String(logging.ChannelID, msg.ChannelID),
+	)
 }
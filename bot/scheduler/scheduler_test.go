@@ -0,0 +1,522 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/discordapi"
+	"github.com/betauia/BetaBot.go/bot/discordapi/discordtest"
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage"
+	"github.com/bwmarrin/discordgo"
+)
+
+// openWorkers opens n stores on one SQLite file, like n bot instances sharing a database
+func openWorkers(t *testing.T, n int) []storage.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	var stores []storage.Store
+	for range n {
+		store, err := storage.Open("sqlite:" + path)
+		if err != nil {
+			t.Fatalf("opening store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		stores = append(stores, store)
+	}
+	return stores
+}
+
+// createDue stores a message that was due a minute ago
+func createDue(t *testing.T, store storage.Store, title string) *models.ScheduledMessage {
+	t.Helper()
+	msg := &models.ScheduledMessage{
+		Title:         title,
+		GuildID:       discordtest.GuildID,
+		UserID:        discordtest.UserID,
+		Message:       "Hello from " + title,
+		ScheduledTime: time.Now().Add(-time.Minute),
+		ChannelID:     discordtest.ChannelID,
+		Status:        models.StatusPending,
+	}
+	if err := store.ScheduledMessages().Create(msg); err != nil {
+		t.Fatalf("creating message: %v", err)
+	}
+	return msg
+}
+
+// getMessage reads a message back from the database
+func getMessage(t *testing.T, store storage.Store, id int64) *models.ScheduledMessage {
+	t.Helper()
+	msg, err := store.ScheduledMessages().GetByID(id)
+	if err != nil {
+		t.Fatalf("getting message %d: %v", id, err)
+	}
+	return msg
+}
+
+// blockingSession is a Discord whose posts in channels hang until release is closed,
+// like a send stuck behind a rate limit. A post whose request is cancelled gives up.
+type blockingSession struct {
+	*discordtest.Fake
+	entered chan struct{} // receives once for every post that started
+	release chan struct{}
+}
+
+func (s *blockingSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	ctx := requestContext(options)
+	s.entered <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.Fake.ChannelMessageSendComplex(channelID, data, options...)
+}
+
+// requestContext returns the context discordgo would make the request with
+func requestContext(options []discordgo.RequestOption) context.Context {
+	cfg := &discordgo.RequestConfig{Request: httptest.NewRequest(http.MethodPost, "/", nil)}
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg.Request.Context()
+}
+
+// waitEntered waits for a post to start on the session
+func waitEntered(t *testing.T, session *blockingSession) {
+	t.Helper()
+	select {
+	case <-session.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no post was started")
+	}
+}
+
+// slowSession is a Discord that takes delay for every post
+type slowSession struct {
+	*discordtest.Fake
+	delay time.Duration
+}
+
+func (s *slowSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	time.Sleep(s.delay)
+	return s.Fake.ChannelMessageSendComplex(channelID, data, options...)
+}
+
+// failingSession is a Discord where posts in one channel fail, while DMs still go through
+type failingSession struct {
+	*discordtest.Fake
+	channelID string
+	err       error
+}
+
+func (s *failingSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if channelID == s.channelID {
+		return nil, s.err
+	}
+	return s.Fake.ChannelMessageSendComplex(channelID, data, options...)
+}
+
+// failAttempts records n failed attempts of a message, as if earlier ticks had tried it
+func failAttempts(t *testing.T, store storage.Store, n int) {
+	t.Helper()
+	for range n {
+		claimed, err := store.ScheduledMessages().ClaimDue(time.Now(), leaseDuration, claimBatchSize)
+		if err != nil || len(claimed) != 1 {
+			t.Fatalf("ClaimDue = %d messages, %v", len(claimed), err)
+		}
+		claimed[0].MarkRetry("earlier failure", time.Now().Add(-time.Second))
+		if err := store.ScheduledMessages().ReleaseLease(claimed[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSendOutcomes(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error  // what posting in the channel fails with, nil to succeed
+		earlier       int    // failed attempts before this one
+		recurrence    string // rule of a recurring message
+		wantStatus    models.MessageStatus
+		wantAttempts  int
+		wantRetry     [2]time.Duration // bounds of the backoff from now, zero when not retried
+		wantNext      time.Duration    // how much later a recurring message is scheduled
+		wantPosts     int
+		wantDM        string // part of the DM to the author, empty for no DM
+		wantDelivered models.MessageStatus
+	}{
+		{
+			name:          "sent",
+			wantStatus:    models.StatusSent,
+			wantAttempts:  1,
+			wantPosts:     1,
+			wantDelivered: models.StatusSent,
+		},
+		{
+			name:          "rate limited",
+			err:           discordtest.RESTError(http.StatusTooManyRequests, 0),
+			wantStatus:    models.StatusPending,
+			wantAttempts:  1,
+			wantRetry:     [2]time.Duration{retryBaseDelay / 2, retryBaseDelay},
+			wantDelivered: models.StatusFailed,
+		},
+		{
+			name:          "server error backs off",
+			err:           discordtest.RESTError(http.StatusBadGateway, 0),
+			earlier:       2,
+			wantStatus:    models.StatusPending,
+			wantAttempts:  3,
+			wantRetry:     [2]time.Duration{2 * retryBaseDelay, 4 * retryBaseDelay},
+			wantDelivered: models.StatusFailed,
+		},
+		{
+			name:          "rate limited on the last attempt",
+			err:           discordtest.RESTError(http.StatusTooManyRequests, 0),
+			earlier:       maxAttempts - 1,
+			wantStatus:    models.StatusFailed,
+			wantAttempts:  maxAttempts,
+			wantDM:        fmt.Sprintf("still failed after %d attempts", maxAttempts),
+			wantDelivered: models.StatusFailed,
+		},
+		{
+			name:          "missing permissions",
+			err:           discordtest.RESTError(http.StatusForbidden, discordgo.ErrCodeMissingPermissions),
+			wantStatus:    models.StatusFailed,
+			wantAttempts:  1,
+			wantDM:        "the bot is missing permission to post in the channel",
+			wantDelivered: models.StatusFailed,
+		},
+		{
+			name:          "unknown channel",
+			err:           discordtest.RESTError(http.StatusNotFound, discordgo.ErrCodeUnknownChannel),
+			wantStatus:    models.StatusFailed,
+			wantAttempts:  1,
+			wantDM:        "the channel no longer exists",
+			wantDelivered: models.StatusFailed,
+		},
+		{
+			name:          "recurring",
+			recurrence:    "daily",
+			wantStatus:    models.StatusPending,
+			wantAttempts:  0,
+			wantNext:      24 * time.Hour,
+			wantPosts:     1,
+			wantDelivered: models.StatusSent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := openWorkers(t, 1)[0]
+			fake := discordtest.NewGuild()
+			msg := createDue(t, store, tt.name)
+			if tt.recurrence != "" {
+				msg.Recurrence = tt.recurrence
+				msg.ScheduledTime = msg.ScheduledTime.Truncate(time.Minute) // rules fire on whole minutes
+				if err := store.ScheduledMessages().Update(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			failAttempts(t, store, tt.earlier)
+
+			var session discordapi.Session = fake
+			if tt.err != nil {
+				session = &failingSession{Fake: fake, channelID: discordtest.ChannelID, err: tt.err}
+			}
+			before := time.Now()
+			checkAndSend(session, store)
+			inFlight.Wait()
+
+			got := getMessage(t, store, msg.ID)
+			if got.Status != tt.wantStatus || got.Attempts != tt.wantAttempts {
+				t.Errorf("status %s after %d attempts, want %s after %d", got.Status, got.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if tt.wantRetry[1] != 0 {
+				if wait := got.NextAttemptAt.Sub(before); wait < tt.wantRetry[0] || wait > tt.wantRetry[1]+time.Second {
+					t.Errorf("retrying in %v, want between %v and %v", wait, tt.wantRetry[0], tt.wantRetry[1])
+				}
+			} else if !got.NextAttemptAt.IsZero() {
+				t.Errorf("retrying at %v, want no retry", got.NextAttemptAt)
+			}
+			if tt.err != nil && got.LastError == "" {
+				t.Error("the error wasn't recorded")
+			}
+			if next := got.ScheduledTime.Sub(msg.ScheduledTime); next != tt.wantNext {
+				t.Errorf("moved %v later, want %v", next, tt.wantNext)
+			}
+			if posts := fake.Sent(discordtest.ChannelID); len(posts) != tt.wantPosts {
+				t.Errorf("posted %d times, want %d", len(posts), tt.wantPosts)
+			}
+			if tt.recurrence != "" && (got.Occurrences != 1 || got.PostedMessageID == "") {
+				t.Errorf("after the first occurrence: %d occurrences, posted message %q", got.Occurrences, got.PostedMessageID)
+			}
+
+			dms := fake.DirectMessages(discordtest.UserID)
+			switch {
+			case tt.wantDM == "" && len(dms) > 0:
+				t.Errorf("DMed the author %q", dms[0].Content)
+			case tt.wantDM != "" && len(dms) != 1:
+				t.Errorf("DMed the author %d times, want once", len(dms))
+			case tt.wantDM != "":
+				if !strings.Contains(dms[0].Content, tt.wantDM) {
+					t.Errorf("DM %q doesn't say %q", dms[0].Content, tt.wantDM)
+				}
+				var buttons []string
+				for _, row := range dms[0].Components {
+					for _, button := range row.(discordgo.ActionsRow).Components {
+						buttons = append(buttons, button.(discordgo.Button).CustomID)
+					}
+				}
+				want := []string{fmt.Sprintf("deadletter_reschedule:%d", msg.ID), fmt.Sprintf("deadletter_cancel:%d", msg.ID)}
+				if strings.Join(buttons, " ") != strings.Join(want, " ") {
+					t.Errorf("DM buttons %q, want %q", buttons, want)
+				}
+			}
+
+			deliveries, err := store.Deliveries().ListRecentByGuild(discordtest.GuildID, 1)
+			if err != nil || len(deliveries) != 1 {
+				t.Fatalf("ListRecentByGuild = %d deliveries, %v", len(deliveries), err)
+			}
+			if deliveries[0].Status != tt.wantDelivered {
+				t.Errorf("delivery recorded as %s, want %s", deliveries[0].Status, tt.wantDelivered)
+			}
+		})
+	}
+}
+
+func TestSlowSendIsNotClaimedAgain(t *testing.T) {
+	workers := openWorkers(t, 2)
+	fake := discordtest.NewGuild()
+	session := &blockingSession{Fake: fake, entered: make(chan struct{}, 1), release: make(chan struct{})}
+	msg := createDue(t, workers[0], "slow")
+
+	// The first worker claims the message and hangs while posting it
+	checkAndSend(session, workers[0])
+	waitEntered(t, session)
+
+	// Every tick of the second worker, and of the first, skips the message while it is leased
+	checkAndSend(fake, workers[1])
+	checkAndSend(fake, workers[0])
+	if got := getMessage(t, workers[1], msg.ID); got.Status != models.StatusSending || got.Attempts != 1 {
+		t.Errorf("while posting: status %s after %d attempts, want sending after 1", got.Status, got.Attempts)
+	}
+
+	close(session.release)
+	inFlight.Wait()
+
+	if sent := fake.Sent(discordtest.ChannelID); len(sent) != 1 {
+		t.Fatalf("posted %d times, want once", len(sent))
+	}
+	if got := getMessage(t, workers[1], msg.ID); got.Status != models.StatusSent {
+		t.Errorf("status %s after posting, want sent", got.Status)
+	}
+}
+
+func TestExpiredLeaseIsReclaimedWithoutPostingTwice(t *testing.T) {
+	workers := openWorkers(t, 2)
+	fake := discordtest.NewGuild()
+	msg := createDue(t, workers[0], "crashed")
+
+	// The first worker claims the message and posts it, then dies before recording the post
+	claimed, err := workers[0].ScheduledMessages().ClaimDue(time.Now(), time.Millisecond, claimBatchSize)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("ClaimDue = %d messages, %v", len(claimed), err)
+	}
+	content, err := BuildMessageContent(claimed[0], TemplateData(fake, workers[0], claimed[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted, err := fake.ChannelMessageSendComplex(discordtest.ChannelID, &discordgo.MessageSend{Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) // let the lease expire
+
+	// The second worker takes over, finds the post and records it instead of posting again
+	checkAndSend(fake, workers[1])
+	inFlight.Wait()
+
+	if sent := fake.Sent(discordtest.ChannelID); len(sent) != 1 {
+		t.Fatalf("posted %d times, want once", len(sent))
+	}
+	if len(fake.CallsTo("ChannelMessages")) != 1 {
+		t.Error("the retry didn't look for an earlier post")
+	}
+	got := getMessage(t, workers[1], msg.ID)
+	if got.Status != models.StatusSent || got.PostedMessageID != posted.ID || got.Attempts != 2 {
+		t.Errorf("after taking over: status %s, posted message %q after %d attempts, want sent, %q after 2", got.Status, got.PostedMessageID, got.Attempts, posted.ID)
+	}
+}
+
+func TestFirstAttemptDoesNotLookForEarlierPosts(t *testing.T) {
+	store := openWorkers(t, 1)[0]
+	fake := discordtest.NewGuild()
+	createDue(t, store, "first")
+
+	checkAndSend(fake, store)
+	inFlight.Wait()
+
+	if calls := fake.CallsTo("ChannelMessages"); len(calls) != 0 {
+		t.Errorf("the first attempt read the channel %d times, want none", len(calls))
+	}
+	if sent := fake.Sent(discordtest.ChannelID); len(sent) != 1 {
+		t.Errorf("posted %d times, want once", len(sent))
+	}
+}
+
+func TestMoreDueMessagesThanOneBatch(t *testing.T) {
+	const messages = 2*claimBatchSize + 1
+	store := openWorkers(t, 1)[0]
+	fake := discordtest.NewGuild()
+	for i := range messages {
+		createDue(t, store, fmt.Sprintf("message %d", i))
+	}
+
+	// A single tick sends them all, nothing is left waiting for the next reconcile
+	checkAndSend(fake, store)
+	inFlight.Wait()
+
+	if sent := fake.Sent(discordtest.ChannelID); len(sent) != messages {
+		t.Errorf("posted %d messages in one tick, want all %d", len(sent), messages)
+	}
+	pending, err := store.ScheduledMessages().ListActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("%d messages still pending after the tick", len(pending))
+	}
+}
+
+func TestConcurrentWorkersPostEveryMessageOnce(t *testing.T) {
+	const messages = 20
+	workers := openWorkers(t, 2)
+	fake := discordtest.NewGuild()
+	session := &slowSession{Fake: fake, delay: 20 * time.Millisecond}
+	for i := range messages {
+		createDue(t, workers[0], fmt.Sprintf("message %d", i))
+	}
+
+	// Both workers tick at the same time, repeatedly, while the posts are slow
+	var wg sync.WaitGroup
+	for _, store := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				checkAndSend(session, store)
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	inFlight.Wait()
+
+	sent := fake.Sent(discordtest.ChannelID)
+	posts := map[string]int{}
+	for _, post := range sent {
+		posts[post.Content]++
+	}
+	if len(sent) != messages || len(posts) != messages {
+		t.Fatalf("posted %d times for %d different messages, want every one of the %d messages once", len(sent), len(posts), messages)
+	}
+
+	list, err := workers[1].ScheduledMessages().ListByGuild(discordtest.GuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range list {
+		if msg.Status != models.StatusSent || msg.Attempts != 1 {
+			t.Errorf("%s: status %s after %d attempts, want sent after 1", msg.Title, msg.Status, msg.Attempts)
+		}
+	}
+}
+
+func TestStartStopWait(t *testing.T) {
+	store := openWorkers(t, 1)[0]
+	fake := discordtest.NewGuild()
+	t.Cleanup(func() {
+		Stop()
+		Wait(context.Background())
+	})
+
+	// Stopping leaves the send in flight, and Wait returns once it is done
+	session := &blockingSession{Fake: fake, entered: make(chan struct{}, 1), release: make(chan struct{})}
+	drained := createDue(t, store, "drained")
+	Start(context.Background(), session, store, time.Hour)
+	waitEntered(t, session)
+	Stop()
+
+	waited := make(chan error, 1)
+	go func() { waited <- Wait(context.Background()) }()
+	select {
+	case err := <-waited:
+		t.Fatalf("Wait returned %v while a send was in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(session.release)
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatalf("Wait = %v after the send finished", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait didn't return after the send finished")
+	}
+	if got := getMessage(t, store, drained.ID); got.Status != models.StatusSent {
+		t.Errorf("drained message is %s, want sent", got.Status)
+	}
+
+	// When Wait's deadline passes first, the send is aborted and the message retried later
+	session = &blockingSession{Fake: fake, entered: make(chan struct{}, 1), release: make(chan struct{})}
+	aborted := createDue(t, store, "aborted")
+	Start(context.Background(), session, store, time.Hour)
+	waitEntered(t, session)
+	Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want the deadline to pass", err)
+	}
+	if err := Wait(context.Background()); err != nil {
+		t.Fatalf("Wait = %v after aborting", err)
+	}
+	got := getMessage(t, store, aborted.ID)
+	if got.Status != models.StatusPending || got.Attempts != 1 || !strings.Contains(got.LastError, context.Canceled.Error()) {
+		t.Errorf("aborted message is %s after %d attempts (%q), want pending for a retry", got.Status, got.Attempts, got.LastError)
+	}
+
+	// Started again, the scheduler picks up a message scheduled while it runs
+	Start(context.Background(), fake, store, time.Hour)
+	delivered := createDue(t, store, "delivered")
+	ScheduleMessage(delivered)
+	deadline := time.Now().Add(5 * time.Second)
+	for getMessage(t, store, delivered.ID).Status != models.StatusSent {
+		if time.Now().After(deadline) {
+			t.Fatal("the restarted scheduler didn't send the message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	Stop()
+	if err := Wait(context.Background()); err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+
+	var posts []string
+	for _, sent := range fake.Sent(discordtest.ChannelID) {
+		posts = append(posts, sent.Content)
+	}
+	if want := "Hello from drained,Hello from delivered"; strings.Join(posts, ",") != want {
+		t.Errorf("posted %q, want %q", posts, want)
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+)
+
+type attachmentRepo struct {
+	s *sqlStore
+}
+
+func (r attachmentRepo) Create(a *models.Attachment) error {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO message_attachments (scheduled_message_id, draft_id, filename, content_type, data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	id, err := r.s.insert(query, a.ScheduledMessageID, a.DraftID, a.Filename, a.ContentType, a.Data, a.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	a.ID = id
+	return nil
+}
+
+func (r attachmentRepo) ListByMessage(scheduledMessageID int64) ([]*models.Attachment, error) {
+	query := `SELECT id, scheduled_message_id, draft_id, filename, content_type, data, created_at
+        FROM message_attachments
+        WHERE scheduled_message_id = ? AND draft_id = ''
+        ORDER BY id ASC`
+	return queryAll(r.s, scanAttachment, query, scheduledMessageID)
+}
+
+func (r attachmentRepo) MoveToMessage(draftID string, scheduledMessageID int64) error {
+	result, err := r.s.exec(r.s.db, "UPDATE message_attachments SET scheduled_message_id = ?, draft_id = '' WHERE draft_id = ?", scheduledMessageID, draftID)
+	return expectOneRow(result, err, ErrNotFound)
+}
+
+func (r attachmentRepo) DeleteByMessage(scheduledMessageID int64) error {
+	_, err := r.s.exec(r.s.db, "DELETE FROM message_attachments WHERE scheduled_message_id = ? AND draft_id = ''", scheduledMessageID)
+	return err
+}
+
+func scanAttachment(row rowScanner) (*models.Attachment, error) {
+	a := &models.Attachment{}
+	err := row.Scan(&a.ID, &a.ScheduledMessageID, &a.DraftID, &a.Filename, &a.ContentType, &a.Data, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
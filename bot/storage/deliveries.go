@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"github.com/betauia/BetaBot.go/bot/models"
+)
+
+type deliveryRepo struct {
+	s *sqlStore
+}
+
+func (r deliveryRepo) Create(d *models.Delivery) error {
+	query := `
+		INSERT INTO message_deliveries (scheduled_message_id, guild_id, channel_id, title, posted_message_id, status, error, delivered_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	id, err := r.s.insert(query, d.ScheduledMessageID, d.GuildID, d.ChannelID, d.Title, d.PostedMessageID, d.Status, d.Error, d.DeliveredAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	d.ID = id
+	return nil
+}
+
+func (r deliveryRepo) ListRecentByGuild(guildID string, limit int) ([]*models.Delivery, error) {
+	query := `SELECT id, scheduled_message_id, guild_id, channel_id, title, posted_message_id, status, error, delivered_at
+        FROM message_deliveries
+        WHERE guild_id = ?
+        ORDER BY delivered_at DESC, id DESC
+        LIMIT ?`
+	return queryAll(r.s, scanDelivery, query, guildID, limit)
+}
+
+func scanDelivery(row rowScanner) (*models.Delivery, error) {
+	d := &models.Delivery{}
+	err := row.Scan(&d.ID, &d.ScheduledMessageID, &d.GuildID, &d.ChannelID, &d.Title, &d.PostedMessageID, &d.Status, &d.Error, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
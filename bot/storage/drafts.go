@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+)
+
+type draftRepo struct {
+	s *sqlStore
+}
+
+func (r draftRepo) Create(d *models.Draft) error {
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO drafts (id, flow, guild_id, user_id, state, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = r.s.exec(r.s.db, query, id, d.Flow, d.GuildID, d.UserID, d.State, d.ExpiresAt.UTC(), d.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	d.ID = id
+	return nil
+}
+
+func (r draftRepo) Update(d *models.Draft) error {
+	result, err := r.s.exec(r.s.db, "UPDATE drafts SET state = ?, expires_at = ? WHERE id = ?", d.State, d.ExpiresAt.UTC(), d.ID)
+	return expectOneRow(result, err, ErrNotFound)
+}
+
+func (r draftRepo) Get(id string, now time.Time) (*models.Draft, error) {
+	query := `SELECT id, flow, guild_id, user_id, state, expires_at, created_at
+        FROM drafts
+        WHERE id = ? AND expires_at > ?`
+
+	d := &models.Draft{}
+	err := r.s.queryRow(r.s.db, query, id, now.UTC()).Scan(&d.ID, &d.Flow, &d.GuildID, &d.UserID, &d.State, &d.ExpiresAt, &d.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return d, nil
+}
+
+func (r draftRepo) Delete(id string) error {
+	return r.s.inTx(func(tx *sql.Tx) error {
+		if _, err := r.s.exec(tx, "DELETE FROM message_attachments WHERE draft_id = ?", id); err != nil {
+			return err
+		}
+		_, err := r.s.exec(tx, "DELETE FROM drafts WHERE id = ?", id)
+		return err
+	})
+}
+
+func (r draftRepo) DeleteExpired(now time.Time) (int64, error) {
+	var deleted int64
+	err := r.s.inTx(func(tx *sql.Tx) error {
+		query := "DELETE FROM message_attachments WHERE draft_id IN (SELECT id FROM drafts WHERE expires_at <= ?)"
+		if _, err := r.s.exec(tx, query, now.UTC()); err != nil {
+			return err
+		}
+		result, err := r.s.exec(tx, "DELETE FROM drafts WHERE expires_at <= ?", now.UTC())
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
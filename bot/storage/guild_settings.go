@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+)
+
+type guildSettingsRepo struct {
+	s *sqlStore
+}
+
+func (r guildSettingsRepo) Get(guildID string) (*models.GuildSettings, error) {
+	query := `SELECT guild_id, timezone, debug_logging FROM guild_settings WHERE guild_id = ?`
+
+	gs := &models.GuildSettings{}
+	err := r.s.queryRow(r.s.db, query, guildID).Scan(&gs.GuildID, &gs.Timezone, &gs.DebugLogging)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.GuildSettings{GuildID: guildID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return gs, nil
+}
+
+func (r guildSettingsRepo) Save(gs *models.GuildSettings) error {
+	query := `
+		INSERT INTO guild_settings (guild_id, timezone, debug_logging)
+		VALUES (?, ?, ?)
+		ON CONFLICT (guild_id) DO UPDATE SET timezone = excluded.timezone, debug_logging = excluded.debug_logging
+	`
+	_, err := r.s.exec(r.s.db, query, gs.GuildID, gs.Timezone, gs.DebugLogging)
+	return err
+}
+
+func (r guildSettingsRepo) ListDebugLogging() ([]string, error) {
+	scan := func(row rowScanner) (string, error) {
+		var guildID string
+		err := row.Scan(&guildID)
+		return guildID, err
+	}
+	return queryAll(r.s, scan, "SELECT guild_id FROM guild_settings WHERE debug_logging ORDER BY guild_id")
+}
@@ -0,0 +1,302 @@
+// Package migrations keeps the database schema up to date. Every schema change is an SQL file
+// in sql/<dialect>/, named after the version it migrates to, e.g. sql/sqlite/0002_recurrence.sql.
+// Files are applied in order, each in its own transaction, and the applied versions are recorded
+// in schema_version.
+//
+// Each dialect has its own history: Postgres support was added once the SQLite schema had
+// settled, so its first migration creates that schema in one go. A schema change needs a
+// migration for every dialect.
+//
+// Never edit a migration that has been released; add a new one instead.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/sqlite/*.sql sql/postgres/*.sql
+var files embed.FS
+
+// Dialect is the SQL flavour of a database
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// Rebind rewrites the ? placeholders of a query into the placeholders used by the dialect
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// lockID is the Postgres advisory lock held while migrating, so instances starting at the same
+// time take turns. SQLite gets the same effect from immediate transactions.
+const lockID = 0x62657461626f74 // "betabot"
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer version of the bot.
+// Running against it could corrupt data the newer version relies on.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of the bot supports")
+
+// Migration is a single schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// legacyMarkers identify the schema of SQLite databases created before schema_version existed,
+// when tables and columns were added on startup. Each is the table or column a migration adds.
+var legacyMarkers = []struct {
+	version       int
+	table, column string
+}{
+	{1, "scheduled_messages", ""},
+	{2, "scheduled_messages", "recurrence"},
+	{3, "guild_settings", ""},
+	{4, "message_deliveries", ""},
+	{5, "scheduled_messages", "lease_token"},
+	{6, "scheduled_messages", "next_attempt_at"},
+	{7, "scheduled_messages", "mention_user_ids"},
+	{8, "scheduled_messages", "embed"},
+	{9, "message_attachments", ""},
+	{10, "message_templates", ""},
+}
+
+// All returns the embedded migrations of a dialect ordered by version
+func All(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("sql", string(dialect))
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for database dialect %q", dialect)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		prefix, name, found := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s must be named like 0001_description.sql", entry.Name())
+		}
+
+		content, err := files.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be consecutive, expected %d but found %d", i+1, migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest returns the schema version the embedded migrations of a dialect migrate to
+func Latest(dialect Dialect) (int, error) {
+	migrations, err := All(dialect)
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+// Version returns the schema version of the database, 0 for an empty database
+func Version(db *sql.DB, dialect Dialect) (int, error) {
+	if err := createVersionTable(db, dialect); err != nil {
+		return 0, err
+	}
+	return currentVersion(db)
+}
+
+// Migrate applies all migrations the database hasn't seen yet. It returns ErrSchemaTooNew,
+// without changing anything, when the database is ahead of the embedded migrations.
+func Migrate(db *sql.DB, dialect Dialect) error {
+	migrations, err := All(dialect)
+	if err != nil {
+		return err
+	}
+
+	if err := createVersionTable(db, dialect); err != nil {
+		return err
+	}
+	if dialect == SQLite {
+		if err := adoptLegacySchema(db); err != nil {
+			return err
+		}
+	}
+
+	current, err := currentVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w: the database is at version %d, this build only knows up to version %d", ErrSchemaTooNew, current, len(migrations))
+	}
+
+	for _, migration := range migrations[current:] {
+		if err := apply(db, dialect, migration); err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// apply runs a migration and records it in one transaction, so a failed migration leaves no trace.
+// Another instance may have applied it in the meantime, in which case nothing is done.
+func apply(db *sql.DB, dialect Dialect, migration Migration) error {
+	tx, err := begin(db, dialect)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var applied bool
+	err = tx.QueryRow(dialect.Rebind("SELECT COUNT(*) > 0 FROM schema_version WHERE version = ?"), migration.Version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		return err
+	}
+	_, err = tx.Exec(dialect.Rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+		migration.Version, migration.Name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("Applied migration", slog.Int("version", migration.Version), slog.String("name", migration.Name))
+	return nil
+}
+
+// begin starts a migration transaction, waiting for other instances that are migrating
+func begin(db *sql.DB, dialect Dialect) (*sql.Tx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if dialect == Postgres {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", lockID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+func createVersionTable(db *sql.DB, dialect Dialect) error {
+	timestamp := "DATETIME"
+	if dialect == Postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+
+	tx, err := begin(db, dialect)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + timestamp + ` NOT NULL
+	);
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func currentVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// adoptLegacySchema records the migrations already reflected in a database that was set up
+// before schema_version existed, so they aren't applied twice
+func adoptLegacySchema(db *sql.DB) error {
+	current, err := currentVersion(db)
+	if err != nil || current > 0 {
+		return err
+	}
+
+	version := 0
+	for _, marker := range legacyMarkers {
+		exists, err := schemaObjectExists(db, marker.table, marker.column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			break
+		}
+		version = marker.version
+	}
+	if version == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for v := 1; v <= version; v++ {
+		_, err := tx.Exec("INSERT OR IGNORE INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+			v, "adopted", time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("Adopted existing database schema", slog.Int("version", version))
+	return nil
+}
+
+// schemaObjectExists reports whether a SQLite table, or a column of it when column isn't empty, exists
+func schemaObjectExists(db *sql.DB, table, column string) (bool, error) {
+	var exists bool
+	var err error
+	if column == "" {
+		err = db.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&exists)
+	} else {
+		err = db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&exists)
+	}
+	return exists, err
+}
@@ -0,0 +1,138 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// baselineSchema is the table the bot created on startup before there were migrations
+const baselineSchema = `
+	CREATE TABLE IF NOT EXISTS scheduled_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL UNIQUE,
+		guild_id TEXT NOT NULL,
+		role_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		message TEXT NOT NULL,
+		scheduled_time DATETIME NOT NULL,
+		channel_id TEXT NOT NULL
+	);
+`
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func latest(t *testing.T) int {
+	t.Helper()
+	version, err := Latest(SQLite)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	return version
+}
+
+func expectVersion(t *testing.T, db *sql.DB, want int) {
+	t.Helper()
+	version, err := Version(db, SQLite)
+	if err != nil {
+		t.Fatalf("Version: %v", err)
+	}
+	if version != want {
+		t.Errorf("schema version %d, want %d", version, want)
+	}
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+	db := openSQLite(t)
+	expectVersion(t, db, 0)
+
+	if err := Migrate(db, SQLite); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	expectVersion(t, db, latest(t))
+}
+
+func TestMigrateBaselineSchema(t *testing.T) {
+	db := openSQLite(t)
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	scheduled := time.Date(2025, time.March, 1, 18, 0, 0, 0, time.UTC)
+	_, err := db.Exec(`INSERT INTO scheduled_messages (title, guild_id, role_id, user_id, message, scheduled_time, channel_id)
+		VALUES ('Board meeting', 'guild-1', '', 'user-1', 'See you there', ?, 'channel-1')`, scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, SQLite); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	expectVersion(t, db, latest(t))
+
+	// Only the first migration matches the baseline, the rest are applied on top of it
+	var adopted int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_version WHERE name = 'adopted'").Scan(&adopted); err != nil {
+		t.Fatal(err)
+	}
+	if adopted != legacyMarkers[0].version {
+		t.Errorf("adopted %d versions, want %d", adopted, legacyMarkers[0].version)
+	}
+
+	var (
+		title, message, status string
+		scheduledTime          time.Time
+	)
+	err = db.QueryRow("SELECT title, message, status, scheduled_time FROM scheduled_messages").Scan(&title, &message, &status, &scheduledTime)
+	if err != nil {
+		t.Fatalf("reading the existing message: %v", err)
+	}
+	if title != "Board meeting" || message != "See you there" || status != "pending" || !scheduledTime.Equal(scheduled) {
+		t.Errorf("existing message changed: %q, %q, %s, %v", title, message, status, scheduledTime)
+	}
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	db := openSQLite(t)
+	if err := Migrate(db, SQLite); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	newer := latest(t) + 1
+	if _, err := db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'from_the_future', ?)", newer, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, SQLite); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Migrate returned %v, want ErrSchemaTooNew", err)
+	}
+	expectVersion(t, db, newer)
+}
+
+func TestMigrateTwice(t *testing.T) {
+	db := openSQLite(t)
+	for i := range 2 {
+		if err := Migrate(db, SQLite); err != nil {
+			t.Fatalf("Migrate #%d: %v", i+1, err)
+		}
+	}
+
+	var applied int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != latest(t) {
+		t.Errorf("%d migrations recorded, want %d", applied, latest(t))
+	}
+	expectVersion(t, db, latest(t))
+}
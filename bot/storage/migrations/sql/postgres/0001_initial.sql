@@ -0,0 +1,69 @@
+-- The schema as of SQLite migration 0010_templates. See sql/sqlite for the history of each table.
+CREATE TABLE scheduled_messages (
+	id BIGSERIAL PRIMARY KEY,
+	title TEXT NOT NULL,
+	guild_id TEXT NOT NULL,
+	role_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	message TEXT NOT NULL,
+	scheduled_time TIMESTAMPTZ NOT NULL,
+	channel_id TEXT NOT NULL,
+	recurrence TEXT NOT NULL DEFAULT '',
+	recurrence_end TIMESTAMPTZ,
+	max_occurrences INTEGER NOT NULL DEFAULT 0,
+	occurrences INTEGER NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'pending',
+	posted_message_id TEXT NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	sent_at TIMESTAMPTZ,
+	lease_token TEXT NOT NULL DEFAULT '',
+	lease_expires_at TIMESTAMPTZ,
+	next_attempt_at TIMESTAMPTZ,
+	mention_user_ids TEXT NOT NULL DEFAULT '',
+	mass_mention TEXT NOT NULL DEFAULT '',
+	embed TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX idx_scheduled_messages_active_title
+	ON scheduled_messages (guild_id, title) WHERE status IN ('pending', 'sending');
+CREATE INDEX idx_scheduled_messages_due ON scheduled_messages (status, scheduled_time);
+
+CREATE TABLE guild_settings (
+	guild_id TEXT PRIMARY KEY,
+	timezone TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE message_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	scheduled_message_id BIGINT NOT NULL,
+	guild_id TEXT NOT NULL,
+	channel_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	posted_message_id TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	delivered_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_message_deliveries_guild ON message_deliveries (guild_id, delivered_at);
+
+CREATE TABLE message_attachments (
+	id BIGSERIAL PRIMARY KEY,
+	scheduled_message_id BIGINT NOT NULL,
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL DEFAULT '',
+	data BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_message_attachments_message ON message_attachments (scheduled_message_id);
+
+-- Template names are unique per guild ignoring case, like SQLite's COLLATE NOCASE
+CREATE TABLE message_templates (
+	id BIGSERIAL PRIMARY KEY,
+	guild_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	content TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE UNIQUE INDEX idx_message_templates_name ON message_templates (guild_id, lower(name));
@@ -0,0 +1,11 @@
+-- State of multi-step commands between their steps, see SQLite migration 0011_drafts
+CREATE TABLE drafts (
+	id TEXT PRIMARY KEY,
+	flow TEXT NOT NULL,
+	guild_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	state BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_drafts_expires ON drafts (expires_at);
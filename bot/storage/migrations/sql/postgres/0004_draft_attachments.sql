@@ -0,0 +1,3 @@
+-- Files uploaded with /schedule add belong to their draft until it is confirmed, see SQLite migration 0013_draft_attachments
+ALTER TABLE message_attachments ADD COLUMN draft_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_message_attachments_draft ON message_attachments (draft_id);
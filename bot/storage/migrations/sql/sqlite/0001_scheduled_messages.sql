@@ -0,0 +1,11 @@
+-- The original scheduled_messages table
+CREATE TABLE scheduled_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL UNIQUE,
+	guild_id TEXT NOT NULL,
+	role_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	message TEXT NOT NULL,
+	scheduled_time DATETIME NOT NULL,
+	channel_id TEXT NOT NULL
+);
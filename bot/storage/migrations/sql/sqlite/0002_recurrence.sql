@@ -0,0 +1,5 @@
+-- Recurring scheduled messages
+ALTER TABLE scheduled_messages ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';
+ALTER TABLE scheduled_messages ADD COLUMN recurrence_end DATETIME;
+ALTER TABLE scheduled_messages ADD COLUMN max_occurrences INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scheduled_messages ADD COLUMN occurrences INTEGER NOT NULL DEFAULT 0;
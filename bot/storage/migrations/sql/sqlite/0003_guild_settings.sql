@@ -0,0 +1,5 @@
+-- Per-guild settings, such as the timezone scheduled times are entered in
+CREATE TABLE guild_settings (
+	guild_id TEXT PRIMARY KEY,
+	timezone TEXT NOT NULL DEFAULT ''
+);
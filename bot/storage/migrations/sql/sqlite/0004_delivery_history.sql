@@ -0,0 +1,47 @@
+-- Delivery status and history. Messages are kept after they have been sent, so titles
+-- only have to be unique per guild among messages that are still waiting to be sent.
+-- SQLite can't drop the old UNIQUE constraint in place, so the table is rebuilt.
+CREATE TABLE scheduled_messages_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	guild_id TEXT NOT NULL,
+	role_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	message TEXT NOT NULL,
+	scheduled_time DATETIME NOT NULL,
+	channel_id TEXT NOT NULL,
+	recurrence TEXT NOT NULL DEFAULT '',
+	recurrence_end DATETIME,
+	max_occurrences INTEGER NOT NULL DEFAULT 0,
+	occurrences INTEGER NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'pending',
+	posted_message_id TEXT NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	sent_at DATETIME
+);
+
+INSERT INTO scheduled_messages_new (id, title, guild_id, role_id, user_id, message, scheduled_time, channel_id,
+	recurrence, recurrence_end, max_occurrences, occurrences)
+SELECT id, title, guild_id, role_id, user_id, message, scheduled_time, channel_id,
+	recurrence, recurrence_end, max_occurrences, occurrences
+FROM scheduled_messages;
+
+DROP TABLE scheduled_messages;
+ALTER TABLE scheduled_messages_new RENAME TO scheduled_messages;
+
+CREATE UNIQUE INDEX idx_scheduled_messages_active_title
+	ON scheduled_messages (guild_id, title) WHERE status IN ('pending', 'sending');
+
+CREATE TABLE message_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	scheduled_message_id INTEGER NOT NULL,
+	guild_id TEXT NOT NULL,
+	channel_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	posted_message_id TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	delivered_at DATETIME NOT NULL
+);
+CREATE INDEX idx_message_deliveries_guild ON message_deliveries (guild_id, delivered_at);
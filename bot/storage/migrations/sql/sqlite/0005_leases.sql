@@ -0,0 +1,6 @@
+-- Leases, so that a due message is only claimed by one worker at a time
+ALTER TABLE scheduled_messages ADD COLUMN lease_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE scheduled_messages ADD COLUMN lease_expires_at DATETIME;
+
+-- Due messages are looked up on every scheduler tick
+CREATE INDEX idx_scheduled_messages_due ON scheduled_messages (status, scheduled_time);
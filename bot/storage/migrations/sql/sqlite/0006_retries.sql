@@ -0,0 +1,2 @@
+-- Backoff between retries of failed posts
+ALTER TABLE scheduled_messages ADD COLUMN next_attempt_at DATETIME;
@@ -0,0 +1,3 @@
+-- Members and @everyone/@here pinged when a message is posted. Pinged roles are stored in role_id.
+ALTER TABLE scheduled_messages ADD COLUMN mention_user_ids TEXT NOT NULL DEFAULT '';
+ALTER TABLE scheduled_messages ADD COLUMN mass_mention TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Embeds posted with a message, stored as JSON
+ALTER TABLE scheduled_messages ADD COLUMN embed TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+-- Files posted with a message, kept until its last delivery
+CREATE TABLE message_attachments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	scheduled_message_id INTEGER NOT NULL,
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL DEFAULT '',
+	data BLOB NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX idx_message_attachments_message ON message_attachments (scheduled_message_id);
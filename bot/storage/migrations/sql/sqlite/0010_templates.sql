@@ -0,0 +1,10 @@
+-- Reusable message templates per guild
+CREATE TABLE message_templates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id TEXT NOT NULL,
+	name TEXT NOT NULL COLLATE NOCASE,
+	content TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	UNIQUE (guild_id, name)
+);
@@ -0,0 +1,11 @@
+-- State of multi-step commands between their steps, so several can run at once and survive restarts
+CREATE TABLE drafts (
+	id TEXT PRIMARY KEY,
+	flow TEXT NOT NULL,
+	guild_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	state BLOB NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX idx_drafts_expires ON drafts (expires_at);
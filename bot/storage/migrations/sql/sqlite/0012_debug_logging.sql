@@ -0,0 +1,2 @@
+-- Guilds can turn on debug logs for themselves with /logging
+ALTER TABLE guild_settings ADD COLUMN debug_logging BOOLEAN NOT NULL DEFAULT FALSE;
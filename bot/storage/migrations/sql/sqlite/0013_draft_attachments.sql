@@ -0,0 +1,4 @@
+-- Files uploaded with /schedule add are stored once and belong to the draft until it is
+-- confirmed, instead of being copied into the draft's state on every step
+ALTER TABLE message_attachments ADD COLUMN draft_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_message_attachments_draft ON message_attachments (draft_id);
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage/migrations"
+)
+
+type scheduledMessageRepo struct {
+	s *sqlStore
+}
+
+// scheduledMessageColumns lists the columns read by scanScheduledMessage, in order
+const scheduledMessageColumns = `id, title, guild_id, role_id, user_id, message, scheduled_time, channel_id,
+	embed, mention_user_ids, mass_mention, recurrence, recurrence_end, max_occurrences, occurrences,
+	status, posted_message_id, attempts, last_error, sent_at, next_attempt_at, lease_token, lease_expires_at`
+
+func scanScheduledMessage(row rowScanner) (*models.ScheduledMessage, error) {
+	sm := &models.ScheduledMessage{}
+	var recurrenceEnd, sentAt, nextAttemptAt, leaseExpiresAt sql.NullTime
+	err := row.Scan(&sm.ID, &sm.Title, &sm.GuildID, &sm.RoleID, &sm.UserID, &sm.Message, &sm.ScheduledTime, &sm.ChannelID,
+		&sm.Embed, &sm.MentionUserIDs, &sm.MassMention, &sm.Recurrence, &recurrenceEnd, &sm.MaxOccurrences, &sm.Occurrences,
+		&sm.Status, &sm.PostedMessageID, &sm.Attempts, &sm.LastError, &sentAt, &nextAttemptAt, &sm.LeaseToken, &leaseExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	sm.RecurrenceEnd = recurrenceEnd.Time
+	sm.SentAt = sentAt.Time
+	sm.NextAttemptAt = nextAttemptAt.Time
+	sm.LeaseExpiresAt = leaseExpiresAt.Time
+	return sm, nil
+}
+
+func (r scheduledMessageRepo) Create(sm *models.ScheduledMessage) error {
+	if sm.Status == "" {
+		sm.Status = models.StatusPending
+	}
+
+	query := `
+		INSERT INTO scheduled_messages (title, guild_id, role_id, user_id, message, scheduled_time, channel_id,
+			embed, mention_user_ids, mass_mention, recurrence, recurrence_end, max_occurrences, occurrences,
+			status, posted_message_id, attempts, last_error, sent_at, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	id, err := r.s.insert(query, sm.Title, sm.GuildID, sm.RoleID, sm.UserID, sm.Message, sm.ScheduledTime.UTC(), sm.ChannelID,
+		sm.Embed, sm.MentionUserIDs, sm.MassMention, sm.Recurrence, nullTime(sm.RecurrenceEnd), sm.MaxOccurrences, sm.Occurrences,
+		sm.Status, sm.PostedMessageID, sm.Attempts, sm.LastError, nullTime(sm.SentAt), nullTime(sm.NextAttemptAt))
+	if err != nil {
+		return err
+	}
+
+	sm.ID = id
+	return nil
+}
+
+func (r scheduledMessageRepo) Update(sm *models.ScheduledMessage) error {
+	query := `
+        UPDATE scheduled_messages
+        SET title = ?, guild_id = ?, role_id = ?, user_id = ?, message = ?, scheduled_time = ?, channel_id = ?,
+            embed = ?, mention_user_ids = ?, mass_mention = ?, recurrence = ?, recurrence_end = ?, max_occurrences = ?, occurrences = ?,
+            status = ?, posted_message_id = ?, attempts = ?, last_error = ?, sent_at = ?, next_attempt_at = ?
+        WHERE id = ? AND status IN (?, ?)
+    `
+	result, err := r.s.exec(r.s.db, query, sm.Title, sm.GuildID, sm.RoleID, sm.UserID, sm.Message, sm.ScheduledTime.UTC(), sm.ChannelID,
+		sm.Embed, sm.MentionUserIDs, sm.MassMention, sm.Recurrence, nullTime(sm.RecurrenceEnd), sm.MaxOccurrences, sm.Occurrences,
+		sm.Status, sm.PostedMessageID, sm.Attempts, sm.LastError, nullTime(sm.SentAt), nullTime(sm.NextAttemptAt), sm.ID,
+		models.StatusPending, models.StatusFailed)
+	return expectOneRow(result, err, models.ErrNotActive)
+}
+
+func (r scheduledMessageRepo) Cancel(sm *models.ScheduledMessage) error {
+	err := r.s.inTx(func(tx *sql.Tx) error {
+		query := `UPDATE scheduled_messages SET status = ? WHERE id = ? AND status IN (?, ?)`
+		result, err := r.s.exec(tx, query, models.StatusCancelled, sm.ID, models.StatusPending, models.StatusFailed)
+		if err := expectOneRow(result, err, models.ErrNotActive); err != nil {
+			return err
+		}
+		_, err = r.s.exec(tx, `DELETE FROM message_attachments WHERE scheduled_message_id = ?`, sm.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	sm.Status = models.StatusCancelled
+	return nil
+}
+
+func (r scheduledMessageRepo) Delete(sm *models.ScheduledMessage) error {
+	return r.s.inTx(func(tx *sql.Tx) error {
+		if _, err := r.s.exec(tx, `DELETE FROM scheduled_messages WHERE id = ?`, sm.ID); err != nil {
+			return err
+		}
+		_, err := r.s.exec(tx, `DELETE FROM message_attachments WHERE scheduled_message_id = ?`, sm.ID)
+		return err
+	})
+}
+
+func (r scheduledMessageRepo) GetByID(id int64) (*models.ScheduledMessage, error) {
+	query := `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages WHERE id = ?`
+	sm, err := scanScheduledMessage(r.s.queryRow(r.s.db, query, id))
+	return sm, notFound(err)
+}
+
+func (r scheduledMessageRepo) ListByGuild(guildID string) ([]*models.ScheduledMessage, error) {
+	query := `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages WHERE guild_id = ? ORDER BY scheduled_time ASC, id ASC`
+	return queryAll(r.s, scanScheduledMessage, query, guildID)
+}
+
+func (r scheduledMessageRepo) ListUpcomingByGuild(guildID string, now time.Time) ([]*models.ScheduledMessage, error) {
+	query := `SELECT ` + scheduledMessageColumns + `
+        FROM scheduled_messages
+        WHERE guild_id = ? AND status = ? AND scheduled_time > ?
+        ORDER BY scheduled_time ASC, id ASC`
+	return queryAll(r.s, scanScheduledMessage, query, guildID, models.StatusPending, now.UTC())
+}
+
+func (r scheduledMessageRepo) ListActive() ([]*models.ScheduledMessage, error) {
+	query := `SELECT ` + scheduledMessageColumns + `
+        FROM scheduled_messages
+        WHERE status IN (?, ?)
+        ORDER BY scheduled_time ASC, id ASC`
+	return queryAll(r.s, scanScheduledMessage, query, models.StatusPending, models.StatusSending)
+}
+
+func (r scheduledMessageRepo) ClaimDue(now time.Time, lease time.Duration, limit int) ([]*models.ScheduledMessage, error) {
+	token, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	// A single UPDATE statement is atomic in SQLite, so concurrent workers can never claim the
+	// same row. Postgres re-checks only the outer WHERE clause when a row was changed under it,
+	// so the candidate rows are locked, and rows locked by another worker skipped, instead.
+	lockRows := ""
+	if r.s.dialect == migrations.Postgres {
+		lockRows = "FOR UPDATE SKIP LOCKED"
+	}
+	query := `
+		UPDATE scheduled_messages
+		SET status = ?, lease_token = ?, lease_expires_at = ?, attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM scheduled_messages
+			WHERE (status = ? AND scheduled_time <= ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?))
+				OR (status = ? AND lease_expires_at <= ?)
+			ORDER BY scheduled_time ASC
+			LIMIT ?
+			` + lockRows + `
+		)
+	`
+	now = now.UTC()
+	_, err = r.s.exec(r.s.db, query, models.StatusSending, token, now.Add(lease),
+		models.StatusPending, now, now, models.StatusSending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages WHERE lease_token = ? AND status = ? ORDER BY scheduled_time ASC`
+	return queryAll(r.s, scanScheduledMessage, query, token, models.StatusSending)
+}
+
+func (r scheduledMessageRepo) ReleaseLease(sm *models.ScheduledMessage) error {
+	query := `
+        UPDATE scheduled_messages
+        SET scheduled_time = ?, occurrences = ?, status = ?, posted_message_id = ?, attempts = ?, last_error = ?, sent_at = ?,
+            next_attempt_at = ?, lease_token = '', lease_expires_at = NULL
+        WHERE id = ? AND lease_token = ?
+    `
+	result, err := r.s.exec(r.s.db, query, sm.ScheduledTime.UTC(), sm.Occurrences, sm.Status, sm.PostedMessageID, sm.Attempts,
+		sm.LastError, nullTime(sm.SentAt), nullTime(sm.NextAttemptAt), sm.ID, sm.LeaseToken)
+	if err := expectOneRow(result, err, models.ErrLeaseLost); err != nil {
+		return err
+	}
+
+	sm.LeaseToken = ""
+	sm.LeaseExpiresAt = time.Time{}
+	return nil
+}
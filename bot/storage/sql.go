@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/storage/migrations"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlStore implements Store on top of database/sql. SQLite and Postgres share the queries,
+// which are written with ? placeholders and rebound for the dialect.
+type sqlStore struct {
+	db      *sql.DB
+	dialect migrations.Dialect
+}
+
+func openSQLite(path string) (Store, error) {
+	// Immediate transactions take the write lock up front, so two instances starting
+	// at the same time can't both apply the same migration
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("sqlite3", path+separator+"_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	return newSQLStore(db, migrations.SQLite)
+}
+
+func openPostgres(databaseURL string) (Store, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLStore(db, migrations.Postgres)
+}
+
+func newSQLStore(db *sql.DB, dialect migrations.Dialect) (Store, error) {
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to %s database: %w", dialect, err)
+	}
+
+	// Bring the schema up to date, see storage/migrations
+	if err := migrations.Migrate(db, dialect); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s database: %w", dialect, err)
+	}
+
+	return &sqlStore{db: db, dialect: dialect}, nil
+}
+
+func (s *sqlStore) ScheduledMessages() ScheduledMessageRepository { return scheduledMessageRepo{s} }
+func (s *sqlStore) Deliveries() DeliveryRepository                { return deliveryRepo{s} }
+func (s *sqlStore) GuildSettings() GuildSettingsRepository        { return guildSettingsRepo{s} }
+func (s *sqlStore) Attachments() AttachmentRepository             { return attachmentRepo{s} }
+func (s *sqlStore) Templates() TemplateRepository                 { return templateRepo{s} }
+func (s *sqlStore) Drafts() DraftRepository                       { return draftRepo{s} }
+
+func (s *sqlStore) Backend() string {
+	return string(s.dialect)
+}
+
+func (s *sqlStore) SchemaVersion() (int, error) {
+	return migrations.Version(s.db, s.dialect)
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// queryable is implemented by both *sql.DB and *sql.Tx
+type queryable interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *sqlStore) exec(q queryable, query string, args ...any) (sql.Result, error) {
+	return q.Exec(s.dialect.Rebind(query), args...)
+}
+
+func (s *sqlStore) query(q queryable, query string, args ...any) (*sql.Rows, error) {
+	return q.Query(s.dialect.Rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(q queryable, query string, args ...any) *sql.Row {
+	return q.QueryRow(s.dialect.Rebind(query), args...)
+}
+
+// insert runs an INSERT statement and returns the ID of the new row
+func (s *sqlStore) insert(query string, args ...any) (int64, error) {
+	var id int64
+	err := s.queryRow(s.db, query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds
+func (s *sqlStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// queryAll runs a query and scans every row with scan
+func queryAll[T any](s *sqlStore, scan func(rowScanner) (T, error), query string, args ...any) ([]T, error) {
+	rows, err := s.query(s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []T
+	for rows.Next() {
+		result, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// expectOneRow turns an update that matched no rows into errNoMatch
+func expectOneRow(result sql.Result, err error, errNoMatch error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errNoMatch
+	}
+	return nil
+}
+
+// nullTime stores the zero time as NULL. Times are stored in UTC so that
+// SQLite's text comparison of timestamps orders them correctly.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// randomID returns a random, unguessable hex string, used for lease tokens and draft IDs
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,152 @@
+// Package storage persists the bot's data. The rest of the bot only talks to the repository
+// interfaces in this file, so the database behind them can be swapped: SQLite for a single
+// instance, or Postgres when several instances share one database. Open picks the backend
+// from the DATABASE_URL setting.
+//
+// Every implementation must pass the conformance suite in storage/storagetest.
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/dateparse"
+	"github.com/betauia/BetaBot.go/bot/models"
+)
+
+// DefaultDatabaseURL is used when DATABASE_URL isn't set
+const DefaultDatabaseURL = "sqlite:./db/betabot.db"
+
+// ErrNotFound is returned when looking up something that doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// Store gives access to the repositories of one database
+type Store interface {
+	ScheduledMessages() ScheduledMessageRepository
+	Deliveries() DeliveryRepository
+	GuildSettings() GuildSettingsRepository
+	Attachments() AttachmentRepository
+	Templates() TemplateRepository
+	Drafts() DraftRepository
+
+	// Backend returns the name of the database behind the store, e.g. "sqlite"
+	Backend() string
+	// SchemaVersion returns the version the database schema was migrated to
+	SchemaVersion() (int, error)
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// ScheduledMessageRepository stores scheduled messages and their delivery state
+type ScheduledMessageRepository interface {
+	// Create inserts a new message and sets its ID. Its status defaults to pending.
+	Create(msg *models.ScheduledMessage) error
+	// Update saves the changes to a message. Only pending or failed messages can be updated,
+	// so edits never race with a delivery in progress; models.ErrNotActive is returned otherwise.
+	Update(msg *models.ScheduledMessage) error
+	// Cancel marks the message as cancelled so it is never sent but stays in the history, and
+	// deletes its attachments. models.ErrNotActive is returned if it is being sent or was already sent.
+	Cancel(msg *models.ScheduledMessage) error
+	// Delete removes a message and its attachments
+	Delete(msg *models.ScheduledMessage) error
+
+	GetByID(id int64) (*models.ScheduledMessage, error)
+	// ListByGuild returns all messages of a guild, including sent and cancelled ones
+	ListByGuild(guildID string) ([]*models.ScheduledMessage, error)
+	// ListUpcomingByGuild returns the pending messages of a guild scheduled after now
+	ListUpcomingByGuild(guildID string, now time.Time) ([]*models.ScheduledMessage, error)
+	// ListActive returns the messages of all guilds that are waiting to be sent or are being sent
+	ListActive() ([]*models.ScheduledMessage, error)
+
+	// ClaimDue atomically claims up to limit messages that are due at now, marking them as
+	// sending under a fresh lease token. Messages whose lease has expired, because the worker
+	// sending them crashed or hung, are claimed again. A message is only ever leased to one
+	// worker at a time, even when several bot instances share the database.
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]*models.ScheduledMessage, error)
+	// ReleaseLease persists the outcome of a delivery and releases the lease held by this worker.
+	// It returns models.ErrLeaseLost if the lease expired and another worker has claimed the message since.
+	ReleaseLease(msg *models.ScheduledMessage) error
+}
+
+// DeliveryRepository stores the delivery history
+type DeliveryRepository interface {
+	Create(delivery *models.Delivery) error
+	// ListRecentByGuild returns the most recent deliveries in a guild, newest first
+	ListRecentByGuild(guildID string, limit int) ([]*models.Delivery, error)
+}
+
+// GuildSettingsRepository stores per-guild configuration
+type GuildSettingsRepository interface {
+	// Get returns the settings of a guild, or the defaults if none are stored
+	Get(guildID string) (*models.GuildSettings, error)
+	// Save inserts or updates the settings of a guild
+	Save(settings *models.GuildSettings) error
+	// ListDebugLogging returns the IDs of the guilds that turned on debug logs
+	ListDebugLogging() ([]string, error)
+}
+
+// AttachmentRepository stores the files posted with scheduled messages
+type AttachmentRepository interface {
+	// Create stores a file for a scheduled message, or for a draft when DraftID is set
+	Create(attachment *models.Attachment) error
+	// ListByMessage returns the files of a scheduled message, in the order they were added
+	ListByMessage(scheduledMessageID int64) ([]*models.Attachment, error)
+	// MoveToMessage hands the files of a draft over to the message it was saved as,
+	// returning ErrNotFound if the draft has none
+	MoveToMessage(draftID string, scheduledMessageID int64) error
+	// DeleteByMessage removes the files of a scheduled message once they are no longer needed
+	DeleteByMessage(scheduledMessageID int64) error
+}
+
+// TemplateRepository stores message templates
+type TemplateRepository interface {
+	// Create inserts a new template. Names are unique per guild, ignoring case.
+	Create(template *models.Template) error
+	Delete(template *models.Template) error
+	// GetByName returns a template in a guild by its name, ignoring case
+	GetByName(guildID, name string) (*models.Template, error)
+	// ListByGuild returns all templates in a guild, ordered by name
+	ListByGuild(guildID string) ([]*models.Template, error)
+}
+
+// DraftRepository stores the state of multi-step commands between their steps
+type DraftRepository interface {
+	// Create inserts a new draft and sets its ID to a random, unguessable string
+	Create(draft *models.Draft) error
+	// Update saves the state and expiry of a draft, returning ErrNotFound if it was deleted
+	Update(draft *models.Draft) error
+	// Get returns a draft by its ID, or ErrNotFound if it doesn't exist or expired before now
+	Get(id string, now time.Time) (*models.Draft, error)
+	// Delete removes a draft and the files uploaded for it
+	Delete(id string) error
+	// DeleteExpired removes the drafts that expired before now, and their files, and returns how many drafts there were
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// Open connects to the database at databaseURL and brings its schema up to date.
+// postgres:// and postgresql:// URLs select Postgres. Anything else is the path of a SQLite
+// database, optionally prefixed with sqlite:, and an empty URL means DefaultDatabaseURL.
+func Open(databaseURL string) (Store, error) {
+	if databaseURL == "" {
+		databaseURL = DefaultDatabaseURL
+	}
+
+	if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+		return openPostgres(databaseURL)
+	}
+	path := strings.TrimPrefix(databaseURL, "sqlite:")
+	path = strings.TrimPrefix(path, "//")
+	return openSQLite(path)
+}
+
+// GuildLocation returns the timezone of a guild, falling back to the default timezone on errors
+func GuildLocation(store Store, guildID string) *time.Location {
+	settings, err := store.GuildSettings().Get(guildID)
+	if err != nil {
+		return dateparse.DefaultLocation
+	}
+	return settings.Location()
+}
@@ -0,0 +1,19 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/betauia/BetaBot.go/bot/storage/storagetest"
+)
+
+func TestSQLite(t *testing.T) {
+	storagetest.Run(t, storagetest.OpenSQLite)
+}
+
+func TestPostgres(t *testing.T) {
+	if os.Getenv("TEST_DATABASE_URL") == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	storagetest.Run(t, storagetest.OpenPostgres)
+}
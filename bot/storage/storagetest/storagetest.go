@@ -0,0 +1,692 @@
+// Package storagetest is the conformance suite for storage.Store implementations. Every backend
+// runs the same checks, so the bot behaves the same whichever database it is configured with.
+// Call it from a test of the backend:
+//
+//	func TestSQLite(t *testing.T) { storagetest.Run(t, storagetest.OpenSQLite) }
+//	func TestPostgres(t *testing.T) { storagetest.Run(t, storagetest.OpenPostgres) }
+package storagetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+	"github.com/betauia/BetaBot.go/bot/storage"
+)
+
+// Run runs the conformance suite. open must return a store with an empty, migrated database
+// that is closed when the test ends.
+func Run(t *testing.T, open func(t *testing.T) storage.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store storage.Store)
+	}{
+		{"ScheduledMessageRoundTrip", testScheduledMessageRoundTrip},
+		{"ScheduledMessageNotFound", testScheduledMessageNotFound},
+		{"UpdateOnlyActive", testUpdateOnlyActive},
+		{"CancelDeletesAttachments", testCancelDeletesAttachments},
+		{"ActiveTitlesUnique", testActiveTitlesUnique},
+		{"ListQueries", testListQueries},
+		{"ClaimDue", testClaimDue},
+		{"ClaimExpiredLease", testClaimExpiredLease},
+		{"ConcurrentClaims", testConcurrentClaims},
+		{"Deliveries", testDeliveries},
+		{"GuildSettings", testGuildSettings},
+		{"Attachments", testAttachments},
+		{"Templates", testTemplates},
+		{"Drafts", testDrafts},
+		{"DraftAttachments", testDraftAttachments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, open(t))
+		})
+	}
+}
+
+// OpenSQLite opens a store on a new SQLite database in a temporary directory
+func OpenSQLite(t *testing.T) storage.Store {
+	t.Helper()
+	store, err := storage.Open("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("opening SQLite store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// OpenPostgres opens a store on the Postgres database in TEST_DATABASE_URL, skipping the test
+// when it isn't set. Every store gets its own schema, which is dropped when the test ends.
+func OpenPostgres(t *testing.T) storage.Store {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	// The driver is registered by package storage
+	admin, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("connecting to Postgres: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "storagetest_" + randomID(t)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+	})
+
+	separator := "?"
+	if strings.Contains(databaseURL, "?") {
+		separator = "&"
+	}
+	store, err := storage.Open(databaseURL + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatalf("opening Postgres store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func randomID(t *testing.T) string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// base is a reference time. Databases store times with different precision, so it is whole seconds.
+var base = time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+
+func newMessage(guildID, title string, scheduled time.Time) *models.ScheduledMessage {
+	return &models.ScheduledMessage{
+		Title:         title,
+		GuildID:       guildID,
+		UserID:        "user-1",
+		Message:       "Hello " + title,
+		ScheduledTime: scheduled,
+		ChannelID:     "channel-1",
+	}
+}
+
+func mustCreate(t *testing.T, store storage.Store, msg *models.ScheduledMessage) *models.ScheduledMessage {
+	t.Helper()
+	if err := store.ScheduledMessages().Create(msg); err != nil {
+		t.Fatalf("creating %q: %v", msg.Title, err)
+	}
+	return msg
+}
+
+func mustGet(t *testing.T, store storage.Store, id int64) *models.ScheduledMessage {
+	t.Helper()
+	msg, err := store.ScheduledMessages().GetByID(id)
+	if err != nil {
+		t.Fatalf("getting message %d: %v", id, err)
+	}
+	return msg
+}
+
+func titles(messages []*models.ScheduledMessage) string {
+	var names []string
+	for _, msg := range messages {
+		names = append(names, msg.Title)
+	}
+	return strings.Join(names, ",")
+}
+
+func testScheduledMessageRoundTrip(t *testing.T, store storage.Store) {
+	msg := newMessage("guild-1", "full", base)
+	msg.Embed = `{"title":"Embed"}`
+	msg.SetMentions([]string{"role-1", "role-2"}, []string{"user-2"}, models.MentionHere)
+	msg.Recurrence = "weekly"
+	msg.RecurrenceEnd = base.Add(30 * 24 * time.Hour)
+	msg.MaxOccurrences = 4
+	msg.Occurrences = 1
+	msg.LastError = "earlier error"
+	msg.SentAt = base.Add(-time.Hour)
+	msg.NextAttemptAt = base.Add(time.Minute)
+	mustCreate(t, store, msg)
+
+	if msg.ID == 0 {
+		t.Fatal("Create didn't set the ID")
+	}
+	if msg.Status != models.StatusPending {
+		t.Errorf("status defaults to %q, want pending", msg.Status)
+	}
+
+	got := mustGet(t, store, msg.ID)
+	want := *msg
+	checks := []struct {
+		field     string
+		got, want any
+	}{
+		{"Title", got.Title, want.Title},
+		{"GuildID", got.GuildID, want.GuildID},
+		{"RoleID", got.RoleID, want.RoleID},
+		{"UserID", got.UserID, want.UserID},
+		{"Message", got.Message, want.Message},
+		{"Embed", got.Embed, want.Embed},
+		{"ChannelID", got.ChannelID, want.ChannelID},
+		{"MentionUserIDs", got.MentionUserIDs, want.MentionUserIDs},
+		{"MassMention", got.MassMention, want.MassMention},
+		{"Recurrence", got.Recurrence, want.Recurrence},
+		{"MaxOccurrences", got.MaxOccurrences, want.MaxOccurrences},
+		{"Occurrences", got.Occurrences, want.Occurrences},
+		{"Status", got.Status, want.Status},
+		{"LastError", got.LastError, want.LastError},
+		{"ScheduledTime", got.ScheduledTime.UTC(), want.ScheduledTime.UTC()},
+		{"RecurrenceEnd", got.RecurrenceEnd.UTC(), want.RecurrenceEnd.UTC()},
+		{"SentAt", got.SentAt.UTC(), want.SentAt.UTC()},
+		{"NextAttemptAt", got.NextAttemptAt.UTC(), want.NextAttemptAt.UTC()},
+		{"LeaseExpiresAt", got.LeaseExpiresAt.IsZero(), true},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %v, want %v", check.field, check.got, check.want)
+		}
+	}
+}
+
+func testScheduledMessageNotFound(t *testing.T, store storage.Store) {
+	if _, err := store.ScheduledMessages().GetByID(12345); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetByID of a missing message returned %v, want ErrNotFound", err)
+	}
+}
+
+func testUpdateOnlyActive(t *testing.T, store storage.Store) {
+	msg := mustCreate(t, store, newMessage("guild-1", "update", base))
+
+	msg.Message = "changed"
+	if err := store.ScheduledMessages().Update(msg); err != nil {
+		t.Fatalf("updating pending message: %v", err)
+	}
+	if got := mustGet(t, store, msg.ID); got.Message != "changed" {
+		t.Errorf("message = %q after update, want changed", got.Message)
+	}
+
+	if err := store.ScheduledMessages().Cancel(msg); err != nil {
+		t.Fatalf("cancelling: %v", err)
+	}
+	if msg.Status != models.StatusCancelled {
+		t.Errorf("status = %q after Cancel, want cancelled", msg.Status)
+	}
+	if err := store.ScheduledMessages().Update(msg); !errors.Is(err, models.ErrNotActive) {
+		t.Errorf("updating cancelled message returned %v, want ErrNotActive", err)
+	}
+	if err := store.ScheduledMessages().Cancel(msg); !errors.Is(err, models.ErrNotActive) {
+		t.Errorf("cancelling twice returned %v, want ErrNotActive", err)
+	}
+}
+
+func testCancelDeletesAttachments(t *testing.T, store storage.Store) {
+	cancelled := mustCreate(t, store, newMessage("guild-1", "cancelled", base))
+	deleted := mustCreate(t, store, newMessage("guild-1", "deleted", base))
+	for _, msg := range []*models.ScheduledMessage{cancelled, deleted} {
+		err := store.Attachments().Create(&models.Attachment{ScheduledMessageID: msg.ID, Filename: "a.txt", Data: []byte("a")})
+		if err != nil {
+			t.Fatalf("creating attachment: %v", err)
+		}
+	}
+
+	if err := store.ScheduledMessages().Cancel(cancelled); err != nil {
+		t.Fatalf("cancelling: %v", err)
+	}
+	if err := store.ScheduledMessages().Delete(deleted); err != nil {
+		t.Fatalf("deleting: %v", err)
+	}
+
+	for _, msg := range []*models.ScheduledMessage{cancelled, deleted} {
+		attachments, err := store.Attachments().ListByMessage(msg.ID)
+		if err != nil {
+			t.Fatalf("listing attachments: %v", err)
+		}
+		if len(attachments) != 0 {
+			t.Errorf("%s message still has %d attachments", msg.Title, len(attachments))
+		}
+	}
+	if _, err := store.ScheduledMessages().GetByID(deleted.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetByID of a deleted message returned %v, want ErrNotFound", err)
+	}
+}
+
+func testActiveTitlesUnique(t *testing.T, store storage.Store) {
+	first := mustCreate(t, store, newMessage("guild-1", "title", base))
+	if err := store.ScheduledMessages().Create(newMessage("guild-1", "title", base)); err == nil {
+		t.Error("created two pending messages with the same title in a guild")
+	}
+	mustCreate(t, store, newMessage("guild-2", "title", base))
+
+	if err := store.ScheduledMessages().Cancel(first); err != nil {
+		t.Fatalf("cancelling: %v", err)
+	}
+	mustCreate(t, store, newMessage("guild-1", "title", base))
+}
+
+func testListQueries(t *testing.T, store storage.Store) {
+	now := base
+	mustCreate(t, store, newMessage("guild-1", "later", now.Add(2*time.Hour)))
+	mustCreate(t, store, newMessage("guild-1", "soon", now.Add(time.Hour)))
+	mustCreate(t, store, newMessage("guild-1", "past", now.Add(-time.Hour)))
+	cancelled := mustCreate(t, store, newMessage("guild-1", "cancelled", now.Add(time.Hour)))
+	mustCreate(t, store, newMessage("guild-2", "other", now.Add(time.Hour)))
+	if err := store.ScheduledMessages().Cancel(cancelled); err != nil {
+		t.Fatalf("cancelling: %v", err)
+	}
+
+	upcoming, err := store.ScheduledMessages().ListUpcomingByGuild("guild-1", now)
+	if err != nil {
+		t.Fatalf("ListUpcomingByGuild: %v", err)
+	}
+	if got := titles(upcoming); got != "soon,later" {
+		t.Errorf("ListUpcomingByGuild = %s, want soon,later", got)
+	}
+
+	all, err := store.ScheduledMessages().ListByGuild("guild-1")
+	if err != nil {
+		t.Fatalf("ListByGuild: %v", err)
+	}
+	if got := titles(all); got != "past,soon,cancelled,later" {
+		t.Errorf("ListByGuild = %s, want past,soon,cancelled,later", got)
+	}
+
+	active, err := store.ScheduledMessages().ListActive()
+	if err != nil {
+		t.Fatalf("ListActive: %v", err)
+	}
+	if got := titles(active); got != "past,soon,other,later" {
+		t.Errorf("ListActive = %s, want past,soon,other,later", got)
+	}
+}
+
+func testClaimDue(t *testing.T, store storage.Store) {
+	now := base
+	due := mustCreate(t, store, newMessage("guild-1", "due", now.Add(-time.Minute)))
+	mustCreate(t, store, newMessage("guild-1", "future", now.Add(time.Minute)))
+	backingOff := newMessage("guild-1", "backing-off", now.Add(-time.Minute))
+	backingOff.NextAttemptAt = now.Add(time.Minute)
+	mustCreate(t, store, backingOff)
+
+	claimed, err := store.ScheduledMessages().ClaimDue(now, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimDue: %v", err)
+	}
+	if got := titles(claimed); got != "due" {
+		t.Fatalf("claimed %s, want due", got)
+	}
+	msg := claimed[0]
+	if msg.Status != models.StatusSending || msg.LeaseToken == "" || msg.Attempts != 1 {
+		t.Errorf("claimed message has status %q, lease %q and %d attempts", msg.Status, msg.LeaseToken, msg.Attempts)
+	}
+
+	again, err := store.ScheduledMessages().ClaimDue(now, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimDue: %v", err)
+	}
+	if len(again) != 0 {
+		t.Errorf("claimed %s again while it was leased", titles(again))
+	}
+
+	msg.MarkSent("posted-1", now)
+	if err := store.ScheduledMessages().ReleaseLease(msg); err != nil {
+		t.Fatalf("ReleaseLease: %v", err)
+	}
+	got := mustGet(t, store, due.ID)
+	if got.Status != models.StatusSent || got.PostedMessageID != "posted-1" || got.LeaseToken != "" || !got.LeaseExpiresAt.IsZero() {
+		t.Errorf("after release: status %q, posted %q, lease %q until %v", got.Status, got.PostedMessageID, got.LeaseToken, got.LeaseExpiresAt)
+	}
+
+	// The backoff has passed
+	claimed, err = store.ScheduledMessages().ClaimDue(now.Add(2*time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimDue: %v", err)
+	}
+	if got := titles(claimed); got != "backing-off,future" {
+		t.Errorf("claimed %s, want backing-off,future", got)
+	}
+}
+
+func testClaimExpiredLease(t *testing.T, store storage.Store) {
+	now := base
+	mustCreate(t, store, newMessage("guild-1", "crashed", now.Add(-time.Minute)))
+
+	first, err := store.ScheduledMessages().ClaimDue(now, time.Minute, 10)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("ClaimDue returned %d messages, %v", len(first), err)
+	}
+
+	second, err := store.ScheduledMessages().ClaimDue(now.Add(2*time.Minute), time.Minute, 10)
+	if err != nil || len(second) != 1 {
+		t.Fatalf("ClaimDue after the lease expired returned %d messages, %v", len(second), err)
+	}
+	if second[0].Attempts != 2 {
+		t.Errorf("attempts = %d after reclaiming, want 2", second[0].Attempts)
+	}
+
+	first[0].MarkSent("posted-1", now)
+	if err := store.ScheduledMessages().ReleaseLease(first[0]); !errors.Is(err, models.ErrLeaseLost) {
+		t.Errorf("releasing an expired lease returned %v, want ErrLeaseLost", err)
+	}
+	second[0].MarkRetry("timeout", now.Add(3*time.Minute))
+	if err := store.ScheduledMessages().ReleaseLease(second[0]); err != nil {
+		t.Errorf("releasing the current lease: %v", err)
+	}
+}
+
+func testConcurrentClaims(t *testing.T, store storage.Store) {
+	const messages, workers = 20, 8
+	for i := range messages {
+		mustCreate(t, store, newMessage("guild-1", fmt.Sprintf("msg-%02d", i), base.Add(-time.Minute)))
+	}
+
+	var mu sync.Mutex
+	claims := map[int64]int{}
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := store.ScheduledMessages().ClaimDue(base, time.Minute, 5)
+			if err != nil {
+				t.Errorf("ClaimDue: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, msg := range claimed {
+				claims[msg.ID]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != messages {
+		t.Errorf("%d of %d messages were claimed", len(claims), messages)
+	}
+	for id, count := range claims {
+		if count > 1 {
+			t.Errorf("message %d was claimed %d times", id, count)
+		}
+	}
+}
+
+func testDeliveries(t *testing.T, store storage.Store) {
+	for i := range 5 {
+		delivery := &models.Delivery{
+			ScheduledMessageID: 1,
+			GuildID:            "guild-1",
+			ChannelID:          "channel-1",
+			Title:              fmt.Sprintf("delivery-%d", i),
+			PostedMessageID:    fmt.Sprintf("posted-%d", i),
+			Status:             models.StatusSent,
+			DeliveredAt:        base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := store.Deliveries().Create(delivery); err != nil {
+			t.Fatalf("creating delivery: %v", err)
+		}
+		if delivery.ID == 0 {
+			t.Fatal("Create didn't set the ID")
+		}
+	}
+	failed := &models.Delivery{ScheduledMessageID: 2, GuildID: "guild-2", Status: models.StatusFailed, Error: "boom", DeliveredAt: base}
+	if err := store.Deliveries().Create(failed); err != nil {
+		t.Fatalf("creating delivery: %v", err)
+	}
+
+	recent, err := store.Deliveries().ListRecentByGuild("guild-1", 3)
+	if err != nil {
+		t.Fatalf("ListRecentByGuild: %v", err)
+	}
+	var got []string
+	for _, delivery := range recent {
+		got = append(got, delivery.Title)
+	}
+	if strings.Join(got, ",") != "delivery-4,delivery-3,delivery-2" {
+		t.Errorf("ListRecentByGuild = %v, want the three newest first", got)
+	}
+
+	other, err := store.Deliveries().ListRecentByGuild("guild-2", 10)
+	if err != nil {
+		t.Fatalf("ListRecentByGuild: %v", err)
+	}
+	if len(other) != 1 || other[0].Status != models.StatusFailed || other[0].Error != "boom" {
+		t.Errorf("ListRecentByGuild of guild-2 = %+v", other)
+	}
+}
+
+func testGuildSettings(t *testing.T, store storage.Store) {
+	settings, err := store.GuildSettings().Get("guild-1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if settings.GuildID != "guild-1" || settings.Timezone != "" {
+		t.Errorf("defaults = %+v", settings)
+	}
+
+	for _, timezone := range []string{"Europe/Oslo", "UTC"} {
+		settings.Timezone = timezone
+		if err := store.GuildSettings().Save(settings); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		got, err := store.GuildSettings().Get("guild-1")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got.Timezone != timezone {
+			t.Errorf("timezone = %q after saving %q", got.Timezone, timezone)
+		}
+	}
+
+	settings.DebugLogging = true
+	if err := store.GuildSettings().Save(settings); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := store.GuildSettings().Save(&models.GuildSettings{GuildID: "guild-2", Timezone: "UTC"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	debug, err := store.GuildSettings().ListDebugLogging()
+	if err != nil {
+		t.Fatalf("ListDebugLogging: %v", err)
+	}
+	if strings.Join(debug, ",") != "guild-1" {
+		t.Errorf("ListDebugLogging = %v, want [guild-1]", debug)
+	}
+	if got, _ := store.GuildSettings().Get("guild-1"); got == nil || !got.DebugLogging || got.Timezone != "UTC" {
+		t.Errorf("Get after turning on debug logs = %+v", got)
+	}
+}
+
+func testAttachments(t *testing.T, store storage.Store) {
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i) // every byte value, including NUL
+	}
+
+	for _, name := range []string{"first.bin", "second.bin"} {
+		attachment := &models.Attachment{ScheduledMessageID: 7, Filename: name, ContentType: "application/octet-stream", Data: data}
+		if err := store.Attachments().Create(attachment); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if attachment.ID == 0 || attachment.CreatedAt.IsZero() {
+			t.Errorf("Create didn't set the ID and creation time: %+v", attachment)
+		}
+	}
+
+	attachments, err := store.Attachments().ListByMessage(7)
+	if err != nil {
+		t.Fatalf("ListByMessage: %v", err)
+	}
+	if len(attachments) != 2 || attachments[0].Filename != "first.bin" || attachments[1].Filename != "second.bin" {
+		t.Fatalf("ListByMessage returned %d attachments in the wrong order", len(attachments))
+	}
+	if !bytes.Equal(attachments[0].Data, data) || attachments[0].ContentType != "application/octet-stream" {
+		t.Error("attachment data didn't survive the round trip")
+	}
+
+	if err := store.Attachments().DeleteByMessage(7); err != nil {
+		t.Fatalf("DeleteByMessage: %v", err)
+	}
+	if attachments, _ := store.Attachments().ListByMessage(7); len(attachments) != 0 {
+		t.Errorf("%d attachments left after DeleteByMessage", len(attachments))
+	}
+}
+
+func testTemplates(t *testing.T, store storage.Store) {
+	for _, name := range []string{"weekly", "Announcement", "board"} {
+		template := &models.Template{GuildID: "guild-1", Name: name, Content: "Hi {{date}}", UserID: "user-1"}
+		if err := store.Templates().Create(template); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if err := store.Templates().Create(&models.Template{GuildID: "guild-1", Name: "WEEKLY", Content: "x", UserID: "user-1"}); err == nil {
+		t.Error("created two templates whose names only differ in case")
+	}
+	if err := store.Templates().Create(&models.Template{GuildID: "guild-2", Name: "weekly", Content: "x", UserID: "user-1"}); err != nil {
+		t.Errorf("template names should only be unique per guild: %v", err)
+	}
+
+	template, err := store.Templates().GetByName("guild-1", "WeEkLy")
+	if err != nil {
+		t.Fatalf("GetByName: %v", err)
+	}
+	if template.Name != "weekly" || template.Content != "Hi {{date}}" {
+		t.Errorf("GetByName = %+v", template)
+	}
+
+	list, err := store.Templates().ListByGuild("guild-1")
+	if err != nil {
+		t.Fatalf("ListByGuild: %v", err)
+	}
+	var names []string
+	for _, template := range list {
+		names = append(names, template.Name)
+	}
+	if strings.Join(names, ",") != "Announcement,board,weekly" {
+		t.Errorf("ListByGuild = %v, want ordered by name ignoring case", names)
+	}
+
+	if err := store.Templates().Delete(template); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Templates().GetByName("guild-1", "weekly"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetByName of a deleted template returned %v, want ErrNotFound", err)
+	}
+}
+
+func testDrafts(t *testing.T, store storage.Store) {
+	now := base
+	draft := &models.Draft{Flow: "schedule", GuildID: "guild-1", UserID: "user-1", State: []byte(`{"step":1}`), ExpiresAt: now.Add(time.Hour)}
+	if err := store.Drafts().Create(draft); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	other := &models.Draft{Flow: "schedule", GuildID: "guild-1", UserID: "user-1", State: []byte(`{}`), ExpiresAt: now.Add(-time.Minute)}
+	if err := store.Drafts().Create(other); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if draft.ID == "" || draft.ID == other.ID {
+		t.Fatalf("Create gave the IDs %q and %q, want unique IDs", draft.ID, other.ID)
+	}
+
+	got, err := store.Drafts().Get(draft.ID, now)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Flow != "schedule" || got.UserID != "user-1" || string(got.State) != `{"step":1}` || !got.ExpiresAt.Equal(draft.ExpiresAt) {
+		t.Errorf("Get = %+v", got)
+	}
+	if _, err := store.Drafts().Get(other.ID, now); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get of an expired draft returned %v, want ErrNotFound", err)
+	}
+
+	got.State = []byte(`{"step":2}`)
+	got.ExpiresAt = now.Add(2 * time.Hour)
+	if err := store.Drafts().Update(got); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := store.Drafts().Get(draft.ID, now.Add(90*time.Minute)); got == nil || string(got.State) != `{"step":2}` {
+		t.Errorf("Update did not save the state and expiry: %+v", got)
+	}
+
+	deleted, err := store.Drafts().DeleteExpired(now)
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpired removed %d drafts, want 1", deleted)
+	}
+
+	if err := store.Drafts().Delete(draft.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Drafts().Update(got); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Update of a deleted draft returned %v, want ErrNotFound", err)
+	}
+}
+
+func testDraftAttachments(t *testing.T, store storage.Store) {
+	now := base
+	var drafts []*models.Draft
+	for _, expires := range []time.Time{now.Add(time.Hour), now.Add(time.Hour), now.Add(-time.Minute)} {
+		draft := &models.Draft{Flow: "schedule", GuildID: "guild-1", UserID: "user-1", State: []byte(`{}`), ExpiresAt: expires}
+		if err := store.Drafts().Create(draft); err != nil {
+			t.Fatalf("Create draft: %v", err)
+		}
+		attachment := &models.Attachment{DraftID: draft.ID, Filename: "file.txt", Data: []byte("hello")}
+		if err := store.Attachments().Create(attachment); err != nil {
+			t.Fatalf("Create attachment: %v", err)
+		}
+		drafts = append(drafts, draft)
+	}
+	confirmed, cancelled, expired := drafts[0], drafts[1], drafts[2]
+
+	if attachments, _ := store.Attachments().ListByMessage(0); len(attachments) != 0 {
+		t.Errorf("ListByMessage(0) returned %d files of drafts", len(attachments))
+	}
+
+	if err := store.Attachments().MoveToMessage(confirmed.ID, 9); err != nil {
+		t.Fatalf("MoveToMessage: %v", err)
+	}
+	if err := store.Attachments().MoveToMessage(confirmed.ID, 9); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("MoveToMessage of a draft without files returned %v, want ErrNotFound", err)
+	}
+	// The file stays with the message when its draft is done
+	if err := store.Drafts().Delete(confirmed.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	attachments, err := store.Attachments().ListByMessage(9)
+	if err != nil {
+		t.Fatalf("ListByMessage: %v", err)
+	}
+	if len(attachments) != 1 || attachments[0].DraftID != "" || string(attachments[0].Data) != "hello" {
+		t.Fatalf("ListByMessage after MoveToMessage = %+v", attachments)
+	}
+
+	// The files of cancelled and expired drafts are deleted with them
+	if err := store.Drafts().Delete(cancelled.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Drafts().DeleteExpired(now); err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	for _, draft := range []*models.Draft{cancelled, expired} {
+		if err := store.Attachments().MoveToMessage(draft.ID, 10); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("the file of a deleted draft is still stored, MoveToMessage returned %v", err)
+		}
+	}
+}
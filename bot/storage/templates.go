@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/betauia/BetaBot.go/bot/models"
+)
+
+type templateRepo struct {
+	s *sqlStore
+}
+
+// Names are compared with lower() rather than SQLite's COLLATE NOCASE so the queries work on
+// Postgres too. Both only fold ASCII letters, matching the unique index of each dialect.
+
+func (r templateRepo) Create(t *models.Template) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO message_templates (guild_id, name, content, user_id, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	id, err := r.s.insert(query, t.GuildID, t.Name, t.Content, t.UserID, t.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	t.ID = id
+	return nil
+}
+
+func (r templateRepo) Delete(t *models.Template) error {
+	_, err := r.s.exec(r.s.db, "DELETE FROM message_templates WHERE id = ?", t.ID)
+	return err
+}
+
+func (r templateRepo) GetByName(guildID, name string) (*models.Template, error) {
+	query := `SELECT id, guild_id, name, content, user_id, created_at
+        FROM message_templates
+        WHERE guild_id = ? AND lower(name) = lower(?)`
+	t, err := scanTemplate(r.s.queryRow(r.s.db, query, guildID, name))
+	return t, notFound(err)
+}
+
+func (r templateRepo) ListByGuild(guildID string) ([]*models.Template, error) {
+	query := `SELECT id, guild_id, name, content, user_id, created_at
+        FROM message_templates
+        WHERE guild_id = ?
+        ORDER BY lower(name) ASC`
+	return queryAll(r.s, scanTemplate, query, guildID)
+}
+
+func scanTemplate(row rowScanner) (*models.Template, error) {
+	t := &models.Template{}
+	err := row.Scan(&t.ID, &t.GuildID, &t.Name, &t.Content, &t.UserID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
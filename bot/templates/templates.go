@@ -0,0 +1,155 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// placeholder matches {{name}} and {{name:argument}}
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}:\s]+)\s*(?::([^{}]*))?\}\}`)
+
+// ErrGuildUnavailable is returned by Expand when the guild couldn't be loaded. Unlike other
+// errors it doesn't mean the text is wrong, so expanding it again later may succeed.
+var ErrGuildUnavailable = errors.New("server information is not available")
+
+// Variables describes the placeholders that can be used in scheduled messages and templates
+var Variables = []struct{ Name, Description string }{
+	{"{{date}}", "date of the post, e.g. 31.12.2025"},
+	{"{{time}}", "time of the post, e.g. 18:00"},
+	{"{{weekday}}", "weekday of the post, e.g. Tuesday"},
+	{"{{occurrence}}", "how many times the message has been posted, including this post"},
+	{"{{member_count}}", "number of members in the server"},
+	{"{{role:Name}}", "the role called Name, shown without pinging it"},
+	{"{{channel:name}}", "a link to the channel called name"},
+}
+
+// Data holds the values placeholders are expanded with
+type Data struct {
+	Time       time.Time // scheduled time of the post, in the guild's timezone
+	Occurrence int       // 1 for the first post of a message
+
+	// Guild loads the guild with its roles, channels and member count. It is only
+	// called for messages that use one of those placeholders.
+	Guild func() (*discordgo.Guild, error)
+}
+
+// Validate checks that the text only uses known placeholders, with an argument where one is needed.
+// Whether referenced roles and channels exist is checked by Expand.
+func Validate(text string) error {
+	for _, match := range placeholder.FindAllStringSubmatch(text, -1) {
+		if err := checkPlaceholder(strings.ToLower(match[1]), match[2]); err != nil {
+			return err
+		}
+	}
+	if rest := placeholder.ReplaceAllString(text, ""); strings.Contains(rest, "{{") {
+		return fmt.Errorf("unclosed or malformed placeholder near %q", excerpt(rest[strings.Index(rest, "{{"):]))
+	}
+	return nil
+}
+
+func checkPlaceholder(name, argument string) error {
+	switch name {
+	case "date", "time", "weekday", "occurrence", "member_count":
+		if argument != "" {
+			return fmt.Errorf("{{%s}} doesn't take an argument", name)
+		}
+	case "role", "channel":
+		if strings.TrimSpace(argument) == "" {
+			return fmt.Errorf("{{%s:...}} needs a name, e.g. {{%s:%s}}", name, name, map[string]string{"role": "Board", "channel": "general"}[name])
+		}
+	default:
+		return fmt.Errorf("unknown variable {{%s}}", name)
+	}
+	return nil
+}
+
+// Expand replaces the placeholders in text. It fails on unknown placeholders and on roles
+// or channels that don't exist in the guild, so a broken message isn't posted.
+func Expand(text string, data *Data) (string, error) {
+	if err := Validate(text); err != nil {
+		return "", err
+	}
+
+	var guild *discordgo.Guild
+	loadGuild := func() (*discordgo.Guild, error) {
+		if guild != nil {
+			return guild, nil
+		}
+		if data.Guild == nil {
+			return nil, errors.New("no server to load")
+		}
+		var err error
+		guild, err = data.Guild()
+		return guild, err
+	}
+
+	var expandErr error
+	expanded := placeholder.ReplaceAllStringFunc(text, func(match string) string {
+		if expandErr != nil {
+			return match
+		}
+		parts := placeholder.FindStringSubmatch(match)
+		value, err := expandPlaceholder(strings.ToLower(parts[1]), strings.TrimSpace(parts[2]), data, loadGuild)
+		if err != nil {
+			expandErr = err
+		}
+		return value
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+func expandPlaceholder(name, argument string, data *Data, loadGuild func() (*discordgo.Guild, error)) (string, error) {
+	switch name {
+	case "date":
+		return data.Time.Format("02.01.2006"), nil
+	case "time":
+		return data.Time.Format("15:04"), nil
+	case "weekday":
+		return data.Time.Weekday().String(), nil
+	case "occurrence":
+		return strconv.Itoa(data.Occurrence), nil
+	}
+
+	guild, err := loadGuild()
+	if err != nil {
+		return "", fmt.Errorf("%w for {{%s}}: %v", ErrGuildUnavailable, name, err)
+	}
+
+	switch name {
+	case "member_count":
+		return strconv.Itoa(guild.MemberCount), nil
+	case "role":
+		for _, role := range guild.Roles {
+			if strings.EqualFold(role.Name, argument) {
+				return "<@&" + role.ID + ">", nil
+			}
+		}
+		return "", fmt.Errorf("there is no role called %q", argument)
+	case "channel":
+		argument = strings.TrimPrefix(argument, "#")
+		for _, channel := range guild.Channels {
+			if strings.EqualFold(channel.Name, argument) {
+				return "<#" + channel.ID + ">", nil
+			}
+		}
+		return "", fmt.Errorf("there is no channel called %q", argument)
+	}
+	return "", fmt.Errorf("unknown variable {{%s}}", name)
+}
+
+// excerpt shortens text for use in error messages
+func excerpt(text string) string {
+	if runes := []rune(text); len(runes) > 20 {
+		return string(runes[:20]) + "…"
+	}
+	return text
+}
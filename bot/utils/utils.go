@@ -1,9 +0,0 @@
-package utils
-
-import "log"
-
-func CheckNilErr(err error) {
-	if err != nil {
-		log.Fatalf("Invalid bot parameters: %v", err)
-	}
-}
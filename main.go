@@ -1,29 +1,52 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"os"
 
 	bot "github.com/betauia/BetaBot.go/bot"
-	"github.com/joho/godotenv"
+	"github.com/betauia/BetaBot.go/bot/config"
+	"github.com/betauia/BetaBot.go/bot/dateparse"
+	"github.com/betauia/BetaBot.go/bot/logging"
 )
 
 func main() {
-	// Load the .env file using the godotenv package
-	err := godotenv.Load()
+	// Load the configuration from the config file, the environment, .env and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	if cfg.PrintConfig {
+		cfg.Print(os.Stdout)
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
-	// Initialize the database
-	bot.InitDatabase()
+	// From here on everything is logged in the configured format
+	if err := logging.Setup(cfg.LogFormat, cfg.LogLevel); err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
+	}
 
-	// Retrieve the bot token from the environment variable
-	bot.BotToken = os.Getenv("BOT_TOKEN")
-	if bot.BotToken == "" {
-		log.Fatal("BOT_TOKEN environment variable is not set")
+	if err := dateparse.SetDefaultTimezone(cfg.DefaultTimezone); err != nil {
+		slog.Error("Failed to set default timezone", slog.String("timezone", cfg.DefaultTimezone), logging.Err(err))
+		os.Exit(1)
 	}
 
+	// Initialize the database, SQLite unless the database URL points at Postgres
+	bot.InitDatabase(cfg.DatabaseURL)
+
 	// Run the bot
-	bot.Run()
+	bot.Run(cfg)
 }